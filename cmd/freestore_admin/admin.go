@@ -1,26 +1,55 @@
 // Command freestore_admin runs a sample controller of servers.
+//
+// To create a cluster, start its servers with -wait-init and run:
+//
+//	freestore_admin -init 10.1.1.2:5000,10.1.1.3:5000,10.1.1.4:5000
+//
+// Every member saves the same genesis view and starts serving. Running it
+// again is safe; servers initialized with another genesis view are reported.
 package main
 
 import (
 	"flag"
 	"log"
+	"os"
+	"strings"
 
 	"github.com/mateusbraga/freestore/pkg/comm"
+	"github.com/mateusbraga/freestore/pkg/server"
 	"github.com/mateusbraga/freestore/pkg/view"
 )
 
 func main() {
 	leave := flag.String("leave", "", "Process to leave the system")
+	instances := flag.String("instances", "", "Process to ask how many per-view protocol instances it keeps")
+	initMembers := flag.String("init", "", "Comma separated addresses of the members of a new cluster, which are given its genesis view")
 	//initialProcess := flag.String("initial", "", "Process to ask for the initial view")
 	flag.Parse()
 
 	if *leave != "" {
-		leavingProcess := view.Process{*leave}
+		leavingProcess := view.Process{Addr: *leave}
 
 		log.Printf("Asking %v to leave\n", leavingProcess)
 
 		sendLeaveProcess(leavingProcess)
 	}
+
+	if *instances != "" {
+		printProtocolInstances(view.Process{Addr: *instances})
+	}
+
+	if *initMembers != "" {
+		var addrs []string
+		for _, addr := range strings.Split(*initMembers, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+
+		if !initCluster(addrs) {
+			os.Exit(1)
+		}
+	}
 }
 
 func sendLeaveProcess(process view.Process) {
@@ -30,3 +59,61 @@ func sendLeaveProcess(process view.Process) {
 		return
 	}
 }
+
+func printProtocolInstances(process view.Process) {
+	var instances server.ProtocolInstances
+	err := comm.SendRPCRequest(process, "AdminService.ProtocolInstances", struct{}{}, &instances)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	log.Printf("%v keeps %+v\n", process, instances)
+}
+
+// initCluster gives the servers at addrs the genesis view whose members are
+// those servers, and checks that all of them agree on it. It returns false if
+// some server was not initialized with it.
+func initCluster(addrs []string) bool {
+	// the servers know their node ID and incarnation, which are part of the genesis view
+	var members []view.Process
+	for _, addr := range addrs {
+		var process view.Process
+		if err := comm.SendRPCRequest(view.Process{Addr: addr}, "AdminService.Process", struct{}{}, &process); err != nil {
+			log.Printf("FATAL: Failed to get the process at %v: %v\n", addr, err)
+			return false
+		}
+		members = append(members, process)
+	}
+	genesis := view.NewWithProcesses(members...)
+
+	log.Printf("Initializing %v with genesis view %v, ref: %v\n", addrs, genesis, genesis.ViewRef)
+	for _, process := range members {
+		if err := comm.SendRPCRequest(process, "AdminService.Init", server.InitMsg{Genesis: genesis}, &struct{}{}); err != nil {
+			log.Printf("WARN: Failed to initialize %v: %v\n", process, err)
+		}
+	}
+
+	ok := true
+	for _, process := range members {
+		var serverGenesis *view.View
+		if err := comm.SendRPCRequest(process, "AdminService.Genesis", struct{}{}, &serverGenesis); err != nil {
+			log.Printf("WARN: Failed to get the genesis view of %v: %v\n", process, err)
+			ok = false
+			continue
+		}
+		switch {
+		case serverGenesis == nil:
+			log.Printf("WARN: %v is not initialized\n", process)
+			ok = false
+		case serverGenesis.ViewRef != genesis.ViewRef:
+			log.Printf("WARN: Genesis mismatch: %v has genesis view %v, ref: %v\n", process, serverGenesis, serverGenesis.ViewRef)
+			ok = false
+		}
+	}
+
+	if ok {
+		log.Printf("All %v members have genesis view ref %v\n", len(members), genesis.ViewRef)
+	}
+	return ok
+}
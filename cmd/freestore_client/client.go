@@ -9,20 +9,29 @@ import (
 	"log"
 	"math"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/mateusbraga/freestore/pkg/client"
+	"github.com/mateusbraga/freestore/pkg/comm"
+	"github.com/mateusbraga/freestore/pkg/config"
 	"github.com/mateusbraga/freestore/pkg/view"
 )
 
 func main() {
 	nTotal := flag.Uint64("n", math.MaxUint64, "number of times to perform a read and write operation")
-	initialProcess := flag.String("initial", "", "Process to ask for the initial view")
-	retryProcess := flag.String("retry", "", "Process to ask for a newer view")
+	configPath := flag.String("config", os.Getenv(config.EnvPrefix+"CONFIG"), "Configuration file, whose seeds are the initial view")
+	initialProcess := flag.String("initial", "", "Process to ask for the initial view, instead of using the seeds")
+	retryProcess := flag.String("retry", "", "Process to ask for a newer view, instead of the seeds")
 	flag.Parse()
 
-	freestoreClient, err := client.New(getInitialViewFunc(*initialProcess), getFurtherViewsFunc(*retryProcess))
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalln("FATAL:", err)
+	}
+	cfg.SetupLogging()
+	comm.DefaultTransport = comm.NewTCPTransportWithConfig(comm.TCPConfig{CallTimeout: time.Duration(cfg.CallTimeout), HeartbeatPeriod: time.Duration(cfg.HeartbeatPeriod)})
+
+	freestoreClient, err := client.New(getInitialViewFunc(cfg, *initialProcess), getFurtherViewsFunc(cfg, *retryProcess))
 	if err != nil {
 		log.Fatalln("FATAL:", err)
 	}
@@ -33,14 +42,14 @@ func main() {
 		finalValue, err = freestoreClient.Read()
 		endRead := time.Now()
 		if err != nil {
-			log.Fatalln(err)
+			log.Fatalln("FATAL:", err)
 		}
 
 		startWrite := time.Now()
 		err = freestoreClient.Write(finalValue)
 		endWrite := time.Now()
 		if err != nil {
-			log.Fatalln(err)
+			log.Fatalln("FATAL:", err)
 		}
 
 		if i%1000 == 0 {
@@ -51,42 +60,29 @@ func main() {
 	}
 }
 
-func getInitialViewFunc(initialProc string) func() (*view.View, error) {
+func getInitialViewFunc(cfg config.Config, initialProc string) func() (*view.View, error) {
 	return func() (*view.View, error) {
-		hostname, err := os.Hostname()
-		if err != nil {
-			log.Panicln(err)
+		if initialProc == "" {
+			return cfg.SeedView(), nil
 		}
 
-		if initialProc == "" {
-			switch {
-			case strings.Contains(hostname, "node-"): // emulab.net
-				updates := []view.Update{view.Update{Type: view.Join, Process: view.Process{"10.1.1.2:5000"}},
-					view.Update{Type: view.Join, Process: view.Process{"10.1.1.3:5000"}},
-					view.Update{Type: view.Join, Process: view.Process{"10.1.1.4:5000"}},
-				}
-				return view.NewWithUpdates(updates...), nil
-			default:
-				updates := []view.Update{view.Update{Type: view.Join, Process: view.Process{"[::]:5000"}},
-					view.Update{Type: view.Join, Process: view.Process{"[::]:5001"}},
-					view.Update{Type: view.Join, Process: view.Process{"[::]:5002"}},
-				}
-				return view.NewWithUpdates(updates...), nil
-			}
-		} else {
-			process := view.Process{initialProc}
-			initialView, err := client.GetCurrentView(process)
-			if err != nil {
-				log.Fatalf("Failed to get current view from process %v: %v\n", process, err)
-			}
-			return initialView, nil
+		process := view.Process{Addr: initialProc}
+		initialView, err := client.GetCurrentView(process)
+		if err != nil {
+			log.Fatalf("FATAL: Failed to get current view from process %v: %v\n", process, err)
 		}
+		return initialView, nil
 	}
 }
 
-func getFurtherViewsFunc(retryProcess string) func() (*view.View, error) {
+func getFurtherViewsFunc(cfg config.Config, retryProcess string) func() (*view.View, error) {
+	processes := cfg.SeedProcesses()
+	if retryProcess != "" {
+		processes = []view.Process{view.Process{Addr: retryProcess}}
+	}
+
 	return func() (*view.View, error) {
-		view, err := client.GetCurrentView(view.Process{retryProcess})
+		view, err := client.GetCurrentView(processes...)
 		if err != nil {
 			return nil, err
 		}
@@ -1,4 +1,10 @@
 // Command freestore_measures runs a sample client that measures freestore's latency and throughput.
+//
+// With -reconfiguration, it performs operations while servers join or leave
+// the system, and reports the longest time an operation waited, which is how
+// long the operations were unavailable during a reconfiguration. Run it with
+// the servers answering reads during reconfigurations and without, to
+// compare both.
 package main
 
 import (
@@ -10,10 +16,11 @@ import (
 	"log"
 	"os"
 	"runtime"
-	"strings"
 	"time"
 
 	"github.com/mateusbraga/freestore/pkg/client"
+	"github.com/mateusbraga/freestore/pkg/comm"
+	"github.com/mateusbraga/freestore/pkg/config"
 	"github.com/mateusbraga/freestore/pkg/view"
 	"github.com/mateusbraga/gostat"
 )
@@ -24,16 +31,19 @@ var (
 	numberOfOperations = flag.Int("n", 1000, "Number of operations to perform (latency measurement)")
 	measureLatency     = flag.Bool("latency", false, "Client will measure latency")
 	measureThroughput  = flag.Bool("throughput", false, "Client will measure throughput")
+	measureReconfig    = flag.Bool("reconfiguration", false, "Client will measure the longest wait of an operation while the servers reconfigure, for the given duration")
 	totalDuration      = flag.Duration("duration", 10*time.Second, "Duration to run operations (throughput measurement)")
+	numberOfWorkers    = flag.Int("workers", 1, "Number of goroutines performing operations concurrently on the same client (throughput measurement)")
 	resultFile         = flag.String("o", "/proj/freestore/results.txt", "Result file filename")
-	initialProcess     = flag.String("initial", "", "Process to ask for the initial view")
-	retryProcess       = flag.String("retry", "", "Process to ask for a newer view")
+	configPath         = flag.String("config", os.Getenv(config.EnvPrefix+"CONFIG"), "Configuration file, whose seeds are the initial view")
+	initialProcess     = flag.String("initial", "", "Process to ask for the initial view, instead of using the seeds")
+	retryProcess       = flag.String("retry", "", "Process to ask for a newer view, instead of the seeds")
 )
 
 var (
+	cfg             config.Config
 	latencies       []int64
 	ops             int
-	stopChan        <-chan time.Time
 	freestoreClient *client.Client
 )
 
@@ -46,17 +56,24 @@ func main() {
 	flag.Parse()
 
 	var err error
-	freestoreClient, err = client.New(getInitialView, getFurtherViews)
+	cfg, err = config.Load(*configPath)
 	if err != nil {
 		log.Fatalln("FATAL:", err)
 	}
+	cfg.SetupLogging()
+	comm.DefaultTransport = comm.NewTCPTransportWithConfig(comm.TCPConfig{CallTimeout: time.Duration(cfg.CallTimeout), HeartbeatPeriod: time.Duration(cfg.HeartbeatPeriod)})
 
-	stopChan = time.After(*totalDuration)
+	freestoreClient, err = client.New(getInitialView, getFurtherViews)
+	if err != nil {
+		log.Fatalln("FATAL:", err)
+	}
 
 	if *measureLatency {
 		latency()
 	} else if *measureThroughput {
 		throughput()
+	} else if *measureReconfig {
+		reconfiguration()
 	} else {
 		latencyAndThroughput()
 	}
@@ -79,7 +96,7 @@ func latencyAndThroughput() {
 			err := freestoreClient.Write(data)
 			timeAfter := time.Now()
 			if err != nil {
-				log.Fatalln(err)
+				log.Fatalln("FATAL:", err)
 			}
 
 			latencies = append(latencies, timeAfter.Sub(timeBefore).Nanoseconds())
@@ -87,7 +104,7 @@ func latencyAndThroughput() {
 	} else {
 		err := freestoreClient.Write(data)
 		if err != nil {
-			log.Fatalln("ERROR initial write:", err)
+			log.Fatalln("FATAL: Initial write:", err)
 		}
 
 		for ops = 0; ops < *numberOfOperations; ops++ {
@@ -95,7 +112,7 @@ func latencyAndThroughput() {
 			_, err = freestoreClient.Read()
 			timeAfter := time.Now()
 			if err != nil {
-				log.Fatalln(err)
+				log.Fatalln("FATAL:", err)
 			}
 
 			latencies = append(latencies, timeAfter.Sub(timeBefore).Nanoseconds())
@@ -138,7 +155,7 @@ func saveResults(latenciesMean int64, latenciesStandardDeviation int64, opsPerSe
 	defer w.Flush()
 
 	if _, err = w.Write([]byte(fmt.Sprintf("%v %v %v %v %v %v %v\n", latenciesMean, latenciesStandardDeviation, opsPerSecond, opsTotal, operation, *size, time.Now().Format(time.RFC3339)))); err != nil {
-		log.Fatalln(err)
+		log.Fatalln("FATAL:", err)
 	}
 }
 
@@ -157,7 +174,7 @@ func latency() {
 			err := freestoreClient.Write(data)
 			timeAfter := time.Now()
 			if err != nil {
-				log.Fatalln(err)
+				log.Fatalln("FATAL:", err)
 			}
 
 			latencies = append(latencies, timeAfter.Sub(timeBefore).Nanoseconds())
@@ -165,7 +182,7 @@ func latency() {
 	} else {
 		err := freestoreClient.Write(data)
 		if err != nil {
-			log.Fatalln("Initial write:", err)
+			log.Fatalln("FATAL: Initial write:", err)
 		}
 
 		for ops = 0; ops < *numberOfOperations; ops++ {
@@ -173,7 +190,7 @@ func latency() {
 			_, err = freestoreClient.Read()
 			timeAfter := time.Now()
 			if err != nil {
-				log.Fatalln(err)
+				log.Fatalln("FATAL:", err)
 			}
 
 			latencies = append(latencies, timeAfter.Sub(timeBefore).Nanoseconds())
@@ -193,51 +210,101 @@ func latency() {
 
 func throughput() {
 	if *isWrite {
-		log.Printf("Measuring throughput of write operations with size %vB for %v\n", *size, *totalDuration)
+		log.Printf("Measuring throughput of write operations with size %vB for %v with %v workers\n", *size, *totalDuration, *numberOfWorkers)
 	} else {
-		log.Printf("Measuring throughput of read operations with size %vB for %v\n", *size, *totalDuration)
+		log.Printf("Measuring throughput of read operations with size %vB for %v with %v workers\n", *size, *totalDuration, *numberOfWorkers)
 	}
 
 	data := createFakeData()
 
-	if *isWrite {
-		for ; ; ops++ {
-			err := freestoreClient.Write(data)
-			if err != nil {
-				log.Fatalln(err)
-			}
+	if !*isWrite {
+		err := freestoreClient.Write(data)
+		if err != nil {
+			log.Fatalln("FATAL: Initial write:", err)
+		}
+	}
 
-			select {
-			case <-stopChan:
-				goto RESULT
-			default:
+	// all workers share freestoreClient
+	deadline := time.Now().Add(*totalDuration)
+	opsChan := make(chan int, *numberOfWorkers)
+	for i := 0; i < *numberOfWorkers; i++ {
+		go func() {
+			workerOps := 0
+			for ; time.Now().Before(deadline); workerOps++ {
+				var err error
+				if *isWrite {
+					err = freestoreClient.Write(data)
+				} else {
+					_, err = freestoreClient.Read()
+				}
+				if err != nil {
+					log.Fatalln("FATAL:", err)
+				}
 			}
-		}
+			opsChan <- workerOps
+		}()
+	}
+
+	for i := 0; i < *numberOfWorkers; i++ {
+		ops += <-opsChan
+	}
+
+	opsPerSecond := float64(ops) / totalDuration.Seconds()
+
+	fmt.Printf("Result: throughput %v [%v in %v with %v workers]\n", int64(opsPerSecond), ops, totalDuration.Seconds(), *numberOfWorkers)
+	saveResults(0, 0, int64(opsPerSecond), ops)
+}
+
+// reconfiguration performs operations for totalDuration and reports the
+// longest latency, which is saved as the latency of the results. Servers are
+// expected to join or leave the system meanwhile.
+func reconfiguration() {
+	if *isWrite {
+		log.Printf("Measuring the longest wait of write operations with size %vB during reconfigurations for %v\n", *size, *totalDuration)
 	} else {
+		log.Printf("Measuring the longest wait of read operations with size %vB during reconfigurations for %v\n", *size, *totalDuration)
+	}
+
+	data := createFakeData()
+
+	if !*isWrite {
 		err := freestoreClient.Write(data)
 		if err != nil {
-			log.Fatalln("Initial write:", err)
+			log.Fatalln("FATAL: Initial write:", err)
 		}
+	}
 
-		for ; ; ops++ {
+	var longestLatency time.Duration
+	var longestLatencyStart time.Time
+	deadline := time.Now().Add(*totalDuration)
+	for ops = 0; time.Now().Before(deadline); ops++ {
+		var err error
+		timeBefore := time.Now()
+		if *isWrite {
+			err = freestoreClient.Write(data)
+		} else {
 			_, err = freestoreClient.Read()
-			if err != nil {
-				log.Fatalln(err)
-			}
+		}
+		timeAfter := time.Now()
+		if err != nil {
+			log.Fatalln("FATAL:", err)
+		}
 
-			select {
-			case <-stopChan:
-				goto RESULT
-			default:
-			}
+		latency := timeAfter.Sub(timeBefore)
+		latencies = append(latencies, latency.Nanoseconds())
+		if latency > longestLatency {
+			longestLatency = latency
+			longestLatencyStart = timeBefore
 		}
 	}
 
-RESULT:
+	latenciesMean := gostat.Mean(latencies)
+	latenciesMeanDuration := time.Duration(int64(latenciesMean))
+
 	opsPerSecond := float64(ops) / totalDuration.Seconds()
 
-	fmt.Printf("Result: throughput %v [%v in %v]\n", int64(opsPerSecond), ops, totalDuration.Seconds())
-	saveResults(0, 0, int64(opsPerSecond), ops)
+	fmt.Printf("Result: longest wait %v at %v - latency %v - throughput %v [%v in %v]\n", longestLatency, longestLatencyStart.Format(time.RFC3339Nano), latenciesMeanDuration, int64(opsPerSecond), ops, totalDuration.Seconds())
+	saveResults(longestLatency.Nanoseconds(), 0, int64(opsPerSecond), ops)
 }
 
 func createFakeData() []byte {
@@ -245,44 +312,31 @@ func createFakeData() []byte {
 
 	n, err := io.ReadFull(rand.Reader, data)
 	if n != len(data) || err != nil {
-		log.Fatalln("error to generate data:", err)
+		log.Fatalln("FATAL: error to generate data:", err)
 	}
 	return data
 }
 
 func getInitialView() (*view.View, error) {
-	hostname, err := os.Hostname()
-	if err != nil {
-		log.Panicln(err)
+	if *initialProcess == "" {
+		return cfg.SeedView(), nil
 	}
 
-	if *initialProcess == "" {
-		switch {
-		case strings.Contains(hostname, "node-"): // emulab.net
-			updates := []view.Update{view.Update{Type: view.Join, Process: view.Process{"10.1.1.2:5000"}},
-				view.Update{Type: view.Join, Process: view.Process{"10.1.1.3:5000"}},
-				view.Update{Type: view.Join, Process: view.Process{"10.1.1.4:5000"}},
-			}
-			return view.NewWithUpdates(updates...), nil
-		default:
-			updates := []view.Update{view.Update{Type: view.Join, Process: view.Process{"[::]:5000"}},
-				view.Update{Type: view.Join, Process: view.Process{"[::]:5001"}},
-				view.Update{Type: view.Join, Process: view.Process{"[::]:5002"}},
-			}
-			return view.NewWithUpdates(updates...), nil
-		}
-	} else {
-		process := view.Process{*initialProcess}
-		initialView, err := client.GetCurrentView(process)
-		if err != nil {
-			log.Fatalf("Failed to get current view from process %v: %v\n", process, err)
-		}
-		return initialView, nil
+	process := view.Process{Addr: *initialProcess}
+	initialView, err := client.GetCurrentView(process)
+	if err != nil {
+		log.Fatalf("FATAL: Failed to get current view from process %v: %v\n", process, err)
 	}
+	return initialView, nil
 }
 
 func getFurtherViews() (*view.View, error) {
-	view, err := client.GetCurrentView(view.Process{*retryProcess})
+	processes := cfg.SeedProcesses()
+	if *retryProcess != "" {
+		processes = []view.Process{view.Process{Addr: *retryProcess}}
+	}
+
+	view, err := client.GetCurrentView(processes...)
 	if err != nil {
 		return nil, err
 	}
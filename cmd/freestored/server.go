@@ -4,13 +4,18 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
-	"strings"
+	"syscall"
+	"time"
 
 	"github.com/mateusbraga/freestore/pkg/client"
+	"github.com/mateusbraga/freestore/pkg/comm"
+	"github.com/mateusbraga/freestore/pkg/config"
 	"github.com/mateusbraga/freestore/pkg/server"
 	"github.com/mateusbraga/freestore/pkg/view"
 
@@ -24,74 +29,144 @@ func init() {
 }
 
 func main() {
+	configPath := flag.String("config", os.Getenv(config.EnvPrefix+"CONFIG"), "Configuration file. The flags below override it")
 	useConsensus := flag.Bool("consensus", false, "Set consensus to use consensus on reconfiguration")
-	bindAddr := flag.String("bind", "[::]:5000", "Set this process address")
-	initialProcess := flag.String("initial", "", "Process to ask for the initial view")
+	readsDuringReconfiguration := flag.Bool("reads-during-reconfiguration", false, "Answer reads while the state is transferred to a new view, instead of waiting for it to be installed")
+	bindAddr := flag.String("bind", "[::]:5000", "Set the address this process listens to")
+	advertiseAddr := flag.String("advertise", "", "Set the address the other processes use to reach this process, which identifies it in the view. If empty, it is the bind address")
+	nodeID := flag.String("node-id", "", "Set the name of this node in the view, which is kept across address changes. If empty, the node is named by its address")
+	initialProcess := flag.String("initial", "", "Process to ask for the initial view, instead of the seeds")
+	waitForInit := flag.Bool("wait-init", false, "Wait for freestore_admin init to give the genesis view of a new cluster, instead of starting with the seeds")
+	dataDir := flag.String("data-dir", "", "Directory to keep the server state across restarts. If empty, state is kept in memory only")
+	maxRequests := flag.Int("max-requests", 0, "Maximum number of register requests handled at a time. Zero means no limit")
+	removeSuspectedAfter := flag.Duration("remove-suspected-after", 0, "Remove from the view the servers suspected to have crashed for this long. Zero disables the removal")
+	minViewSize := flag.Int("min-view-size", 3, "Least number of members a removal of a suspected server may leave in the view")
+	leaveTimeout := flag.Duration("leave-timeout", 30*time.Second, "How long to wait for the view without this process on interrupt before closing anyway")
 	flag.Parse()
 
-	initialView := getInitialView(*bindAddr, *initialProcess)
+	// the flags are applied before the configuration is validated, as they may complete it
+	cfg, err := config.LoadUnvalidated(*configPath)
+	if err != nil {
+		log.Fatalln("FATAL:", err)
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "consensus":
+			cfg.UseConsensus = *useConsensus
+		case "reads-during-reconfiguration":
+			cfg.ReadsDuringReconfiguration = *readsDuringReconfiguration
+		case "bind":
+			cfg.BindAddr = *bindAddr
+		case "advertise":
+			cfg.AdvertiseAddr = *advertiseAddr
+		case "node-id":
+			cfg.NodeID = *nodeID
+		case "wait-init":
+			cfg.WaitForInit = *waitForInit
+		case "data-dir":
+			cfg.DataDir = *dataDir
+		case "max-requests":
+			cfg.MaxConcurrentRequests = *maxRequests
+		case "remove-suspected-after":
+			cfg.RemoveSuspectedAfter = config.Duration(*removeSuspectedAfter)
+		case "min-view-size":
+			cfg.MinViewSize = *minViewSize
+		case "leave-timeout":
+			cfg.LeaveTimeout = config.Duration(*leaveTimeout)
+		}
+	})
+	if err := cfg.Validate(); err != nil {
+		log.Fatalln("FATAL:", err)
+	}
+	cfg.SetupLogging()
+
+	transport := comm.NewTCPTransportWithConfig(comm.TCPConfig{CallTimeout: time.Duration(cfg.CallTimeout), HeartbeatPeriod: time.Duration(cfg.HeartbeatPeriod)})
+	comm.DefaultTransport = transport
+
+	var initialView *view.View
+	var fetched bool
+	if !cfg.WaitForInit {
+		initialView, fetched = getInitialView(cfg, *initialProcess)
+	}
 
 	go func() {
 		log.Println("Running pprof:", http.ListenAndServe("localhost:6060", nil))
 	}()
 
-	freestoreServer, err := server.New(*bindAddr, initialView, *useConsensus)
-	if err != nil {
-		log.Fatalln(err)
-	}
-	freestoreServer.Run()
-}
+	freestoreServer, err := server.New(server.Config{
+		BindAddr:              cfg.BindAddr,
+		AdvertiseAddr:         cfg.AdvertiseAddr,
+		NodeID:                cfg.NodeID,
+		InitialView:           initialView,
+		UseConsensus:          cfg.UseConsensus,
+		DataDir:               cfg.DataDir,
+		Transport:             transport,
+		ReconfigurationPeriod: time.Duration(cfg.ReconfigurationPeriod),
+
+		MaxConcurrentRequests: cfg.MaxConcurrentRequests,
+		RemoveSuspectedAfter:  time.Duration(cfg.RemoveSuspectedAfter),
+		MinViewSize:           cfg.MinViewSize,
 
-func getInitialView(bindAddr string, initialProc string) *view.View {
-	hostname, err := os.Hostname()
+		ReadsDuringReconfiguration: cfg.ReadsDuringReconfiguration,
+
+		// a view fetched from the running servers may still have this node from an execution whose state is lost
+		Rejoin: fetched,
+	})
 	if err != nil {
-		log.Fatalln(err)
+		log.Fatalln("FATAL:", err)
 	}
 
-	if initialProc == "" {
-		var updates []view.Update
-		switch {
-		case strings.Contains(hostname, "node-"): // emulab.net
-			updates = []view.Update{
-				view.Update{Type: view.Join, Process: view.Process{"10.1.1.2:5000"}},
-				view.Update{Type: view.Join, Process: view.Process{"10.1.1.3:5000"}},
-				view.Update{Type: view.Join, Process: view.Process{"10.1.1.4:5000"}},
-			}
-		default:
-			updates = []view.Update{
-				view.Update{Type: view.Join, Process: view.Process{"[::]:5000"}},
-				view.Update{Type: view.Join, Process: view.Process{"[::]:5001"}},
-				view.Update{Type: view.Join, Process: view.Process{"[::]:5002"}},
+	// Leave the view on interrupt; a second interrupt or the timeout closes the server anyway
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.LeaveTimeout))
+		defer cancel()
+		go func() {
+			select {
+			case <-signals:
+				cancel()
+			case <-ctx.Done():
 			}
-		}
-		hardCodedView := view.NewWithUpdates(updates...)
+		}()
 
-		// don't ask for current view if the process is in the hardCodedView
-		if hardCodedView.HasMember(view.Process{bindAddr}) {
-			return hardCodedView
+		if err := freestoreServer.Leave(ctx); err != nil {
+			log.Println("WARN: Failed to leave the view:", err)
+			freestoreServer.Close()
 		}
+	}()
 
-		for _, u := range updates {
-			v, err := client.GetCurrentView(u.Process)
-			if err != nil {
-				log.Println("Failed to get current view from process %v: %v\n", u.Process, err)
-				continue
-			}
+	freestoreServer.Run()
+}
 
-			if v.Equal(hardCodedView) {
-				return hardCodedView
-			} else {
-				return v
-			}
-		}
-		log.Fatalln("Fatal: None of the hard coded initial processes are responsive.")
-		return nil
-	} else {
-		process := view.Process{initialProc}
+// getInitialView returns the view this server starts with. A seed starts with
+// the view of the seeds; the other servers ask the seeds, or initialProc if
+// set, for the current view. fetched tells if the view is the current view of
+// the running servers.
+func getInitialView(cfg config.Config, initialProc string) (initialView *view.View, fetched bool) {
+	if initialProc != "" {
+		process := view.Process{Addr: initialProc}
 		initialView, err := client.GetCurrentView(process)
 		if err != nil {
-			log.Fatalf("Failed to get current view from process %v: %v\n", process, err)
+			log.Fatalf("FATAL: Failed to get current view from process %v: %v\n", process, err)
 		}
-		return initialView
+		return initialView, true
+	}
+
+	seedView := cfg.SeedView()
+	// don't ask for current view if the process is a seed
+	if seedView.HasMember(view.Process{Addr: cfg.Process().Addr}) {
+		return seedView, false
+	}
+
+	initialView, err := client.GetCurrentView(cfg.SeedProcesses()...)
+	if err != nil {
+		log.Fatalln("FATAL: None of the seeds are responsive:", err)
+	}
+	if initialView.Equal(seedView) {
+		return seedView, false
 	}
+	return initialView, true
 }
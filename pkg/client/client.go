@@ -4,28 +4,65 @@ Package client implements a Freestore client
 package client
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/comm"
+	"github.com/mateusbraga/freestore/pkg/timestamp"
 	"github.com/mateusbraga/freestore/pkg/view"
 )
 
-// Client represents a freestore client. Client may be used by multiple goroutines simultaneously.
+// Client represents a freestore client. Client may be used by multiple
+// goroutines simultaneously, and their operations run concurrently.
 type Client struct {
-	view                *view.View
+	// writeSeq numbers the writes of this client, so concurrent writes over the same value get different timestamps. It is accessed atomically and kept first for its alignment.
+	writeSeq uint64
+
 	getFurtherViewsFunc GetViewFunc
 
-    // mutex protects err and num2ndPhareReads
+	// transport carries the client's requests to the servers
+	transport comm.Transport
+
+	// writerID identifies this client in the timestamps of the values it writes
+	writerID string
+
+	// mutex protects view, num2ndPhaseReads and the recovery state below. It is never held during a quorum round trip.
 	mutex sync.Mutex
-    // Count number of 2nd phase reads this client performed
+	// the most updated view this client knows
+	view *view.View
+	// Count number of 2nd phase reads this client performed
 	num2ndPhaseReads int
-	// wheter this client noticed any errors
-	err   error
+
+	recoveryPolicy RecoveryPolicy
+	// last quorum error this client noticed, nil if the client is healthy
+	err error
+	// backoff is the time to wait after the last failure; the system is tried again at retryTime
+	backoff   time.Duration
+	retryTime time.Time
 }
 
 type GetViewFunc func() (*view.View, error)
 
-// New returns a new Client with initialView.
+// New returns a new Client with initialView that talks to the servers through comm.DefaultTransport.
 func New(getInitialViewFunc GetViewFunc, getFurtherViewsFunc GetViewFunc) (*Client, error) {
-	newClient := &Client{}
+	return NewWithTransport(comm.DefaultTransport, getInitialViewFunc, getFurtherViewsFunc)
+}
+
+// NewWithTransport is like New, but the client talks to the servers through transport.
+func NewWithTransport(transport comm.Transport, getInitialViewFunc GetViewFunc, getFurtherViewsFunc GetViewFunc) (*Client, error) {
+	newClient := &Client{transport: transport, recoveryPolicy: DefaultRecoveryPolicy}
+
+	writerID, err := newWriterID()
+	if err != nil {
+		return nil, err
+	}
+	newClient.writerID = writerID
 
 	initialView, err := getInitialViewFunc()
 	if err != nil {
@@ -38,83 +75,170 @@ func New(getInitialViewFunc GetViewFunc, getFurtherViewsFunc GetViewFunc) (*Clie
 	return newClient, nil
 }
 
-// Write v to the system's register.
-func (cl *Client) Write(v interface{}) error {
+// WriterID returns the identity this client puts in the timestamps of the values it writes. It is unique and does not change during the life of the client.
+func (cl *Client) WriterID() string {
+	return cl.writerID
+}
+
+// Num2ndPhaseReads returns how many reads of this client found different values in the quorum and had to write back the most recent one.
+func (cl *Client) Num2ndPhaseReads() int {
 	cl.mutex.Lock()
 	defer cl.mutex.Unlock()
+	return cl.num2ndPhaseReads
+}
+
+func newWriterID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// Write v to the system's default register (the one with the empty key).
+func (cl *Client) Write(v interface{}) error {
+	return cl.WriteKeyContext(context.Background(), "", v)
+}
+
+// Read executes the quorum read protocol on the system's default register (the one with the empty key).
+func (cl *Client) Read() (interface{}, error) {
+	return cl.ReadKeyContext(context.Background(), "")
+}
+
+// WriteContext is like Write, but it gives up when ctx is done. See WriteKeyContext.
+func (cl *Client) WriteContext(ctx context.Context, v interface{}) error {
+	return cl.WriteKeyContext(ctx, "", v)
+}
+
+// ReadContext is like Read, but it gives up when ctx is done. See ReadKeyContext.
+func (cl *Client) ReadContext(ctx context.Context) (interface{}, error) {
+	return cl.ReadKeyContext(ctx, "")
+}
+
+// WriteKey writes v to the register named key.
+func (cl *Client) WriteKey(key string, v interface{}) error {
+	return cl.WriteKeyContext(context.Background(), key, v)
+}
 
-	// Stop using the system if it is known to be broken (fail-fast)
-	if cl.err != nil {
-		return cl.err
+// ReadKey executes the quorum read protocol on the register named key.
+func (cl *Client) ReadKey(key string) (interface{}, error) {
+	return cl.ReadKeyContext(context.Background(), key)
+}
+
+// WriteKeyContext writes v to the register named key. If ctx is done before
+// the write completes, it returns a comm.CanceledError wrapping ctx.Err(). The
+// write may or may not have taken effect in that case, but the client keeps
+// working normally. If a majority of the view fails, it returns a
+// comm.NoQuorumError (see RecoveryPolicy).
+func (cl *Client) WriteKeyContext(ctx context.Context, key string, v interface{}) error {
+	// Don't use the system while it is known to be broken
+	if err := cl.checkHealth(); err != nil {
+		return err
 	}
 
-	readValue, err := cl.readQuorum()
+	readValue, err := cl.readQuorum(ctx, key)
 	if err != nil {
 		// Special case: diffResultsErr
 		if err == diffResultsErr {
 			// Do nothing - we will write a new value anyway
+		} else if errors.Is(err, comm.ErrCanceled) {
+			return err
 		} else {
-			cl.err = err
+			cl.recordFailure(err)
 			return err
 		}
 	}
 
 	writeMsg := RegisterMsg{}
+	writeMsg.Key = key
 	writeMsg.Value = v
-	//TODO append writer id to timestamp
-	writeMsg.Timestamp = readValue.Timestamp + 1
-	writeMsg.ViewRef = cl.view.ViewRef
+	writeMsg.Timestamp = readValue.Timestamp.Next(cl.writerID, atomic.AddUint64(&cl.writeSeq, 1))
 
-	err = cl.writeQuorum(writeMsg)
+	err = cl.writeQuorum(ctx, writeMsg)
 	if err != nil {
-		cl.err = err
+		if !errors.Is(err, comm.ErrCanceled) {
+			cl.recordFailure(err)
+		}
 		return err
 	}
 
+	cl.recordSuccess()
 	return nil
 }
 
-// Read executes the quorum read protocol.
-func (cl *Client) Read() (interface{}, error) {
-	cl.mutex.Lock()
-	defer cl.mutex.Unlock()
-
-	// Stop using the system if it is known to be broken (fail-fast)
-	if cl.err != nil {
-		return nil, cl.err
+// ReadKeyContext executes the quorum read protocol on the register named key.
+// If ctx is done before the read completes, it returns a comm.CanceledError
+// wrapping ctx.Err(), and the client keeps working normally. If a majority of
+// the view fails, it returns a comm.NoQuorumError (see RecoveryPolicy).
+func (cl *Client) ReadKeyContext(ctx context.Context, key string) (interface{}, error) {
+	// Don't use the system while it is known to be broken
+	if err := cl.checkHealth(); err != nil {
+		return nil, err
 	}
 
-	readMsg, err := cl.readQuorum()
+	readMsg, err := cl.readQuorum(ctx, key)
 	if err != nil {
 		// Special case: diffResultsErr
 		if err == diffResultsErr {
-			return cl.read2ndPhase(readMsg)
+			return cl.read2ndPhase(ctx, readMsg)
+		} else if errors.Is(err, comm.ErrCanceled) {
+			return nil, err
 		} else {
-			cl.err = err
+			cl.recordFailure(err)
 			return nil, err
 		}
 	}
 
+	cl.recordSuccess()
 	return readMsg.Value, nil
 }
 
-func (cl *Client) read2ndPhase(readMsg RegisterMsg) (interface{}, error) {
-    cl.num2ndPhaseReads++
-	err := cl.writeQuorum(readMsg)
+func (cl *Client) read2ndPhase(ctx context.Context, readMsg RegisterMsg) (interface{}, error) {
+	cl.mutex.Lock()
+	cl.num2ndPhaseReads++
+	cl.mutex.Unlock()
+
+	err := cl.writeQuorum(ctx, readMsg)
 	if err != nil {
-		cl.err = err
+		if !errors.Is(err, comm.ErrCanceled) {
+			cl.recordFailure(err)
+		}
 		return nil, err
 	}
 
+	cl.recordSuccess()
 	return readMsg.Value, nil
 }
 
+// getView returns the most updated view known by this client.
+func (cl *Client) getView() *view.View {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	return cl.view
+}
+
+// updateView makes newView the client's view if it is more updated than the
+// current one. Concurrent operations may learn about new views in any order,
+// so a view is never replaced by a less updated one.
+func (cl *Client) updateView(newView *view.View) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	if newView.MoreUpdatedThan(cl.view) {
+		cl.view = newView
+	}
+}
+
 // Used in RPC Read and Write
 type RegisterMsg struct {
-	Value     interface{}  // Value of the register
-	Timestamp int          // Timestamp of the register
-	ViewRef   view.ViewRef // Current client's view
-	Err       error        // Any RPC or register service errors
+	Key       string              // Name of the register
+	Value     interface{}         // Value of the register
+	Timestamp timestamp.Timestamp // Timestamp of the register
+	ViewRef   view.ViewRef        // Current client's view
+	Err       error               // Any RPC or register service errors
+
+	PendingView  *view.View // View the server was installing when it answered the read, if any
+	NotInstalled bool       // The server did not install the view of the read yet
 
 	process view.Process
 }
@@ -8,20 +8,22 @@ import (
 	"log"
 	"testing"
 
+	"github.com/mateusbraga/freestore/pkg/timestamp"
 	"github.com/mateusbraga/freestore/pkg/view"
 )
 
 func TestRegisterMsgGob(t *testing.T) {
-	updates := []view.Update{view.Update{Type: view.Join, Process: view.Process{"10.1.1.2:5000"}},
-		view.Update{Type: view.Join, Process: view.Process{"10.1.1.3:5000"}},
-		view.Update{Type: view.Join, Process: view.Process{"10.1.1.4:5000"}},
+	updates := []view.Update{view.Update{Type: view.Join, Process: view.Process{Addr: "10.1.1.2:5000"}},
+		view.Update{Type: view.Join, Process: view.Process{Addr: "10.1.1.3:5000"}},
+		view.Update{Type: view.Join, Process: view.Process{Addr: "10.1.1.4:5000"}},
 	}
 
 	v1 := view.NewWithUpdates(updates...)
 
 	msg := RegisterMsg{}
+	msg.Key = "key"
 	msg.Value = createFakeData(512)
-	msg.Timestamp = 1
+	msg.Timestamp = timestamp.Timestamp{Counter: 1, WriterID: "writer"}
 	msg.ViewRef = view.ViewToViewRef(v1)
 
 	buf := new(bytes.Buffer)
@@ -41,15 +43,15 @@ func TestRegisterMsgGob(t *testing.T) {
 }
 
 func BenchmarkRegisterMsgGobEncode(b *testing.B) {
-	updates := []view.Update{view.Update{Type: view.Join, Process: view.Process{"10.1.1.2:5000"}},
-		view.Update{Type: view.Join, Process: view.Process{"10.1.1.3:5000"}},
-		view.Update{Type: view.Join, Process: view.Process{"10.1.1.4:5000"}},
+	updates := []view.Update{view.Update{Type: view.Join, Process: view.Process{Addr: "10.1.1.2:5000"}},
+		view.Update{Type: view.Join, Process: view.Process{Addr: "10.1.1.3:5000"}},
+		view.Update{Type: view.Join, Process: view.Process{Addr: "10.1.1.4:5000"}},
 	}
 	v1 := view.NewWithUpdates(updates...)
 
 	msg := RegisterMsg{}
 	msg.Value = createFakeData(512)
-	msg.Timestamp = 1
+	msg.Timestamp = timestamp.Timestamp{Counter: 1, WriterID: "writer"}
 	msg.ViewRef = view.ViewToViewRef(v1)
 
 	buf := new(bytes.Buffer)
@@ -65,15 +67,15 @@ func BenchmarkRegisterMsgGobEncode(b *testing.B) {
 }
 
 func BenchmarkRegisterMsgGobDecode(b *testing.B) {
-	updates := []view.Update{view.Update{Type: view.Join, Process: view.Process{"10.1.1.2:5000"}},
-		view.Update{Type: view.Join, Process: view.Process{"10.1.1.3:5000"}},
-		view.Update{Type: view.Join, Process: view.Process{"10.1.1.4:5000"}},
+	updates := []view.Update{view.Update{Type: view.Join, Process: view.Process{Addr: "10.1.1.2:5000"}},
+		view.Update{Type: view.Join, Process: view.Process{Addr: "10.1.1.3:5000"}},
+		view.Update{Type: view.Join, Process: view.Process{Addr: "10.1.1.4:5000"}},
 	}
 	v1 := view.NewWithUpdates(updates...)
 
 	msg := RegisterMsg{}
 	msg.Value = createFakeData(512)
-	msg.Timestamp = 1
+	msg.Timestamp = timestamp.Timestamp{Counter: 1, WriterID: "writer"}
 	msg.ViewRef = view.ViewToViewRef(v1)
 
 	buf := new(bytes.Buffer)
@@ -107,3 +109,40 @@ func createFakeData(size int) []byte {
 	}
 	return data
 }
+
+func TestWriterIDsAreUnique(t *testing.T) {
+	getView := func() (*view.View, error) { return view.NewWithProcesses(view.Process{Addr: "[::]:5000"}), nil }
+
+	client1, err := New(getView, getView)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client2, err := New(getView, getView)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if client1.WriterID() == "" || client1.WriterID() == client2.WriterID() {
+		t.Errorf("clients should have unique writer ids, got %q and %q", client1.WriterID(), client2.WriterID())
+	}
+}
+
+func TestUpdateViewKeepsMostUpdated(t *testing.T) {
+	v1 := view.NewWithProcesses(view.Process{Addr: "[::]:5000"})
+	v2 := v1.NewCopyWithUpdates(view.Update{Type: view.Join, Process: view.Process{Addr: "[::]:5001"}})
+	v3 := v2.NewCopyWithUpdates(view.Update{Type: view.Join, Process: view.Process{Addr: "[::]:5002"}})
+
+	getView := func() (*view.View, error) { return v1, nil }
+	cl, err := New(getView, getView)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// concurrent operations may report the views out of order
+	cl.updateView(v3)
+	cl.updateView(v2)
+
+	if !cl.getView().Equal(v3) {
+		t.Errorf("expected client view %v, got %v", v3, cl.getView())
+	}
+}
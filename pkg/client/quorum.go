@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"log"
 
@@ -13,44 +14,58 @@ import (
 // read protocol.
 var diffResultsErr = errors.New("Read Divergence")
 
-// readQuorum asks for the register value of all members from the current view.
-// It returns the most recent value after it receives answers from a majority.
-// If the client's view needs to be updated, it will update it and retry.  If
-// values returned by the processes differ, it will return diffResultsErr.
-func (thisClient *Client) readQuorum() (RegisterMsg, error) {
-	destinationView:= thisClient.view
+// viewChangedErr is returned by readPendingView if a server has a view more
+// updated than the one being read, which the client's view was updated to.
+var viewChangedErr = errors.New("View changed")
 
-	// Send write request to all
+// readQuorum asks for the value of the register named key of all members from
+// the current view.  It returns the most recent value after it receives
+// answers from a majority.  If the client's view needs to be updated, it will
+// update it and retry.  If values returned by the processes differ, it will
+// return diffResultsErr. If a majority fails, it returns a comm.NoQuorumError,
+// and if ctx is done first, it returns a comm.CanceledError.
+func (thisClient *Client) readQuorum(ctx context.Context, key string) (RegisterMsg, error) {
+	destinationView := thisClient.getView()
+
+	readMsg := RegisterMsg{Key: key, ViewRef: destinationView.ViewRef}
+
+	// Send read request to all
 	resultChan := make(chan RegisterMsg, destinationView.NumberOfMembers())
-	go broadcastRead(destinationView, destinationView.ViewRef, resultChan)
+	go thisClient.broadcastRead(ctx, destinationView, readMsg, resultChan)
 
 	// Wait for quorum
-	var failedTotal int
+	var failed []view.Process
+	var cause error
 	var resultArray []RegisterMsg
 	var finalValue RegisterMsg
 	for {
-		receivedValue := <-resultChan
+		receivedValue, err := receiveResult(ctx, resultChan)
+		if err != nil {
+			return RegisterMsg{}, err
+		}
 
 		// count success or fail
 		if receivedValue.Err != nil {
-			if oldViewError, ok := receivedValue.Err.(*view.OldViewError); ok {
+			var oldViewError *view.OldViewError
+			if errors.As(receivedValue.Err, &oldViewError) {
 				if oldViewError.NewView.MoreUpdatedThan(destinationView) {
 					log.Println("View updated during read quorum by process", receivedValue.process)
-					thisClient.view = oldViewError.NewView
-					return thisClient.readQuorum()
+					thisClient.updateView(oldViewError.NewView)
+					return thisClient.readQuorum(ctx, key)
 				}
 				// oldViewError.NewView is actually not more updated than current view, try again
-				go sendRead(receivedValue.process, destinationView.ViewRef, resultChan)
+				go thisClient.sendRead(ctx, receivedValue.process, &readMsg, resultChan)
 				log.Printf("Process %v has old view %v\n", receivedValue.process, oldViewError.NewView)
 				continue
 			}
 
 			//log.Println("+1 error to read:", err)
-			failedTotal++
+			failed = append(failed, receivedValue.process)
+			cause = receivedValue.Err
 		} else {
 			resultArray = append(resultArray, receivedValue)
 
-			if receivedValue.Timestamp > finalValue.Timestamp {
+			if finalValue.Timestamp.Less(receivedValue.Timestamp) {
 				finalValue = receivedValue
 			}
 		}
@@ -58,18 +73,36 @@ func (thisClient *Client) readQuorum() (RegisterMsg, error) {
 		// check conditions. this is done here to handle when a quorum leaves
 		// the system. In this case, most processes would fail but we should
 		// wait for one (or all) that will tell the client the updated view.
-		everyProcessReturned := len(resultArray)+failedTotal == destinationView.NumberOfMembers()
-		systemFailed := everyProcessReturned && failedTotal > destinationView.NumberOfToleratedFaults()
+		everyProcessReturned := len(resultArray)+len(failed) == destinationView.NumberOfMembers()
+		systemFailed := everyProcessReturned && len(failed) > destinationView.NumberOfToleratedFaults()
 		if systemFailed {
 			// maybe all processes from the view left, try to get the new view
-			if thisClient.couldGetNewView() {
-				return thisClient.readQuorum()
+			if thisClient.couldGetNewView(destinationView) {
+				return thisClient.readQuorum(ctx, key)
 			} else {
-				return RegisterMsg{}, errors.New("Failed to get read quorum")
+				return RegisterMsg{}, comm.NoQuorumError{Op: "read", View: destinationView, Failed: failed, Cause: cause.Error()}
 			}
 		}
 
 		if len(resultArray) == destinationView.QuorumSize() {
+			// Servers transferring their state to a new view answered, and a write may have completed in it
+			for _, pendingView := range pendingViews(resultArray, destinationView) {
+				pendingResults, err := thisClient.readPendingView(ctx, key, pendingView)
+				if err == viewChangedErr {
+					return thisClient.readQuorum(ctx, key)
+				} else if err != nil {
+					return RegisterMsg{}, err
+				}
+
+				for _, receivedValue := range pendingResults {
+					resultArray = append(resultArray, receivedValue)
+					if finalValue.Timestamp.Less(receivedValue.Timestamp) {
+						finalValue = receivedValue
+					}
+				}
+			}
+			finalValue.Key = key
+
 			// Look for divergence on values received
 			for _, val := range resultArray {
 				if finalValue.Timestamp != val.Timestamp {
@@ -81,41 +114,119 @@ func (thisClient *Client) readQuorum() (RegisterMsg, error) {
 	}
 }
 
+// pendingViews returns the views more updated than destinationView that the
+// servers were installing when they answered the results of a read.
+func pendingViews(results []RegisterMsg, destinationView *view.View) []*view.View {
+	var views []*view.View
+OuterLoop:
+	for _, result := range results {
+		if result.PendingView == nil || !result.PendingView.MoreUpdatedThan(destinationView) {
+			continue
+		}
+		for _, v := range views {
+			if v.Equal(result.PendingView) {
+				continue OuterLoop
+			}
+		}
+		views = append(views, result.PendingView)
+	}
+	return views
+}
+
+// readPendingView asks for the value of the register named key of a quorum
+// of pendingView, a view the servers were installing when they answered a
+// read. It returns the values of the servers that installed pendingView: the
+// others did no write in it. If a server has a view more updated than
+// pendingView, it updates the client's view and returns viewChangedErr.
+func (thisClient *Client) readPendingView(ctx context.Context, key string, pendingView *view.View) ([]RegisterMsg, error) {
+	readMsg := RegisterMsg{Key: key, ViewRef: pendingView.ViewRef}
+
+	resultChan := make(chan RegisterMsg, pendingView.NumberOfMembers())
+	go thisClient.broadcastRead(ctx, pendingView, readMsg, resultChan)
+
+	var failed []view.Process
+	var cause error
+	var answers int
+	var installedResults []RegisterMsg
+	for {
+		receivedValue, err := receiveResult(ctx, resultChan)
+		if err != nil {
+			return nil, err
+		}
+
+		if receivedValue.Err != nil {
+			var oldViewError *view.OldViewError
+			if !errors.As(receivedValue.Err, &oldViewError) {
+				failed = append(failed, receivedValue.process)
+				cause = receivedValue.Err
+			} else if oldViewError.NewView.MoreUpdatedThan(pendingView) {
+				thisClient.updateView(oldViewError.NewView)
+				return nil, viewChangedErr
+			} else {
+				// the process did not install pendingView
+				answers++
+			}
+		} else {
+			answers++
+			if !receivedValue.NotInstalled {
+				installedResults = append(installedResults, receivedValue)
+			}
+		}
+
+		if len(failed) > pendingView.NumberOfToleratedFaults() {
+			return nil, comm.NoQuorumError{Op: "read", View: pendingView, Failed: failed, Cause: cause.Error()}
+		}
+		if answers == pendingView.QuorumSize() {
+			if len(installedResults) != 0 {
+				thisClient.updateView(pendingView)
+			}
+			return installedResults, nil
+		}
+	}
+}
+
 // writeQuorum tries to write the value on writeMsg in the register of all
 // processes on client's current view. It returns when it gets confirmation
 // from a majority.  If the client's view needs to be updated, it will update
-// it and retry.
-func (thisClient *Client) writeQuorum(writeMsg RegisterMsg) error {
-	destinationView:= thisClient.view
+// it and retry. If a majority fails, it returns a comm.NoQuorumError, and if
+// ctx is done first, it returns a comm.CanceledError.
+func (thisClient *Client) writeQuorum(ctx context.Context, writeMsg RegisterMsg) error {
+	destinationView := thisClient.getView()
 
 	writeMsg.ViewRef = destinationView.ViewRef
 
 	// Send write request to all
 	resultChan := make(chan RegisterMsg, destinationView.NumberOfMembers())
-	go broadcastWrite(destinationView, writeMsg, resultChan)
+	go thisClient.broadcastWrite(ctx, destinationView, writeMsg, resultChan)
 
 	// Wait for quorum
 	var successTotal int
-	var failedTotal int
+	var failed []view.Process
+	var cause error
 	for {
-		receivedValue := <-resultChan
+		receivedValue, err := receiveResult(ctx, resultChan)
+		if err != nil {
+			return err
+		}
 
 		// count success or fail
 		if receivedValue.Err != nil {
-			if oldViewError, ok := receivedValue.Err.(*view.OldViewError); ok {
+			var oldViewError *view.OldViewError
+			if errors.As(receivedValue.Err, &oldViewError) {
 				if oldViewError.NewView.MoreUpdatedThan(destinationView) {
 					log.Println("View updated during write quorum by process", receivedValue.process)
-					thisClient.view = oldViewError.NewView
-					return thisClient.writeQuorum(writeMsg)
+					thisClient.updateView(oldViewError.NewView)
+					return thisClient.writeQuorum(ctx, writeMsg)
 				}
 				// oldViewError.NewView is actually not more updated than current view, try again
-				go sendWrite(receivedValue.process, &writeMsg, resultChan)
+				go thisClient.sendWrite(ctx, receivedValue.process, &writeMsg, resultChan)
 				log.Printf("Process %v has old view %v\n", receivedValue.process, oldViewError.NewView)
 				continue
 			}
 
 			//log.Println("+1 error to write:", err)
-			failedTotal++
+			failed = append(failed, receivedValue.process)
+			cause = receivedValue.Err
 		} else {
 			successTotal++
 		}
@@ -123,14 +234,14 @@ func (thisClient *Client) writeQuorum(writeMsg RegisterMsg) error {
 		// check conditions. this is done here to handle when a quorum leaves
 		// the system. In this case, most processes would fail but we should
 		// wait for one (or all) that will tell the client the updated view.
-		everyProcessReturned := successTotal+failedTotal == destinationView.NumberOfMembers()
-		systemFailed := everyProcessReturned && failedTotal > destinationView.NumberOfToleratedFaults()
+		everyProcessReturned := successTotal+len(failed) == destinationView.NumberOfMembers()
+		systemFailed := everyProcessReturned && len(failed) > destinationView.NumberOfToleratedFaults()
 		if systemFailed {
 			// maybe all processes from the view left, try to get the new view
-			if thisClient.couldGetNewView() {
-				return thisClient.writeQuorum(writeMsg)
+			if thisClient.couldGetNewView(destinationView) {
+				return thisClient.writeQuorum(ctx, writeMsg)
 			} else {
-				return errors.New("Failed to get write quorum")
+				return comm.NoQuorumError{Op: "write", View: destinationView, Failed: failed, Cause: cause.Error()}
 			}
 		}
 
@@ -140,50 +251,63 @@ func (thisClient *Client) writeQuorum(writeMsg RegisterMsg) error {
 	}
 }
 
-func (thisClient *Client) couldGetNewView() bool {
+// couldGetNewView tries to get a view more updated than oldView. The new view
+// may also have been found by a concurrent operation of this client.
+func (thisClient *Client) couldGetNewView(oldView *view.View) bool {
 	v, err := thisClient.getFurtherViewsFunc()
-	if err != nil {
-		return false
+	if err == nil {
+		thisClient.updateView(v)
 	}
 
-	if !v.MoreUpdatedThan(thisClient.view) {
-		return false
-	}
+	return thisClient.getView().MoreUpdatedThan(oldView)
+}
 
-	thisClient.view = v
-	return true
+// receiveResult waits for the next answer on resultChan. It returns a
+// comm.CanceledError if ctx is done, even if answers are available: the
+// answers that arrive after ctx is done are failures caused by the
+// cancellation and must not be counted as failures of the processes.
+func receiveResult(ctx context.Context, resultChan chan RegisterMsg) (RegisterMsg, error) {
+	select {
+	case receivedValue := <-resultChan:
+		if err := ctx.Err(); err != nil {
+			return RegisterMsg{}, comm.CanceledError{Err: err}
+		}
+		return receivedValue, nil
+	case <-ctx.Done():
+		return RegisterMsg{}, comm.CanceledError{Err: ctx.Err()}
+	}
 }
 
-func sendRead(process view.Process, viewRef view.ViewRef, resultChan chan RegisterMsg) {
+func (thisClient *Client) sendRead(ctx context.Context, process view.Process, readMsg *RegisterMsg, resultChan chan RegisterMsg) {
 	var result RegisterMsg
-	err := comm.SendRPCRequest(process, "RegisterService.Read", viewRef, &result)
+	err := thisClient.transport.Call(ctx, process, "RegisterService.Read", readMsg, &result)
 	if err != nil {
-		resultChan <- RegisterMsg{Err: err}
+		resultChan <- RegisterMsg{Err: err, process: process}
 		return
 	}
 	result.process = process
 	resultChan <- result
 }
 
-func broadcastRead(destinationView *view.View, viewRef view.ViewRef, resultChan chan RegisterMsg) {
+func (thisClient *Client) broadcastRead(ctx context.Context, destinationView *view.View, readMsg RegisterMsg, resultChan chan RegisterMsg) {
 	for _, process := range destinationView.GetMembers() {
-		go sendRead(process, viewRef, resultChan)
+		go thisClient.sendRead(ctx, process, &readMsg, resultChan)
 	}
 }
 
-func sendWrite(process view.Process, writeMsg *RegisterMsg, resultChan chan RegisterMsg) {
+func (thisClient *Client) sendWrite(ctx context.Context, process view.Process, writeMsg *RegisterMsg, resultChan chan RegisterMsg) {
 	var result RegisterMsg
-	err := comm.SendRPCRequest(process, "RegisterService.Write", writeMsg, &result)
+	err := thisClient.transport.Call(ctx, process, "RegisterService.Write", writeMsg, &result)
 	if err != nil {
-		resultChan <- RegisterMsg{Err: err}
+		resultChan <- RegisterMsg{Err: err, process: process}
 		return
 	}
 	result.process = process
 	resultChan <- result
 }
 
-func broadcastWrite(destinationView *view.View, writeMsg RegisterMsg, resultChan chan RegisterMsg) {
+func (thisClient *Client) broadcastWrite(ctx context.Context, destinationView *view.View, writeMsg RegisterMsg, resultChan chan RegisterMsg) {
 	for _, process := range destinationView.GetMembers() {
-		go sendWrite(process, &writeMsg, resultChan)
+		go thisClient.sendWrite(ctx, process, &writeMsg, resultChan)
 	}
 }
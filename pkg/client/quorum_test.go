@@ -0,0 +1,106 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/comm"
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// stuckRegisterService is a register service that does not reply until release is closed.
+type stuckRegisterService struct {
+	release chan struct{}
+}
+
+func (r *stuckRegisterService) Read(arg RegisterMsg, reply *RegisterMsg) error {
+	<-r.release
+	reply.Key = arg.Key
+	return nil
+}
+
+func (r *stuckRegisterService) Write(arg RegisterMsg, reply *RegisterMsg) error {
+	<-r.release
+	return nil
+}
+
+func startStuckServer(t *testing.T) (view.Process, *stuckRegisterService) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := &stuckRegisterService{release: make(chan struct{})}
+	rpcServer := rpc.NewServer()
+	if err := rpcServer.RegisterName("RegisterService", service); err != nil {
+		t.Fatal(err)
+	}
+	go rpcServer.Accept(listener)
+
+	return view.Process{Addr: listener.Addr().String()}, service
+}
+
+func TestReadContextDeadline(t *testing.T) {
+	process, service := startStuckServer(t)
+
+	getView := func() (*view.View, error) { return view.NewWithProcesses(process), nil }
+	cl, err := New(getView, getView)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := cl.ReadContext(ctx)
+		done <- err
+	}()
+
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReadContext did not return after its deadline")
+	}
+
+	var canceledErr comm.CanceledError
+	if !errors.As(err, &canceledErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a CanceledError wrapping context.DeadlineExceeded, got %v", err)
+	}
+	if _, err := cl.Health(); err != nil {
+		t.Fatalf("canceled read must not break the client, got client error %v", err)
+	}
+
+	// the client works again once the server answers
+	close(service.release)
+	if err := cl.WriteContext(context.Background(), "value"); err != nil {
+		t.Errorf("write after canceled read failed: %v", err)
+	}
+}
+
+func TestWriteContextCancel(t *testing.T) {
+	process, service := startStuckServer(t)
+	defer close(service.release)
+
+	getView := func() (*view.View, error) { return view.NewWithProcesses(process), nil }
+	cl, err := New(getView, getView)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	err = cl.WriteContext(ctx, "value")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if _, err := cl.Health(); err != nil {
+		t.Fatalf("canceled write must not break the client, got client error %v", err)
+	}
+}
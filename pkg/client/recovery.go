@@ -0,0 +1,137 @@
+package client
+
+import (
+	"time"
+)
+
+// RecoveryPolicy tells a Client what to do after an operation fails to get a quorum.
+//
+// Unless the policy is FailFast, the client returns the failure to the
+// operations started during a backoff period. When the period ends, the next
+// operation refreshes the client's view and tries the system again. The
+// backoff doubles after each failed try, up to MaxBackoff, and the client
+// is back in service as soon as a quorum answers.
+type RecoveryPolicy struct {
+	// FailFast makes the first failure permanent: the client returns it for every later operation.
+	FailFast bool
+
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+var (
+	// DefaultRecoveryPolicy is the policy of new clients.
+	DefaultRecoveryPolicy = RecoveryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 30 * time.Second}
+
+	// FailFastPolicy stops using the system after the first failure, until the client is recreated.
+	FailFastPolicy = RecoveryPolicy{FailFast: true}
+)
+
+// Health is the state of a client regarding the failures it noticed.
+type Health int
+
+const (
+	// Healthy clients got a quorum on their last operation.
+	Healthy Health = iota
+	// Recovering clients failed to get a quorum and will try again after a backoff.
+	Recovering
+	// Failed clients failed to get a quorum and their policy is FailFast.
+	Failed
+)
+
+func (h Health) String() string {
+	switch h {
+	case Healthy:
+		return "healthy"
+	case Recovering:
+		return "recovering"
+	case Failed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// SetRecoveryPolicy sets what the client does after a failure. It is applied from the next failure on.
+func (cl *Client) SetRecoveryPolicy(policy RecoveryPolicy) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	cl.recoveryPolicy = policy
+}
+
+// Health returns the health state of the client and the last failure it noticed, which is nil if the client is healthy.
+func (cl *Client) Health() (Health, error) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	switch {
+	case cl.err == nil:
+		return Healthy, nil
+	case cl.recoveryPolicy.FailFast:
+		return Failed, cl.err
+	default:
+		return Recovering, cl.err
+	}
+}
+
+// checkHealth returns the last failure if the client must not use the system
+// now. If the backoff period is over, it lets the caller try the system with
+// a refreshed view; the concurrent operations keep failing until the next
+// backoff period ends.
+func (cl *Client) checkHealth() error {
+	cl.mutex.Lock()
+	if cl.err == nil {
+		cl.mutex.Unlock()
+		return nil
+	}
+	if cl.recoveryPolicy.FailFast || time.Now().Before(cl.retryTime) {
+		err := cl.err
+		cl.mutex.Unlock()
+		return err
+	}
+	cl.retryTime = time.Now().Add(cl.backoff)
+	cl.mutex.Unlock()
+
+	cl.refreshView()
+	return nil
+}
+
+// refreshView asks for a more updated view with getFurtherViewsFunc or, if
+// it fails, with the members of the client's current view.
+func (cl *Client) refreshView() {
+	if newView, err := cl.getFurtherViewsFunc(); err == nil {
+		cl.updateView(newView)
+		return
+	}
+
+	if newView, err := GetCurrentViewWithTransport(cl.transport, cl.getView().GetMembers()...); err == nil {
+		cl.updateView(newView)
+	}
+}
+
+// recordFailure saves err as the client's last failure and starts a new backoff period.
+func (cl *Client) recordFailure(err error) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	if cl.err == nil {
+		cl.backoff = cl.recoveryPolicy.InitialBackoff
+	} else {
+		cl.backoff *= 2
+	}
+	if cl.backoff > cl.recoveryPolicy.MaxBackoff {
+		cl.backoff = cl.recoveryPolicy.MaxBackoff
+	}
+
+	cl.err = err
+	cl.retryTime = time.Now().Add(cl.backoff)
+}
+
+// recordSuccess puts the client back in service.
+func (cl *Client) recordSuccess() {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	cl.err = nil
+	cl.backoff = 0
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/comm"
+	"github.com/mateusbraga/freestore/pkg/timestamp"
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// flakyRegisterService is a one-register service that fails every request while down is set.
+type flakyRegisterService struct {
+	down      int32
+	value     interface{}
+	timestamp timestamp.Timestamp
+}
+
+func (r *flakyRegisterService) Read(arg RegisterMsg, reply *RegisterMsg) error {
+	if atomic.LoadInt32(&r.down) == 1 {
+		return errors.New("service down")
+	}
+	reply.Value = r.value
+	reply.Timestamp = r.timestamp
+	return nil
+}
+
+func (r *flakyRegisterService) Write(arg RegisterMsg, reply *RegisterMsg) error {
+	if atomic.LoadInt32(&r.down) == 1 {
+		return errors.New("service down")
+	}
+	if r.timestamp.Less(arg.Timestamp) {
+		r.value = arg.Value
+		r.timestamp = arg.Timestamp
+	}
+	return nil
+}
+
+func startFlakyServer(t *testing.T) (view.Process, *flakyRegisterService) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := &flakyRegisterService{}
+	rpcServer := rpc.NewServer()
+	if err := rpcServer.RegisterName("RegisterService", service); err != nil {
+		t.Fatal(err)
+	}
+	go rpcServer.Accept(listener)
+
+	return view.Process{Addr: listener.Addr().String()}, service
+}
+
+func TestClientRecoversAfterFailure(t *testing.T) {
+	process, service := startFlakyServer(t)
+
+	getView := func() (*view.View, error) { return view.NewWithProcesses(process), nil }
+	cl, err := New(getView, getView)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl.SetRecoveryPolicy(RecoveryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond})
+
+	atomic.StoreInt32(&service.down, 1)
+	if err := cl.Write("lost"); err == nil {
+		t.Fatal("write should fail without a quorum")
+	}
+	if health, err := cl.Health(); health != Recovering || err == nil {
+		t.Fatalf("expected recovering client with an error, got %v and %v", health, err)
+	}
+
+	atomic.StoreInt32(&service.down, 0)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if err := cl.Write("value"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("client did not recover after the quorum came back")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if health, err := cl.Health(); health != Healthy || err != nil {
+		t.Errorf("expected healthy client, got %v and %v", health, err)
+	}
+	if v, err := cl.Read(); err != nil || v != "value" {
+		t.Errorf("expected to read value, got %v and %v", v, err)
+	}
+}
+
+func TestClientFailFast(t *testing.T) {
+	process, service := startFlakyServer(t)
+
+	getView := func() (*view.View, error) { return view.NewWithProcesses(process), nil }
+	cl, err := New(getView, getView)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl.SetRecoveryPolicy(FailFastPolicy)
+
+	atomic.StoreInt32(&service.down, 1)
+	firstErr := cl.Write("lost")
+	var noQuorumErr comm.NoQuorumError
+	if !errors.As(firstErr, &noQuorumErr) || !errors.Is(firstErr, comm.ErrNoQuorum) {
+		t.Fatalf("expected a NoQuorumError, got %v", firstErr)
+	}
+	if len(noQuorumErr.Failed) != 1 || noQuorumErr.Failed[0] != process || noQuorumErr.View.ViewRef != cl.getView().ViewRef {
+		t.Errorf("NoQuorumError should report process %v of the client's view, got %+v", process, noQuorumErr)
+	}
+
+	atomic.StoreInt32(&service.down, 0)
+	time.Sleep(50 * time.Millisecond)
+
+	if err := cl.Write("value"); err == nil || err.Error() != firstErr.Error() {
+		t.Errorf("fail-fast client should keep returning %v, got %v", firstErr, err)
+	}
+	if health, err := cl.Health(); health != Failed || !errors.Is(err, comm.ErrNoQuorum) {
+		t.Errorf("expected failed client with %v, got %v and %v", firstErr, health, err)
+	}
+}
@@ -1,22 +1,33 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"fmt"
+
 	"github.com/mateusbraga/freestore/pkg/comm"
 	"github.com/mateusbraga/freestore/pkg/view"
 )
 
-// GetCurrentView asks processes for the its current view and returns it.
+// GetCurrentView asks processes for the its current view and returns it. If
+// no process answers, the returned error wraps the last failure, usually a
+// comm.UnreachableError.
 func GetCurrentView(processes ...view.Process) (*view.View, error) {
+	return GetCurrentViewWithTransport(comm.DefaultTransport, processes...)
+}
+
+// GetCurrentViewWithTransport is like GetCurrentView, but it talks to processes through transport.
+func GetCurrentViewWithTransport(transport comm.Transport, processes ...view.Process) (*view.View, error) {
+	lastErr := errors.New("no process given")
 	for _, loopProcess := range processes {
 		var receivedView *view.View
-		err := comm.SendRPCRequest(loopProcess, "RegisterService.GetCurrentView", struct{}{}, &receivedView)
+		err := transport.Call(context.Background(), loopProcess, "RegisterService.GetCurrentView", struct{}{}, &receivedView)
 		if err != nil {
+			lastErr = err
 			continue
 		}
 
 		return receivedView, nil
 	}
-	return nil, errors.New(fmt.Sprintf("Failed to get current view from any of the processes: %v", processes))
+	return nil, fmt.Errorf("Failed to get current view from any of the processes %v: %w", processes, lastErr)
 }
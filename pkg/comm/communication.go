@@ -2,107 +2,60 @@
 package comm
 
 import (
-	"errors"
-	"fmt"
+	"context"
 	"log"
-	"net/rpc"
-	"sync"
 
 	"github.com/mateusbraga/freestore/pkg/view"
 )
 
-var (
-	commLinkTable   = make(map[view.Process]communicationLink)
-	commLinkTableMu sync.Mutex
-)
-
-type communicationLink struct {
-	process   view.Process
-	rpcClient *rpc.Client
-}
-
-func (commLink communicationLink) isFaulty() bool {
-	return commLink.rpcClient == nil
-}
-
-func getCommLink(process view.Process) communicationLink {
-	commLinkTableMu.Lock()
-	defer commLinkTableMu.Unlock()
-
-	commLink, ok := commLinkTable[process]
-	if !ok {
-		commLink = communicationLink{process: process}
-
-		newRpcClient, err := rpc.Dial("tcp", process.Addr)
-		if err != nil {
-			newRpcClient = nil
-			repairLinkChan <- commLink
-		}
-		commLink.rpcClient = newRpcClient
-
-		commLinkTable[process] = commLink
-	}
-
-	return commLink
+// SendRPCRequest invokes serviceMethod at process with arg and puts the result at result, using DefaultTransport. Any communication error that occurs is returned.
+func SendRPCRequest(process view.Process, serviceMethod string, arg interface{}, result interface{}) error {
+	return DefaultTransport.Call(context.Background(), process, serviceMethod, arg, result)
 }
 
-func setCommLinkFaulty(process view.Process) {
-	commLinkTableMu.Lock()
-	defer commLinkTableMu.Unlock()
-
-	commLink := commLinkTable[process]
-	commLink.rpcClient = nil
-	commLinkTable[process] = commLink
-
-	repairLinkChan <- commLink
+// SendRPCRequestContext is like SendRPCRequest, but it stops waiting for the
+// answer and returns a CanceledError when ctx is done. result must not be used
+// if an error is returned, as the answer may still arrive later.
+//
+// A process that cannot be reached results in an UnreachableError. An error
+// returned by the service itself is returned as is and does not make the
+// link faulty.
+func SendRPCRequestContext(ctx context.Context, process view.Process, serviceMethod string, arg interface{}, result interface{}) error {
+	return DefaultTransport.Call(ctx, process, serviceMethod, arg, result)
 }
 
-func deleteCommLink(process view.Process) {
-	commLinkTableMu.Lock()
-	defer commLinkTableMu.Unlock()
-
-	delete(commLinkTable, process)
+// BroadcastRPCRequest is like Broadcast, using DefaultTransport.
+func BroadcastRPCRequest(destinationView *view.View, serviceMethod string, arg interface{}) error {
+	return Broadcast(DefaultTransport, destinationView, serviceMethod, arg)
 }
 
-// SendRPCRequest invokes serviceMethod at process with arg and puts the result at result. Any communication error that occurs is returned.
-func SendRPCRequest(process view.Process, serviceMethod string, arg interface{}, result interface{}) error {
-	commLink := getCommLink(process)
-	if commLink.isFaulty() {
-		return errors.New(fmt.Sprintf("SendRPCRequest: Process %v is currently unreachable", process))
-	}
-
-	err := commLink.rpcClient.Call(serviceMethod, arg, result)
-	if err != nil {
-		setCommLinkFaulty(commLink.process)
-		return errors.New(fmt.Sprintf("SendRPCRequest: %v call to process %v failed: %v", serviceMethod, commLink.process, err))
+// Broadcast invokes serviceMethod at all members of the destinationView with
+// arg through transport. It returns a NoQuorumError if it fails to receive a
+// response from a quorum of processes.
+func Broadcast(transport Transport, destinationView *view.View, serviceMethod string, arg interface{}) error {
+	type result struct {
+		process view.Process
+		err     error
 	}
-
-	return nil
-}
-
-// BroadcastRPCRequest invokes serviceMethod at all members of the
-// destinationView with arg. It returns an error if it fails to receive
-// a response from a quorum of processes.
-func BroadcastRPCRequest(destinationView *view.View, serviceMethod string, arg interface{}) error {
-	errorChan := make(chan error, destinationView.NumberOfMembers())
+	resultChan := make(chan result, destinationView.NumberOfMembers())
 
 	for _, process := range destinationView.GetMembers() {
 		go func(process view.Process) {
 			var discardResult struct{}
-			errorChan <- SendRPCRequest(process, serviceMethod, arg, &discardResult)
+			resultChan <- result{process, transport.Call(context.Background(), process, serviceMethod, arg, &discardResult)}
 		}(process)
 	}
 
-	failedTotal := 0
+	var failed []view.Process
 	successTotal := 0
 	for {
-		err := <-errorChan
+		result := <-resultChan
 
-		if err != nil {
-			failedTotal++
-			if failedTotal > destinationView.NumberOfToleratedFaults() {
-				log.Printf("WARN: BroadcastRPCRequest failed to send %v to a quorum\n", serviceMethod)
-				return err
+		if result.err != nil {
+			failed = append(failed, result.process)
+			if len(failed) > destinationView.NumberOfToleratedFaults() {
+				log.Printf("WARN: Broadcast failed to send %v to a quorum\n", serviceMethod)
+				return NoQuorumError{Op: serviceMethod, View: destinationView, Failed: failed, Cause: result.err.Error()}
 			}
 		} else {
 			successTotal++
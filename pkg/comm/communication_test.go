@@ -0,0 +1,53 @@
+package comm
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+type stuckService struct {
+	release chan struct{}
+}
+
+func (s *stuckService) Wait(arg int, reply *int) error {
+	<-s.release
+	*reply = arg
+	return nil
+}
+
+func TestSendRPCRequestContextDeadline(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	service := &stuckService{release: make(chan struct{})}
+	rpcServer := rpc.NewServer()
+	rpcServer.RegisterName("Stuck", service)
+	go rpcServer.Accept(listener)
+
+	process := view.Process{Addr: listener.Addr().String()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var result int
+	err = SendRPCRequestContext(ctx, process, "Stuck.Wait", 1, &result)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	// a slow answer does not make the link faulty
+	close(service.release)
+	var result2 int
+	if err := SendRPCRequest(process, "Stuck.Wait", 2, &result2); err != nil || result2 != 2 {
+		t.Errorf("expected 2 and no error, got %v and %v", result2, err)
+	}
+}
@@ -0,0 +1,172 @@
+package comm
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// FailureDetector is implemented by the transports that watch the processes
+// they talk to. A process is suspected when it stops answering heartbeats,
+// even if its connection is still open. The calls to a suspected process fail
+// with an UnreachableError, so quorum code stops waiting for it.
+type FailureDetector interface {
+	// Suspected tells if process is currently suspected to have failed.
+	Suspected(process view.Process) bool
+
+	// SuspicionLevels returns how many heartbeats in a row each watched
+	// process failed to answer. A level of 0 means the process is trusted.
+	SuspicionLevels() map[view.Process]int
+}
+
+// HeartbeatService answers the heartbeats of the failure detectors. Servers
+// register it so that other processes can watch them.
+type HeartbeatService struct{}
+
+func (h *HeartbeatService) Ping(anything struct{}, reply *struct{}) error {
+	return nil
+}
+
+// heartbeatMonitor keeps the suspicion level of the process of a communicationLink.
+type heartbeatMonitor struct {
+	mu     sync.Mutex
+	missed int
+	// suspectedChan is closed when the process becomes suspected. It is
+	// replaced by a new one when the process answers again.
+	suspectedChan chan struct{}
+	suspected     bool
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newHeartbeatMonitor() *heartbeatMonitor {
+	return &heartbeatMonitor{
+		suspectedChan: make(chan struct{}),
+		stop:          make(chan struct{}),
+	}
+}
+
+// suspicion returns whether the process is suspected and a channel that is closed when it becomes suspected.
+func (m *heartbeatMonitor) suspicion() (bool, <-chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.suspected, m.suspectedChan
+}
+
+func (m *heartbeatMonitor) level() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.missed
+}
+
+// miss records a heartbeat that was not answered. The process becomes suspected after suspectAfter misses in a row.
+func (m *heartbeatMonitor) miss(suspectAfter int) (becameSuspected bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.missed++
+	if m.missed >= suspectAfter && !m.suspected {
+		m.suspected = true
+		close(m.suspectedChan)
+		return true
+	}
+	return false
+}
+
+// answer records a heartbeat that was answered. The process is trusted again.
+func (m *heartbeatMonitor) answer() (wasSuspected bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.missed = 0
+	if m.suspected {
+		m.suspected = false
+		m.suspectedChan = make(chan struct{})
+		return true
+	}
+	return false
+}
+
+func (m *heartbeatMonitor) close() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+// heartbeatLoop pings process every HeartbeatPeriod while its link is in the table.
+func (t *TCPTransport) heartbeatLoop(process view.Process, monitor *heartbeatMonitor) {
+	ticker := time.NewTicker(t.config.HeartbeatPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-monitor.stop:
+			return
+		case <-ticker.C:
+		}
+
+		t.commLinkTableMu.Lock()
+		commLink, ok := t.commLinkTable[process]
+		t.commLinkTableMu.Unlock()
+		if !ok || commLink.monitor != monitor {
+			return
+		}
+		if commLink.isFaulty() {
+			// the link is being repaired, there is no connection to ping
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), t.config.HeartbeatPeriod)
+		err, linkFailed := rpcCall(ctx, commLink.rpcClient, process, "HeartbeatService.Ping", struct{}{}, new(struct{}), nil, 0)
+		cancel()
+
+		if err == nil || (!linkFailed && !isCanceled(err)) {
+			// any answer, even an error of the service, shows the process is alive
+			if monitor.answer() {
+				log.Printf("Process %v answers heartbeats again\n", process)
+			}
+			continue
+		}
+
+		if monitor.miss(t.config.SuspectAfter) {
+			log.Printf("WARN: Process %v is suspected: it missed %v heartbeats\n", process, t.config.SuspectAfter)
+		}
+		if linkFailed {
+			t.setCommLinkFaulty(process)
+		}
+	}
+}
+
+func isCanceled(err error) bool {
+	_, ok := err.(CanceledError)
+	return ok
+}
+
+// Suspected tells if process is currently suspected to have failed.
+func (t *TCPTransport) Suspected(process view.Process) bool {
+	t.commLinkTableMu.Lock()
+	commLink, ok := t.commLinkTable[process]
+	t.commLinkTableMu.Unlock()
+	if !ok {
+		return false
+	}
+
+	suspected, _ := commLink.monitor.suspicion()
+	return suspected
+}
+
+// SuspicionLevels returns how many heartbeats in a row each process this transport talks to failed to answer.
+func (t *TCPTransport) SuspicionLevels() map[view.Process]int {
+	t.commLinkTableMu.Lock()
+	defer t.commLinkTableMu.Unlock()
+
+	levels := make(map[view.Process]int, len(t.commLinkTable))
+	for process, commLink := range t.commLinkTable {
+		levels[process] = commLink.monitor.level()
+	}
+	return levels
+}
+
+var _ FailureDetector = new(TCPTransport)
@@ -0,0 +1,184 @@
+package comm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// startSilentServer accepts TCP connections but never answers on them.
+func startSilentServer(t *testing.T) (view.Process, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var conns []net.Conn
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				for _, conn := range conns {
+					conn.Close()
+				}
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	return view.Process{Addr: listener.Addr().String()}, func() { listener.Close(); <-done }
+}
+
+func TestTCPTransportCallTimeout(t *testing.T) {
+	process, stop := startSilentServer(t)
+	defer stop()
+
+	transport := NewTCPTransportWithConfig(TCPConfig{CallTimeout: 50 * time.Millisecond, HeartbeatPeriod: time.Hour})
+
+	var reply string
+	start := time.Now()
+	err := transport.Call(context.Background(), process, "Echo.Echo", "hello", &reply)
+	if !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("expected unreachable error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("call took %v to time out", elapsed)
+	}
+}
+
+func TestTCPTransportSuspectsSilentProcess(t *testing.T) {
+	process, stop := startSilentServer(t)
+	defer stop()
+
+	transport := NewTCPTransportWithConfig(TCPConfig{CallTimeout: -1, HeartbeatPeriod: 20 * time.Millisecond, SuspectAfter: 2})
+
+	// without a call timeout, only the failure detector ends this call
+	var reply string
+	err := transport.Call(context.Background(), process, "Echo.Echo", "hello", &reply)
+	if !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("expected unreachable error, got %v", err)
+	}
+	if !transport.Suspected(process) {
+		t.Errorf("%v should be suspected", process)
+	}
+	if level := transport.SuspicionLevels()[process]; level < 2 {
+		t.Errorf("expected suspicion level of at least 2, got %v", level)
+	}
+
+	// new calls fail right away
+	if err := transport.Call(context.Background(), process, "Echo.Echo", "hello", &reply); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("expected unreachable error for a suspected process, got %v", err)
+	}
+}
+
+func TestTCPTransportTrustsLiveProcess(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	rpcServer := rpc.NewServer()
+	rpcServer.RegisterName("Echo", new(echoService))
+	rpcServer.Register(new(HeartbeatService))
+	go rpcServer.Accept(listener)
+
+	process := view.Process{Addr: listener.Addr().String()}
+	transport := NewTCPTransportWithConfig(TCPConfig{HeartbeatPeriod: 10 * time.Millisecond, SuspectAfter: 2})
+
+	var reply string
+	if err := transport.Call(context.Background(), process, "Echo.Echo", "hello", &reply); err != nil || reply != "hello" {
+		t.Fatalf("expected hello and no error, got %q and %v", reply, err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if transport.Suspected(process) {
+		t.Errorf("%v answers heartbeats and should not be suspected", process)
+	}
+	if level, ok := transport.SuspicionLevels()[process]; !ok || level != 0 {
+		t.Errorf("expected suspicion level 0, got %v (watched: %v)", level, ok)
+	}
+}
+
+// startBlackholeServer returns the address of a socket that does not accept
+// connections and whose backlog is full, so connecting to it hangs.
+func startBlackholeServer(t *testing.T) (view.Process, func()) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Listen(fd, 0); err != nil {
+		t.Fatal(err)
+	}
+	sockaddr, err := syscall.Getsockname(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	process := view.Process{Addr: fmt.Sprintf("127.0.0.1:%v", sockaddr.(*syscall.SockaddrInet4).Port)}
+
+	// fill the backlog
+	conn, err := net.Dial("tcp", process.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return process, func() { conn.Close(); syscall.Close(fd) }
+}
+
+func TestTCPTransportDialDoesNotBlockOtherCalls(t *testing.T) {
+	blackhole, stop := startBlackholeServer(t)
+	defer stop()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	rpcServer := rpc.NewServer()
+	rpcServer.RegisterName("Echo", new(echoService))
+	go rpcServer.Accept(listener)
+	live := view.Process{Addr: listener.Addr().String()}
+
+	transport := NewTCPTransportWithConfig(TCPConfig{CallTimeout: -1, HeartbeatPeriod: time.Hour})
+
+	blackholeDone := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		var reply string
+		blackholeDone <- transport.Call(ctx, blackhole, "Echo.Echo", "hello", &reply)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// the dial to the blackhole must not hold up a call to another process
+	start := time.Now()
+	var reply string
+	if err := transport.Call(context.Background(), live, "Echo.Echo", "hello", &reply); err != nil || reply != "hello" {
+		t.Fatalf("expected hello and no error, got %q and %v", reply, err)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("the call to a live process took %v while dialing a blackhole", elapsed)
+	}
+
+	// and it gives up when its context is done
+	select {
+	case err := <-blackholeDone:
+		if !errors.Is(err, ErrCanceled) {
+			t.Errorf("expected canceled error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the call to the blackhole ignored its context")
+	}
+}
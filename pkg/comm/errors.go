@@ -0,0 +1,108 @@
+package comm
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// The errors below classify the failures of freestore's clients and
+// servers. Check for them with errors.Is; the types that carry details can
+// be extracted with errors.As. All error types survive gob transport, so a
+// server may return them inside its replies; like view.OldViewError, they
+// arrive as pointers.
+var (
+	// ErrNoQuorum is matched by NoQuorumError.
+	ErrNoQuorum = errors.New("no quorum")
+	// ErrViewChanged is matched by view.OldViewError, returned when the request used a view that was replaced.
+	ErrViewChanged = view.ErrViewChanged
+	// ErrUnreachable is matched by UnreachableError.
+	ErrUnreachable = errors.New("process unreachable")
+	// ErrCanceled is matched by CanceledError.
+	ErrCanceled = errors.New("request canceled")
+	// ErrOverloaded is matched by OverloadedError.
+	ErrOverloaded = errors.New("server overloaded")
+)
+
+// NoQuorumError is returned when an operation fails to get answers from a quorum of View.
+type NoQuorumError struct {
+	Op     string         // operation that failed, e.g. "read"
+	View   *view.View     // view whose quorum was not reached
+	Failed []view.Process // processes that failed to answer
+	Cause  string         // one of the failures, as a hint of what went wrong
+}
+
+func (e NoQuorumError) Error() string {
+	return fmt.Sprintf("Failed to get %v quorum of view %v: processes %v failed (%v)", e.Op, e.View, e.Failed, e.Cause)
+}
+
+func (e NoQuorumError) Is(target error) bool { return target == ErrNoQuorum }
+
+// UnreachableError is returned when a request could not be delivered to Process or its answer was lost.
+type UnreachableError struct {
+	Process view.Process
+	Method  string // the RPC method, empty if the request was not sent
+	Cause   string
+}
+
+func (e UnreachableError) Error() string {
+	if e.Method == "" {
+		return fmt.Sprintf("Process %v is currently unreachable", e.Process)
+	}
+	return fmt.Sprintf("%v call to process %v failed: %v", e.Method, e.Process, e.Cause)
+}
+
+func (e UnreachableError) Is(target error) bool { return target == ErrUnreachable }
+
+// CanceledError is returned when the context of a request is done before the request completes.
+type CanceledError struct {
+	Err error // the context's error
+}
+
+func (e CanceledError) Error() string {
+	return fmt.Sprintf("Request canceled: %v", e.Err)
+}
+
+func (e CanceledError) Unwrap() error {
+	return e.Err
+}
+
+func (e CanceledError) Is(target error) bool { return target == ErrCanceled }
+
+// GobEncode encodes only whether the deadline was exceeded, as the context's errors cannot be sent.
+func (e CanceledError) GobEncode() ([]byte, error) {
+	if errors.Is(e.Err, context.DeadlineExceeded) {
+		return []byte{1}, nil
+	}
+	return []byte{0}, nil
+}
+
+func (e *CanceledError) GobDecode(data []byte) error {
+	if len(data) == 1 && data[0] == 1 {
+		e.Err = context.DeadlineExceeded
+	} else {
+		e.Err = context.Canceled
+	}
+	return nil
+}
+
+// OverloadedError is returned by a server that has too many requests in progress to take a new one.
+type OverloadedError struct {
+	Process view.Process
+}
+
+func (e OverloadedError) Error() string {
+	return fmt.Sprintf("Process %v is overloaded", e.Process)
+}
+
+func (e OverloadedError) Is(target error) bool { return target == ErrOverloaded }
+
+func init() {
+	gob.Register(new(NoQuorumError))
+	gob.Register(new(UnreachableError))
+	gob.Register(new(CanceledError))
+	gob.Register(new(OverloadedError))
+}
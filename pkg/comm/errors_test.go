@@ -0,0 +1,66 @@
+package comm
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"testing"
+
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// errorMsg is like the replies of freestore's services, which carry errors in an interface field.
+type errorMsg struct {
+	Err error
+}
+
+func TestErrorsSurviveGob(t *testing.T) {
+	v := view.NewWithProcesses(view.Process{Addr: "127.0.0.1:5000"})
+
+	tests := []struct {
+		err    error
+		target error
+	}{
+		{NoQuorumError{Op: "read", View: v, Failed: []view.Process{view.Process{Addr: "127.0.0.1:5000"}}, Cause: "boom"}, ErrNoQuorum},
+		{view.OldViewError{NewView: v}, ErrViewChanged},
+		{UnreachableError{Process: view.Process{Addr: "127.0.0.1:5000"}, Method: "RegisterService.Read", Cause: "EOF"}, ErrUnreachable},
+		{CanceledError{Err: context.DeadlineExceeded}, context.DeadlineExceeded},
+		{CanceledError{Err: context.Canceled}, ErrCanceled},
+		{OverloadedError{Process: view.Process{Addr: "127.0.0.1:5000"}}, ErrOverloaded},
+	}
+
+	for _, test := range tests {
+		if !errors.Is(test.err, test.target) {
+			t.Errorf("%v should match %v", test.err, test.target)
+		}
+
+		buf := new(bytes.Buffer)
+		if err := gob.NewEncoder(buf).Encode(errorMsg{Err: test.err}); err != nil {
+			t.Errorf("failed to encode %v: %v", test.err, err)
+			continue
+		}
+		var decoded errorMsg
+		if err := gob.NewDecoder(buf).Decode(&decoded); err != nil {
+			t.Errorf("failed to decode %v: %v", test.err, err)
+			continue
+		}
+
+		if !errors.Is(decoded.Err, test.target) {
+			t.Errorf("decoded %v should match %v", decoded.Err, test.target)
+		}
+		if decoded.Err.Error() != test.err.Error() {
+			t.Errorf("expected decoded error %q, got %q", test.err, decoded.Err)
+		}
+	}
+
+	// gob decodes the errors as pointers, like view.OldViewError
+	var noQuorumErr *NoQuorumError
+	buf := new(bytes.Buffer)
+	gob.NewEncoder(buf).Encode(errorMsg{Err: NoQuorumError{Op: "write", View: v}})
+	var decoded errorMsg
+	gob.NewDecoder(buf).Decode(&decoded)
+	if !errors.As(decoded.Err, &noQuorumErr) || noQuorumErr.View == nil || !noQuorumErr.View.Equal(v) {
+		t.Errorf("expected a NoQuorumError with view %v, got %v", v, decoded.Err)
+	}
+}
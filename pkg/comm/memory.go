@@ -0,0 +1,195 @@
+package comm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"strings"
+	"sync"
+
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// MemoryTransport is a Transport that connects processes running in the same
+// Go process, with in-memory pipes instead of sockets. The requests are still
+// gob encoded, so a service behaves as it would over TCP. It is meant for
+// tests.
+type MemoryTransport struct {
+	mu        sync.Mutex
+	listeners map[string]*memoryListener
+	clients   map[string]*rpc.Client
+	lastPort  int
+}
+
+var _ Transport = new(MemoryTransport)
+
+// NewMemoryTransport returns a MemoryTransport with no process listening.
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{
+		listeners: make(map[string]*memoryListener),
+		clients:   make(map[string]*rpc.Client),
+	}
+}
+
+// Listen starts listening at addr. If addr is empty or its port is 0, a free address is chosen.
+func (t *MemoryTransport) Listen(addr string) (net.Listener, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if addr == "" || strings.HasSuffix(addr, ":0") {
+		t.lastPort++
+		addr = fmt.Sprintf("memory:%d", t.lastPort)
+	}
+	if _, ok := t.listeners[addr]; ok {
+		return nil, fmt.Errorf("listen memory %v: address already in use", addr)
+	}
+
+	l := &memoryListener{
+		transport: t,
+		addr:      memoryAddr(addr),
+		connChan:  make(chan net.Conn),
+		done:      make(chan struct{}),
+		conns:     make(map[net.Conn]bool),
+	}
+	t.listeners[addr] = l
+	return l, nil
+}
+
+func (t *MemoryTransport) Call(ctx context.Context, process view.Process, serviceMethod string, arg interface{}, result interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return CanceledError{Err: err}
+	}
+
+	rpcClient, err := t.getClient(ctx, process.Addr)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return CanceledError{Err: ctxErr}
+		}
+		return UnreachableError{Process: process, Method: serviceMethod, Cause: err.Error()}
+	}
+
+	err, linkFailed := rpcCall(ctx, rpcClient, process, serviceMethod, arg, result, nil, 0)
+	if linkFailed {
+		t.dropClient(process.Addr, rpcClient)
+	}
+	return err
+}
+
+func (t *MemoryTransport) getClient(ctx context.Context, addr string) (*rpc.Client, error) {
+	t.mu.Lock()
+	rpcClient, ok := t.clients[addr]
+	l := t.listeners[addr]
+	t.mu.Unlock()
+
+	if ok {
+		return rpcClient, nil
+	}
+	if l == nil {
+		return nil, errors.New("connection refused")
+	}
+
+	conn, err := l.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rpcClient = rpc.NewClient(conn)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if concurrentClient, ok := t.clients[addr]; ok {
+		// a concurrent call connected first
+		rpcClient.Close()
+		return concurrentClient, nil
+	}
+	t.clients[addr] = rpcClient
+	return rpcClient, nil
+}
+
+// dropClient forgets rpcClient, whose connection failed. The next call to addr connects again.
+func (t *MemoryTransport) dropClient(addr string, rpcClient *rpc.Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.clients[addr] == rpcClient {
+		delete(t.clients, addr)
+	}
+	rpcClient.Close()
+}
+
+func (t *MemoryTransport) removeListener(l *memoryListener) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.listeners[string(l.addr)] == l {
+		delete(t.listeners, string(l.addr))
+	}
+}
+
+type memoryAddr string
+
+func (a memoryAddr) Network() string { return "memory" }
+func (a memoryAddr) String() string  { return string(a) }
+
+// memoryListener is the net.Listener of a MemoryTransport. Closing it also
+// closes the connections it accepted, as if the process had stopped.
+type memoryListener struct {
+	transport *MemoryTransport
+	addr      memoryAddr
+	connChan  chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+
+	connsMu sync.Mutex
+	conns   map[net.Conn]bool
+}
+
+// dial waits for l to accept a connection, or for ctx to be done.
+func (l *memoryListener) dial(ctx context.Context) (net.Conn, error) {
+	clientConn, serverConn := net.Pipe()
+	select {
+	case l.connChan <- serverConn:
+		return clientConn, nil
+	case <-l.done:
+		return nil, errors.New("connection refused")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connChan:
+		l.connsMu.Lock()
+		defer l.connsMu.Unlock()
+		select {
+		case <-l.done:
+			conn.Close()
+			return nil, errors.New("use of closed memory listener")
+		default:
+		}
+		l.conns[conn] = true
+		return conn, nil
+	case <-l.done:
+		return nil, errors.New("use of closed memory listener")
+	}
+}
+
+func (l *memoryListener) Close() error {
+	l.closeOnce.Do(func() {
+		l.transport.removeListener(l)
+
+		l.connsMu.Lock()
+		defer l.connsMu.Unlock()
+		close(l.done)
+		for conn := range l.conns {
+			conn.Close()
+		}
+	})
+	return nil
+}
+
+func (l *memoryListener) Addr() net.Addr {
+	return l.addr
+}
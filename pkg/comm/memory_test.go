@@ -0,0 +1,149 @@
+package comm
+
+import (
+	"context"
+	"errors"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+type echoService struct{}
+
+func (s *echoService) Echo(arg string, reply *string) error {
+	*reply = arg
+	return nil
+}
+
+func (s *echoService) Ping(arg string, reply *struct{}) error {
+	return nil
+}
+
+func (s *echoService) Fail(arg string, reply *string) error {
+	return errors.New(arg)
+}
+
+func startMemoryEcho(t *testing.T, transport *MemoryTransport, addr string) (view.Process, func()) {
+	listener, err := transport.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rpcServer := rpc.NewServer()
+	rpcServer.RegisterName("Echo", new(echoService))
+	go rpcServer.Accept(listener)
+
+	return view.Process{Addr: listener.Addr().String()}, func() { listener.Close() }
+}
+
+func TestMemoryTransport(t *testing.T) {
+	transport := NewMemoryTransport()
+	process, stop := startMemoryEcho(t, transport, "")
+
+	var reply string
+	if err := transport.Call(context.Background(), process, "Echo.Echo", "hello", &reply); err != nil || reply != "hello" {
+		t.Fatalf("expected hello and no error, got %q and %v", reply, err)
+	}
+
+	// an error of the service is not a communication failure
+	if err := transport.Call(context.Background(), process, "Echo.Fail", "boom", &reply); err == nil || errors.Is(err, ErrUnreachable) {
+		t.Errorf("expected the service error, got %v", err)
+	}
+
+	if err := transport.Call(context.Background(), view.Process{Addr: "memory:none"}, "Echo.Echo", "hello", &reply); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("expected unreachable error for a process that is not listening, got %v", err)
+	}
+
+	stop()
+	if err := transport.Call(context.Background(), process, "Echo.Echo", "hello", &reply); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("expected unreachable error for a stopped process, got %v", err)
+	}
+
+	// the process comes back at the same address
+	_, stop = startMemoryEcho(t, transport, process.Addr)
+	defer stop()
+	if err := transport.Call(context.Background(), process, "Echo.Echo", "again", &reply); err != nil || reply != "again" {
+		t.Errorf("expected again and no error after restart, got %q and %v", reply, err)
+	}
+
+	if _, err := transport.Listen(process.Addr); err == nil {
+		t.Errorf("listening twice at %v should fail", process.Addr)
+	}
+}
+
+func TestMemoryTransportContext(t *testing.T) {
+	transport := NewMemoryTransport()
+	listener, err := transport.Listen("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	service := &stuckService{release: make(chan struct{})}
+	rpcServer := rpc.NewServer()
+	rpcServer.RegisterName("Stuck", service)
+	go rpcServer.Accept(listener)
+	process := view.Process{Addr: listener.Addr().String()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var result int
+	if err := transport.Call(ctx, process, "Stuck.Wait", 1, &result); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	close(service.release)
+	var result2 int
+	if err := transport.Call(context.Background(), process, "Stuck.Wait", 2, &result2); err != nil || result2 != 2 {
+		t.Errorf("expected 2 and no error, got %v and %v", result2, err)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	transport := NewMemoryTransport()
+	process1, stop1 := startMemoryEcho(t, transport, "")
+	defer stop1()
+	process2, stop2 := startMemoryEcho(t, transport, "")
+	defer stop2()
+	process3, stop3 := startMemoryEcho(t, transport, "")
+
+	destinationView := view.NewWithProcesses(process1, process2, process3)
+	if err := Broadcast(transport, destinationView, "Echo.Ping", "hello"); err != nil {
+		t.Errorf("broadcast to a quorum failed: %v", err)
+	}
+
+	stop3()
+	stop2()
+	if err := Broadcast(transport, destinationView, "Echo.Ping", "hello"); !errors.Is(err, ErrNoQuorum) {
+		t.Errorf("expected no quorum error, got %v", err)
+	}
+}
+
+// TestMemoryTransportDialDeadline checks that a call to a listener that does
+// not accept connections returns when its context is done, as over TCP.
+func TestMemoryTransportDialDeadline(t *testing.T) {
+	transport := NewMemoryTransport()
+	listener, err := transport.Listen("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	process := view.Process{Addr: listener.Addr().String()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	errChan := make(chan error, 1)
+	go func() { errChan <- transport.Call(ctx, process, "Echo.Echo", "hello", new(string)) }()
+
+	select {
+	case err := <-errChan:
+		if !errors.Is(err, ErrCanceled) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected the deadline to be exceeded, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the call did not return after its deadline")
+	}
+}
@@ -1,7 +1,7 @@
 package comm
 
 import (
-	"net/rpc"
+	"context"
 	"time"
 
 	"github.com/mateusbraga/freestore/pkg/view"
@@ -20,47 +20,59 @@ const (
 	maxCommLinkRepairPeriod = 3 * time.Second
 )
 
-var (
-	// chan which new faulty links arrive
-	repairLinkChan = make(chan communicationLink, 20)
-)
-
-func repairCommLinkFunc(process view.Process) error {
-	newRpcClient, err := rpc.Dial("tcp", process.Addr)
+func (t *TCPTransport) repairCommLinkFunc(process view.Process) error {
+	newRpcClient, err := t.dial(context.Background(), process)
 	if err != nil {
 		return err
 	}
 
-	commLinkTableMu.Lock()
-	defer commLinkTableMu.Unlock()
+	t.commLinkTableMu.Lock()
+	defer t.commLinkTableMu.Unlock()
 
-	commLink := commLinkTable[process]
+	commLink, ok := t.commLinkTable[process]
+	if !ok {
+		// the link was deleted, it is not used anymore
+		newRpcClient.Close()
+		return nil
+	}
 	commLink.rpcClient = newRpcClient
-	commLinkTable[process] = commLink
+	t.commLinkTable[process] = commLink
 	return nil
 }
 
-func init() { go repairCommLinkLoop() }
+// takeFaultyLinks returns the links handed to repairCommLinkLoop since the last call.
+func (t *TCPTransport) takeFaultyLinks() []view.Process {
+	t.commLinkTableMu.Lock()
+	defer t.commLinkTableMu.Unlock()
+
+	var processes []view.Process
+	for process := range t.faultyLinks {
+		processes = append(processes, process)
+	}
+	t.faultyLinks = make(map[view.Process]bool)
+	return processes
+}
 
-func repairCommLinkLoop() {
+func (t *TCPTransport) repairCommLinkLoop() {
 	commLinkRepairPeriod := initialCommLinkRepairPeriod
 	faultyCommLinks := make(map[view.Process]bool)
 	repairTimer := time.NewTimer(commLinkRepairPeriod)
 
 	for {
 		select {
-		case commLink := <-repairLinkChan:
-			err := repairCommLinkFunc(commLink.process)
-			if err != nil {
-				faultyCommLinks[commLink.process] = true
-
-				commLinkRepairPeriod = initialCommLinkRepairPeriod
-				repairTimer.Reset(commLinkRepairPeriod)
+		case <-t.repairSignal:
+			for _, process := range t.takeFaultyLinks() {
+				err := t.repairCommLinkFunc(process)
+				if err != nil {
+					faultyCommLinks[process] = true
 
+					commLinkRepairPeriod = initialCommLinkRepairPeriod
+					repairTimer.Reset(commLinkRepairPeriod)
+				}
 			}
 		case _ = <-repairTimer.C:
 			for process, _ := range faultyCommLinks {
-				err := repairCommLinkFunc(process)
+				err := t.repairCommLinkFunc(process)
 				if err != nil {
 					continue
 				}
@@ -73,7 +85,7 @@ func repairCommLinkLoop() {
 
 			if commLinkRepairPeriod > maxCommLinkRepairPeriod {
 				for process, _ := range faultyCommLinks {
-					deleteCommLink(process)
+					t.deleteCommLink(process)
 				}
 			}
 
@@ -0,0 +1,203 @@
+package comm
+
+import (
+	"context"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// TCPTransport is a Transport that sends the requests with net/rpc over TCP.
+// It keeps a connection to each process it talks to, and repairs the
+// connections that fail in the background. It also sends heartbeats through
+// each connection, and suspects the processes that stop answering them.
+type TCPTransport struct {
+	config TCPConfig
+
+	// commLinkTable and faultyLinks, the links waiting for repairCommLinkLoop, are protected by commLinkTableMu
+	commLinkTable   map[view.Process]communicationLink
+	faultyLinks     map[view.Process]bool
+	commLinkTableMu sync.Mutex
+
+	// repairSignal tells repairCommLinkLoop that faultyLinks has new links
+	repairSignal chan struct{}
+}
+
+var _ Transport = new(TCPTransport)
+
+// TCPConfig sets up a TCPTransport. The zero values are replaced by the defaults.
+type TCPConfig struct {
+	// CallTimeout is how long a call waits for its answer before it fails
+	// with an UnreachableError. If zero, it is 10s; if negative, calls wait
+	// until their context is done.
+	CallTimeout time.Duration
+
+	// HeartbeatPeriod is the time between the heartbeats sent to each
+	// process, and how long a heartbeat waits for its answer. If zero, it is
+	// 500ms.
+	HeartbeatPeriod time.Duration
+
+	// SuspectAfter is how many heartbeats in a row a process must miss to be
+	// suspected. If zero, it is 3.
+	SuspectAfter int
+}
+
+// NewTCPTransport returns a new TCPTransport with the default configuration.
+func NewTCPTransport() *TCPTransport {
+	return NewTCPTransportWithConfig(TCPConfig{})
+}
+
+// NewTCPTransportWithConfig returns a new TCPTransport set up by config.
+func NewTCPTransportWithConfig(config TCPConfig) *TCPTransport {
+	if config.CallTimeout == 0 {
+		config.CallTimeout = 10 * time.Second
+	}
+	if config.HeartbeatPeriod == 0 {
+		config.HeartbeatPeriod = 500 * time.Millisecond
+	}
+	if config.SuspectAfter == 0 {
+		config.SuspectAfter = 3
+	}
+
+	t := &TCPTransport{
+		config:        config,
+		commLinkTable: make(map[view.Process]communicationLink),
+		faultyLinks:   make(map[view.Process]bool),
+		repairSignal:  make(chan struct{}, 1),
+	}
+	go t.repairCommLinkLoop()
+	return t
+}
+
+type communicationLink struct {
+	process   view.Process
+	rpcClient *rpc.Client
+	monitor   *heartbeatMonitor
+}
+
+func (commLink communicationLink) isFaulty() bool {
+	return commLink.rpcClient == nil
+}
+
+func (t *TCPTransport) Call(ctx context.Context, process view.Process, serviceMethod string, arg interface{}, result interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return CanceledError{Err: err}
+	}
+
+	commLink, err := t.getCommLink(ctx, process)
+	if err != nil {
+		return err
+	}
+	if commLink.isFaulty() {
+		return UnreachableError{Process: process}
+	}
+	suspected, suspectedChan := commLink.monitor.suspicion()
+	if suspected {
+		return UnreachableError{Process: process, Method: serviceMethod, Cause: "process is suspected by the failure detector"}
+	}
+
+	err, linkFailed := rpcCall(ctx, commLink.rpcClient, process, serviceMethod, arg, result, suspectedChan, t.config.CallTimeout)
+	if linkFailed {
+		t.setCommLinkFaulty(process)
+	}
+	return err
+}
+
+func (t *TCPTransport) Listen(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+// getCommLink returns the link to process, creating it if there is none. The
+// connection of a new link is dialed without holding commLinkTableMu, and
+// gives up when ctx is done or after CallTimeout.
+func (t *TCPTransport) getCommLink(ctx context.Context, process view.Process) (communicationLink, error) {
+	t.commLinkTableMu.Lock()
+	commLink, ok := t.commLinkTable[process]
+	t.commLinkTableMu.Unlock()
+	if ok {
+		return commLink, nil
+	}
+
+	newRpcClient, err := t.dial(ctx, process)
+	if err != nil {
+		if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+			// the dial gives up at the deadline of ctx, maybe just before ctx is done
+			<-ctx.Done()
+		}
+		if ctx.Err() != nil {
+			return communicationLink{}, CanceledError{Err: ctx.Err()}
+		}
+	}
+
+	t.commLinkTableMu.Lock()
+	defer t.commLinkTableMu.Unlock()
+
+	if commLink, ok := t.commLinkTable[process]; ok {
+		// another call created the link meanwhile
+		if newRpcClient != nil {
+			newRpcClient.Close()
+		}
+		return commLink, nil
+	}
+
+	commLink = communicationLink{process: process, rpcClient: newRpcClient, monitor: newHeartbeatMonitor()}
+	go t.heartbeatLoop(process, commLink.monitor)
+	t.commLinkTable[process] = commLink
+	if commLink.isFaulty() {
+		t.requestRepairLocked(process)
+	}
+	return commLink, nil
+}
+
+// dial connects to process, giving up when ctx is done or after CallTimeout.
+func (t *TCPTransport) dial(ctx context.Context, process view.Process) (*rpc.Client, error) {
+	if t.config.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.config.CallTimeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", process.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return rpc.NewClient(conn), nil
+}
+
+func (t *TCPTransport) setCommLinkFaulty(process view.Process) {
+	t.commLinkTableMu.Lock()
+	defer t.commLinkTableMu.Unlock()
+
+	commLink, ok := t.commLinkTable[process]
+	if !ok {
+		return
+	}
+	commLink.rpcClient = nil
+	t.commLinkTable[process] = commLink
+
+	t.requestRepairLocked(process)
+}
+
+// requestRepairLocked hands the link to process to repairCommLinkLoop. It never blocks.
+func (t *TCPTransport) requestRepairLocked(process view.Process) {
+	t.faultyLinks[process] = true
+	select {
+	case t.repairSignal <- struct{}{}:
+	default:
+		// repairCommLinkLoop is already signaled, it will find the link in faultyLinks
+	}
+}
+
+func (t *TCPTransport) deleteCommLink(process view.Process) {
+	t.commLinkTableMu.Lock()
+	defer t.commLinkTableMu.Unlock()
+
+	if commLink, ok := t.commLinkTable[process]; ok {
+		commLink.monitor.close()
+		delete(t.commLinkTable, process)
+	}
+}
@@ -0,0 +1,63 @@
+package comm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/rpc"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// Transport carries the RPC requests between freestore's processes. Clients,
+// servers and consensus send all their requests through a Transport, and
+// servers accept the requests of the others with its Listen.
+type Transport interface {
+	// Call invokes serviceMethod at process with arg and puts the answer at
+	// result. It returns a CanceledError if ctx is done first and an
+	// UnreachableError if process cannot be reached. result must not be used
+	// if an error is returned, as the answer may still arrive later.
+	Call(ctx context.Context, process view.Process, serviceMethod string, arg interface{}, result interface{}) error
+
+	// Listen returns a listener for the connections made by Call to addr. The
+	// address of the listener is the address of the process.
+	Listen(addr string) (net.Listener, error)
+}
+
+// DefaultTransport is the Transport used when none is given. It sends the requests with net/rpc over TCP.
+var DefaultTransport Transport = NewTCPTransport()
+
+// rpcCall invokes serviceMethod with rpcClient and waits for the answer or for ctx to be done.
+// It gives up with an UnreachableError when suspectedChan is closed or, if timeout is positive, after timeout.
+// linkFailed tells if the error returned means that the connection of rpcClient is broken.
+func rpcCall(ctx context.Context, rpcClient *rpc.Client, process view.Process, serviceMethod string, arg interface{}, result interface{}, suspectedChan <-chan struct{}, timeout time.Duration) (err error, linkFailed bool) {
+	var timeoutChan <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutChan = timer.C
+	}
+
+	call := rpcClient.Go(serviceMethod, arg, result, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+	case <-ctx.Done():
+		// the process may just be slow, the link is not faulty
+		return CanceledError{Err: ctx.Err()}, false
+	case <-timeoutChan:
+		// the failure detector decides whether the process failed
+		return UnreachableError{Process: process, Method: serviceMethod, Cause: fmt.Sprintf("no answer after %v", timeout)}, false
+	case <-suspectedChan:
+		return UnreachableError{Process: process, Method: serviceMethod, Cause: "process is suspected by the failure detector"}, false
+	}
+
+	if serverErr, ok := call.Error.(rpc.ServerError); ok {
+		return fmt.Errorf("%v call to process %v failed: %w", serviceMethod, process, serverErr), false
+	}
+	if call.Error != nil {
+		return UnreachableError{Process: process, Method: serviceMethod, Cause: call.Error.Error()}, true
+	}
+
+	return nil, false
+}
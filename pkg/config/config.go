@@ -0,0 +1,339 @@
+// Package config loads the configuration of freestore's commands.
+//
+// The configuration is a JSON file, whose values may be overridden by
+// environment variables:
+//
+//	{
+//		"seeds": ["10.1.1.2:5000", "10.1.1.3:5000", "10.1.1.4:5000"],
+//		"bind": "[::]:5000",
+//		"advertise": "10.1.1.2:5000",
+//		"data_dir": "/var/lib/freestore",
+//		"consensus": true,
+//		"reconfiguration_period": "1m",
+//		"call_timeout": "10s",
+//		"log_level": "info"
+//	}
+//
+// The seeds are the members of the initial view of the system. A server that
+// is a seed starts as a member; the others, and the clients, get the current
+// view from the seeds. With "wait_for_init": true, the server does not use the
+// seeds and waits for freestore_admin init to give it the genesis view.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// Config is the configuration of freestore's servers and clients. The
+// clients only use Seeds, the timeouts and LogLevel.
+type Config struct {
+	// Seeds are the addresses of the members of the initial view.
+	Seeds []string `json:"seeds"`
+	// WaitForInit makes the server wait for the genesis view of freestore_admin init instead of starting with the seeds.
+	WaitForInit bool `json:"wait_for_init"`
+
+	// BindAddr is the address the server listens to.
+	BindAddr string `json:"bind"`
+	// AdvertiseAddr is the address the other processes use to reach the server. If empty, it is BindAddr.
+	AdvertiseAddr string `json:"advertise"`
+	// NodeID names the node of the server in the views. If empty, the node is named by its address.
+	NodeID string `json:"node_id"`
+	// DataDir is where the server keeps its state. If empty, the state is kept in memory only.
+	DataDir string `json:"data_dir"`
+	// UseConsensus makes the server use consensus on reconfigurations.
+	UseConsensus bool `json:"consensus"`
+	// ReadsDuringReconfiguration makes the server answer reads while its state is transferred to a new view.
+	ReadsDuringReconfiguration bool `json:"reads_during_reconfiguration"`
+	// ReconfigurationPeriod is the time between reconfigurations. If zero, the server default is used.
+	ReconfigurationPeriod Duration `json:"reconfiguration_period"`
+	// MaxConcurrentRequests is the number of register requests a server handles at a time. Zero means no limit.
+	MaxConcurrentRequests int `json:"max_requests"`
+	// RemoveSuspectedAfter enables the removal of the servers suspected for this long. Zero disables it.
+	RemoveSuspectedAfter Duration `json:"remove_suspected_after"`
+	// MinViewSize is the least number of members a removal of a suspected server may leave.
+	MinViewSize int `json:"min_view_size"`
+	// LeaveTimeout is how long a server waits to leave the view on interrupt before closing anyway.
+	LeaveTimeout Duration `json:"leave_timeout"`
+
+	// CallTimeout is how long a request waits for its answer. If zero, the transport default is used.
+	CallTimeout Duration `json:"call_timeout"`
+	// HeartbeatPeriod is the time between the heartbeats of the failure detector. If zero, the transport default is used.
+	HeartbeatPeriod Duration `json:"heartbeat_period"`
+
+	// LogLevel is the least severity of the logged messages: debug, info, warn or error.
+	LogLevel string `json:"log_level"`
+}
+
+// Default returns the configuration used when there is no configuration
+// file: three seeds on the local machine, at the ports 5000 to 5002.
+func Default() Config {
+	return Config{
+		Seeds:        []string{"[::]:5000", "[::]:5001", "[::]:5002"},
+		BindAddr:     "[::]:5000",
+		MinViewSize:  3,
+		LeaveTimeout: Duration(30 * time.Second),
+		LogLevel:     "info",
+	}
+}
+
+// Duration is a time.Duration written as a string, such as "1m30s", in the configuration file.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"10s\": %v", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Load returns the default configuration overridden by the file at path, if
+// path is not empty, and then by the environment variables. The result is
+// validated.
+func Load(path string) (Config, error) {
+	config, err := LoadUnvalidated(path)
+	if err != nil {
+		return Config{}, err
+	}
+	if err := config.Validate(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// LoadUnvalidated is like Load, but it does not validate the result, so the
+// caller can override it further, as with command line flags, before calling
+// Validate.
+func LoadUnvalidated(path string) (Config, error) {
+	config := Default()
+
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return Config{}, err
+		}
+		defer file.Close()
+
+		if err := decode(file, &config); err != nil {
+			return Config{}, fmt.Errorf("config %v: %v", path, err)
+		}
+	}
+
+	if err := config.applyEnv(os.LookupEnv); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+func decode(r io.Reader, config *Config) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(config)
+}
+
+// EnvPrefix starts the names of the environment variables that override the
+// configuration. The name of a variable is the prefix followed by the JSON
+// name of the field in upper case, such as FREESTORE_DATA_DIR. The seeds are
+// separated by commas.
+const EnvPrefix = "FREESTORE_"
+
+func (config *Config) applyEnv(lookupEnv func(string) (string, bool)) error {
+	stringVars := map[string]*string{
+		"BIND":      &config.BindAddr,
+		"ADVERTISE": &config.AdvertiseAddr,
+		"NODE_ID":   &config.NodeID,
+		"DATA_DIR":  &config.DataDir,
+		"LOG_LEVEL": &config.LogLevel,
+	}
+	for name, field := range stringVars {
+		if value, ok := lookupEnv(EnvPrefix + name); ok {
+			*field = value
+		}
+	}
+
+	if value, ok := lookupEnv(EnvPrefix + "SEEDS"); ok {
+		config.Seeds = nil
+		for _, seed := range strings.Split(value, ",") {
+			if seed = strings.TrimSpace(seed); seed != "" {
+				config.Seeds = append(config.Seeds, seed)
+			}
+		}
+	}
+
+	boolVars := map[string]*bool{
+		"CONSENSUS":                    &config.UseConsensus,
+		"WAIT_FOR_INIT":                &config.WaitForInit,
+		"READS_DURING_RECONFIGURATION": &config.ReadsDuringReconfiguration,
+	}
+	for name, field := range boolVars {
+		if value, ok := lookupEnv(EnvPrefix + name); ok {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%v%v: %v", EnvPrefix, name, err)
+			}
+			*field = b
+		}
+	}
+
+	intVars := map[string]*int{
+		"MAX_REQUESTS":  &config.MaxConcurrentRequests,
+		"MIN_VIEW_SIZE": &config.MinViewSize,
+	}
+	for name, field := range intVars {
+		if value, ok := lookupEnv(EnvPrefix + name); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%v%v: %v", EnvPrefix, name, err)
+			}
+			*field = n
+		}
+	}
+
+	durationVars := map[string]*Duration{
+		"RECONFIGURATION_PERIOD": &config.ReconfigurationPeriod,
+		"REMOVE_SUSPECTED_AFTER": &config.RemoveSuspectedAfter,
+		"LEAVE_TIMEOUT":          &config.LeaveTimeout,
+		"CALL_TIMEOUT":           &config.CallTimeout,
+		"HEARTBEAT_PERIOD":       &config.HeartbeatPeriod,
+	}
+	for name, field := range durationVars {
+		if value, ok := lookupEnv(EnvPrefix + name); ok {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("%v%v: %v", EnvPrefix, name, err)
+			}
+			*field = Duration(d)
+		}
+	}
+	return nil
+}
+
+// Validate returns an error describing the first inconsistency of config.
+func (config Config) Validate() error {
+	if len(config.Seeds) == 0 && !config.WaitForInit {
+		return errors.New("config: no seeds, at least one member of the initial view is required")
+	}
+
+	for i, seed := range config.Seeds {
+		if _, _, err := net.SplitHostPort(seed); err != nil {
+			return fmt.Errorf("config: seed %q is not a host:port address: %v", seed, err)
+		}
+
+		seedProcess := view.Process{Addr: seed}
+		for _, otherSeed := range config.Seeds[:i] {
+			if seedProcess.SameAs(view.Process{Addr: otherSeed}) {
+				return fmt.Errorf("config: seeds %q and %q are the same process", otherSeed, seed)
+			}
+		}
+		// the seeds at [::] can only be reached from their machine, so all of them must be there
+		if seedProcess.HasUnspecifiedHost() != (view.Process{Addr: config.Seeds[0]}).HasUnspecifiedHost() {
+			return fmt.Errorf("config: seeds %q and %q cannot be reached from the same machines, give every seed a routable host", config.Seeds[0], seed)
+		}
+	}
+
+	if config.BindAddr != "" {
+		if _, _, err := net.SplitHostPort(config.BindAddr); err != nil {
+			return fmt.Errorf("config: bind address %q is not a host:port address: %v", config.BindAddr, err)
+		}
+	}
+	if config.AdvertiseAddr != "" {
+		if _, _, err := net.SplitHostPort(config.AdvertiseAddr); err != nil {
+			return fmt.Errorf("config: advertise address %q is not a host:port address: %v", config.AdvertiseAddr, err)
+		}
+		if (view.Process{Addr: config.AdvertiseAddr}).HasUnspecifiedHost() {
+			return fmt.Errorf("config: advertise address %q has no routable host", config.AdvertiseAddr)
+		}
+	}
+
+	if config.MinViewSize < 0 || config.MaxConcurrentRequests < 0 {
+		return errors.New("config: min_view_size and max_requests cannot be negative")
+	}
+	if config.RemoveSuspectedAfter > 0 && !config.WaitForInit && config.MinViewSize > len(config.Seeds) {
+		return fmt.Errorf("config: min_view_size %v is larger than the %v seeds, no crashed seed could be removed", config.MinViewSize, len(config.Seeds))
+	}
+
+	if _, ok := logLevels[config.LogLevel]; !ok {
+		return fmt.Errorf("config: unknown log level %q, use debug, info, warn or error", config.LogLevel)
+	}
+	return nil
+}
+
+// Process returns the process of a server with this configuration.
+func (config Config) Process() view.Process {
+	process := view.Process{Addr: config.BindAddr, ID: config.NodeID}
+	if config.AdvertiseAddr != "" {
+		process.Addr = config.AdvertiseAddr
+	}
+	return process
+}
+
+// SeedView returns the initial view, whose members are the seeds.
+func (config Config) SeedView() *view.View {
+	var processes []view.Process
+	for _, seed := range config.Seeds {
+		processes = append(processes, view.Process{Addr: seed})
+	}
+	return view.NewWithProcesses(processes...)
+}
+
+// SeedProcesses returns the processes of the seeds.
+func (config Config) SeedProcesses() []view.Process {
+	return config.SeedView().GetMembers()
+}
+
+// ----- Logging -----
+
+var logLevels = map[string]int{"debug": 0, "info": 1, "warn": 2, "error": 3}
+
+// SetupLogging makes the standard logger drop the messages less severe than
+// config.LogLevel. The severity of a message is told by its prefix, as in
+// "WARN: ..." or "FATAL: ..."; messages without one are info. Messages logged
+// before exiting, as with log.Fatal, must have the "FATAL:" prefix so they are
+// never dropped.
+func (config Config) SetupLogging() {
+	log.SetOutput(&levelWriter{out: log.Writer(), level: logLevels[config.LogLevel]})
+}
+
+type levelWriter struct {
+	out   io.Writer
+	level int
+}
+
+func (w *levelWriter) Write(line []byte) (int, error) {
+	if messageLevel(string(line)) < w.level {
+		return len(line), nil
+	}
+	return w.out.Write(line)
+}
+
+func messageLevel(line string) int {
+	switch {
+	case strings.Contains(line, "FATAL"), strings.Contains(line, "ERROR"):
+		return logLevels["error"]
+	case strings.Contains(line, "WARN"):
+		return logLevels["warn"]
+	case strings.Contains(line, "DEBUG"):
+		return logLevels["debug"]
+	}
+	return logLevels["info"]
+}
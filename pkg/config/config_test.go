@@ -0,0 +1,153 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "freestore-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "freestore.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, `{
+		"seeds": ["10.1.1.2:5000", "10.1.1.3:5000", "10.1.1.4:5000"],
+		"bind": "[::]:5000",
+		"advertise": "10.1.1.2:5000",
+		"consensus": true,
+		"reconfiguration_period": "30s",
+		"call_timeout": "2s"
+	}`)
+
+	config, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Seeds) != 3 || config.AdvertiseAddr != "10.1.1.2:5000" || !config.UseConsensus {
+		t.Errorf("unexpected config %+v", config)
+	}
+	if time.Duration(config.ReconfigurationPeriod) != 30*time.Second || time.Duration(config.CallTimeout) != 2*time.Second {
+		t.Errorf("unexpected durations %v and %v", config.ReconfigurationPeriod, config.CallTimeout)
+	}
+	// the values missing in the file keep their defaults
+	if config.LogLevel != "info" || time.Duration(config.LeaveTimeout) != 30*time.Second {
+		t.Errorf("expected the defaults, got %q and %v", config.LogLevel, config.LeaveTimeout)
+	}
+
+	if !config.SeedView().HasMember(view.Process{Addr: "10.1.1.3:5000"}) || config.Process() != (view.Process{Addr: "10.1.1.2:5000"}) {
+		t.Errorf("unexpected seed view %v or process %v", config.SeedView(), config.Process())
+	}
+}
+
+// TestLoadUnvalidated checks that a file that is only valid once completed by
+// the command line flags, as one without seeds for a server waiting for init,
+// is loaded.
+func TestLoadUnvalidated(t *testing.T) {
+	path := writeConfig(t, `{"seeds": [], "data_dir": "/var/lib/freestore"}`)
+
+	if _, err := Load(path); err == nil {
+		t.Fatal("expected Load to reject a config without seeds")
+	}
+	config, err := LoadUnvalidated(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.WaitForInit = true
+	if err := config.Validate(); err != nil || config.DataDir != "/var/lib/freestore" {
+		t.Errorf("expected a valid config with the data dir of the file, got %+v and %v", config, err)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	config := Config{Seeds: []string{"10.1.1.2:5000"}, DataDir: "/from/file", LogLevel: "info"}
+
+	env := map[string]string{
+		"FREESTORE_SEEDS":            "10.1.1.5:5000, 10.1.1.6:5000",
+		"FREESTORE_DATA_DIR":         "/from/env",
+		"FREESTORE_CONSENSUS":        "true",
+		"FREESTORE_HEARTBEAT_PERIOD": "250ms",
+
+		"FREESTORE_READS_DURING_RECONFIGURATION": "1",
+	}
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	if err := config.applyEnv(lookupEnv); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Seeds) != 2 || config.Seeds[1] != "10.1.1.6:5000" {
+		t.Errorf("expected the seeds of the environment, got %v", config.Seeds)
+	}
+	if config.DataDir != "/from/env" || !config.UseConsensus || !config.ReadsDuringReconfiguration || time.Duration(config.HeartbeatPeriod) != 250*time.Millisecond {
+		t.Errorf("environment not applied: %+v", config)
+	}
+
+	env["FREESTORE_MIN_VIEW_SIZE"] = "three"
+	if err := config.applyEnv(lookupEnv); err == nil || !strings.Contains(err.Error(), "FREESTORE_MIN_VIEW_SIZE") {
+		t.Errorf("expected an error naming the variable, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		content string
+		err     string
+	}{
+		{`{"seeds": []}`, "no seeds"},
+		{`{"seeds": ["10.1.1.2"]}`, "not a host:port"},
+		{`{"seeds": ["10.1.1.2:5000", "10.1.1.2:5000"]}`, "same process"},
+		{`{"seeds": ["localhost:5000", "127.0.0.1:5000"]}`, "same process"},
+		{`{"seeds": ["10.1.1.2:5000", "[::]:5001"]}`, "routable"},
+		{`{"seeds": ["10.1.1.2:5000"], "advertise": "[::]:5000"}`, "no routable host"},
+		{`{"seeds": ["10.1.1.2:5000"], "remove_suspected_after": "10s", "min_view_size": 3}`, "min_view_size"},
+		{`{"seeds": ["10.1.1.2:5000"], "log_level": "loud"}`, "log level"},
+		{`{"seeds": ["10.1.1.2:5000"], "call_timeout": 10}`, "duration"},
+		{`{"seeds": ["10.1.1.2:5000"], "bnid": "[::]:5000"}`, "unknown field"},
+	}
+	for _, test := range tests {
+		_, err := Load(writeConfig(t, test.content))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: expected an error with %q, got %v", test.content, test.err, err)
+		}
+	}
+
+	if err := Default().Validate(); err != nil {
+		t.Errorf("default config is not valid: %v", err)
+	}
+	// the genesis view of a server waiting for init comes from freestore_admin, not from the seeds
+	if err := (Config{WaitForInit: true, LogLevel: "info"}).Validate(); err != nil {
+		t.Errorf("config waiting for init without seeds is not valid: %v", err)
+	}
+}
+
+func TestLevelWriter(t *testing.T) {
+	out := new(bytes.Buffer)
+	w := &levelWriter{out: out, level: logLevels["warn"]}
+
+	w.Write([]byte("2024/01/01 10:00:00 CurrentView updated\n"))
+	w.Write([]byte("2024/01/01 10:00:00 WARN: Broadcast failed\n"))
+	w.Write([]byte("2024/01/01 10:00:00 FATAL: failed to save current view\n"))
+
+	if got := out.String(); strings.Contains(got, "CurrentView") || !strings.Contains(got, "WARN") || !strings.Contains(got, "FATAL") {
+		t.Errorf("expected only the warning and the error, got %q", got)
+	}
+}
@@ -2,6 +2,7 @@
 package consensus
 
 import (
+	"context"
 	"encoding/gob"
 	"errors"
 	"fmt"
@@ -9,70 +10,217 @@ import (
 	"net/rpc"
 	"sync"
 
+	"github.com/cznic/kv"
 	"github.com/mateusbraga/freestore/pkg/comm"
 	"github.com/mateusbraga/freestore/pkg/view"
 )
 
 const CHANNEL_DEFAULT_BUFFER_SIZE = 20
 
-var oldProposalNumberErr OldProposalNumberError
+// Consensus runs the consensus instances of a process. Each process, even
+// when many of them run in the same Go process, has its own Consensus.
+type Consensus struct {
+	// transport carries the requests this process sends to the others
+	transport comm.Transport
 
-var (
-	consensusTable   = make(map[int]consensusInstance)
+	// storage keeps the acceptor state and the proposal numbers
+	storage *kv.DB
+
+	// consensusTable has the consensus instance of each associated view
+	consensusTable   map[view.ViewRef]consensusInstance
 	consensusTableMu sync.RWMutex
-)
+	// releasedView is the view of the last Release, protected by consensusTableMu. The instances of older views are not created again.
+	releasedView *view.View
+
+	// proposalNumberMu serializes the updates of the last proposal numbers in storage
+	proposalNumberMu sync.Mutex
+
+	// done is closed by Close to stop the workers, which are tracked by workers. closed is protected by consensusTableMu.
+	done    chan struct{}
+	closed  bool
+	workers sync.WaitGroup
+}
+
+// ErrClosed is returned by the requests that arrive after Close.
+var ErrClosed = errors.New("consensus closed")
+
+// ErrReleased is returned by the requests to a consensus instance that was released.
+var ErrReleased = errors.New("consensus instance released")
+
+// New returns a Consensus that sends its requests through transport, or
+// comm.DefaultTransport if it is nil, and keeps its state in memory. It is not fault tolerant: a restarted acceptor
+// forgets its promises.
+func New(transport comm.Transport) (*Consensus, error) {
+	storage, err := kv.CreateMem(new(kv.Options))
+	if err != nil {
+		return nil, err
+	}
+	return newConsensus(transport, storage), nil
+}
+
+// Open returns a Consensus that sends its requests through transport and
+// keeps its state in a file in dir, so that promises and accepted proposals
+// survive restarts. The state already saved in dir is recovered.
+func Open(dir string, transport comm.Transport) (*Consensus, error) {
+	storage, err := openStorage(dir)
+	if err != nil {
+		return nil, err
+	}
+	return newConsensus(transport, storage), nil
+}
+
+func newConsensus(transport comm.Transport, storage *kv.DB) *Consensus {
+	if transport == nil {
+		transport = comm.DefaultTransport
+	}
+	return &Consensus{
+		transport:      transport,
+		storage:        storage,
+		consensusTable: make(map[view.ViewRef]consensusInstance),
+		done:           make(chan struct{}),
+	}
+}
+
+// Close stops the consensus instances of c and closes its storage. Propose
+// must not be running or be called after Close.
+func (c *Consensus) Close() error {
+	c.consensusTableMu.Lock()
+	if c.closed {
+		c.consensusTableMu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	c.consensusTableMu.Unlock()
+
+	c.workers.Wait()
+	return c.storage.Close()
+}
+
+// Release stops the consensus instances whose associated views are older than
+// installedView: once a process installs a view, the instances of the views
+// it replaced are over, and a late request for one of them is rejected with
+// ErrReleased instead of creating it again.
+func (c *Consensus) Release(installedView *view.View) {
+	c.consensusTableMu.Lock()
+	defer c.consensusTableMu.Unlock()
+
+	if c.releasedView == nil || installedView.MoreUpdatedThan(c.releasedView) {
+		c.releasedView = installedView
+	}
+
+	for viewRef, ci := range c.consensusTable {
+		if installedView.MoreUpdatedThan(ci.associatedView) {
+			close(ci.released)
+			delete(c.consensusTable, viewRef)
+		}
+	}
+}
+
+// NumberOfInstances returns the number of consensus instances that were not released.
+func (c *Consensus) NumberOfInstances() int {
+	c.consensusTableMu.RLock()
+	defer c.consensusTableMu.RUnlock()
+	return len(c.consensusTable)
+}
+
+// RegisterService registers the consensus RPC service of c on rpcServer.
+func (c *Consensus) RegisterService(rpcServer *rpc.Server) error {
+	return rpcServer.Register(&ConsensusRequest{consensus: c})
+}
 
 type consensusInstance struct {
 	associatedView    *view.View
 	taskChan          chan consensusTask
 	callbackLearnChan chan interface{}
+	// released is closed by Release to stop the worker of the instance
+	released chan struct{}
 
 	// used to compute reconfiguration duration
 	//startTime time.Time
 }
 
-func (ci consensusInstance) Id() int {
-	return ci.associatedView.NumberOfUpdates()
+// Id identifies the instance in the storage. Different views never share an instance, even if they have the same number of updates.
+func (ci consensusInstance) Id() view.ViewRef {
+	return ci.associatedView.ViewRef
 }
 
 type consensusTask interface{}
 
-func GetConsensusResultChan(associatedView *view.View) chan interface{} {
-	ci := getOrCreateConsensus(associatedView)
+// GetConsensusResultChan returns the channel that receives the value chosen
+// in the instance of associatedView, or nil if the instance was released.
+func (c *Consensus) GetConsensusResultChan(associatedView *view.View) chan interface{} {
+	ci, ok := c.getOrCreateConsensus(associatedView)
+	if !ok {
+		return nil
+	}
 	return ci.callbackLearnChan
 }
 
 // used to compute reconfiguration duration
 //func GetConsensusStartTime(associatedView *view.View) time.Time {
-	//ci := getOrCreateConsensus(associatedView)
-	//return ci.startTime
+//ci := getOrCreateConsensus(associatedView)
+//return ci.startTime
 //}
 
-func getOrCreateConsensus(associatedView *view.View) consensusInstance {
-	consensusTableMu.Lock()
-	defer consensusTableMu.Unlock()
+// getOrCreateConsensus returns the consensus instance of associatedView. It
+// returns false if the instance was released.
+func (c *Consensus) getOrCreateConsensus(associatedView *view.View) (consensusInstance, bool) {
+	c.consensusTableMu.Lock()
+	defer c.consensusTableMu.Unlock()
 
-	ci, ok := consensusTable[associatedView.NumberOfUpdates()]
+	ci, ok := c.consensusTable[associatedView.ViewRef]
 	if !ok {
-		ci = consensusInstance{associatedView: associatedView, taskChan: make(chan consensusTask, CHANNEL_DEFAULT_BUFFER_SIZE), callbackLearnChan: make(chan interface{}, 1)}
+		if c.releasedView != nil && c.releasedView.MoreUpdatedThan(associatedView) {
+			return consensusInstance{}, false
+		}
+
+		ci = consensusInstance{associatedView: associatedView, taskChan: make(chan consensusTask, CHANNEL_DEFAULT_BUFFER_SIZE), callbackLearnChan: make(chan interface{}, 1), released: make(chan struct{})}
 		//ci.startTime = time.Now()
-		consensusTable[associatedView.NumberOfUpdates()] = ci
+		c.consensusTable[associatedView.ViewRef] = ci
 		log.Println("Created consensus instance:", ci)
 
-		go consensusWorker(ci)
+		if !c.closed {
+			c.workers.Add(1)
+			go func() {
+				defer c.workers.Done()
+				c.consensusWorker(ci)
+			}()
+		}
 	}
-	return ci
+	return ci, true
 }
 
-func consensusWorker(ci consensusInstance) {
-	var acceptedProposal Proposal     // highest numbered accepted proposal
-	var lastPromiseProposalNumber int // highest numbered prepare request
-	var learnCounter int              // number of learn requests received
+func (c *Consensus) consensusWorker(ci consensusInstance) {
+	var acceptedProposal Proposal                   // highest numbered accepted proposal
+	var lastPromiseProposalNumber int               // highest numbered prepare request
+	learners := make(map[int]map[view.Process]bool) // acceptors that sent a learn request for each proposal number
+	var learned bool                                // tells if the chosen value was sent to callbackLearnChan
+
+	// recover the acceptor state in case this process restarted
+	if savedPrepareRequest, ok := c.getPrepareRequestFromStorage(ci); ok {
+		lastPromiseProposalNumber = savedPrepareRequest.N
+	}
+	if savedAcceptedProposal, ok := c.getAcceptedProposalFromStorage(ci); ok {
+		acceptedProposal = savedAcceptedProposal
+		// accepting a proposal promises not to accept lower numbered ones
+		if acceptedProposal.N > lastPromiseProposalNumber {
+			lastPromiseProposalNumber = acceptedProposal.N
+		}
+	}
 
 	for {
-		taskInterface, ok := <-ci.taskChan
-		if !ok {
-			log.Printf("consensus instance %v done\n", ci)
+		var taskInterface consensusTask
+		select {
+		case task, ok := <-ci.taskChan:
+			if !ok {
+				log.Printf("consensus instance %v done\n", ci)
+				return
+			}
+			taskInterface = task
+		case <-ci.released:
+			return
+		case <-c.done:
 			return
 		}
 
@@ -83,13 +231,13 @@ func consensusWorker(ci consensusInstance) {
 
 			if receivedPrepareRequest.N > lastPromiseProposalNumber {
 				//setLastPromiseProposalNumber
-				savePrepareRequestOnStorage(ci, receivedPrepareRequest)
+				c.savePrepareRequestOnStorage(ci, receivedPrepareRequest)
 				lastPromiseProposalNumber = receivedPrepareRequest.N
 
 				receivedPrepareRequest.reply.N = acceptedProposal.N
 				receivedPrepareRequest.reply.Value = acceptedProposal.Value
 			} else {
-				receivedPrepareRequest.reply.Err = oldProposalNumberErr
+				receivedPrepareRequest.reply.Err = OldProposalNumberError{Promised: lastPromiseProposalNumber}
 			}
 
 			receivedPrepareRequest.returnChan <- true
@@ -97,14 +245,16 @@ func consensusWorker(ci consensusInstance) {
 			//log.Println("Processing accept request")
 			receivedAcceptRequest := task
 
-			if receivedAcceptRequest.N >= lastPromiseProposalNumber {
+			// a delayed accept of a lower numbered proposal must not replace the accepted one, which may be chosen
+			if receivedAcceptRequest.N >= lastPromiseProposalNumber && receivedAcceptRequest.N >= acceptedProposal.N {
 				//setAcceptedProposal
-				saveAcceptedProposalOnStorage(ci, receivedAcceptRequest.Proposal)
+				c.saveAcceptedProposalOnStorage(ci, receivedAcceptRequest.Proposal)
 				acceptedProposal = *receivedAcceptRequest.Proposal
+				lastPromiseProposalNumber = receivedAcceptRequest.N
 
-				go broadcastLearnRequest(receivedAcceptRequest.AssociatedView, *receivedAcceptRequest.Proposal)
+				go c.broadcastLearnRequest(receivedAcceptRequest.AssociatedView, *receivedAcceptRequest.Proposal)
 			} else {
-				receivedAcceptRequest.reply.Err = oldProposalNumberErr
+				receivedAcceptRequest.reply.Err = OldProposalNumberError{Promised: lastPromiseProposalNumber}
 			}
 
 			receivedAcceptRequest.returnChan <- true
@@ -112,28 +262,49 @@ func consensusWorker(ci consensusInstance) {
 			//log.Println("Processing learn request")
 			receivedLearnRequest := task
 
-			learnCounter++
-			if learnCounter == receivedLearnRequest.AssociatedView.QuorumSize() {
+			if learned || !ci.associatedView.HasMember(receivedLearnRequest.Acceptor) {
+				break
+			}
+
+			// a value is chosen when a quorum of distinct acceptors accepted the same proposal
+			acceptors, ok := learners[receivedLearnRequest.N]
+			if !ok {
+				acceptors = make(map[view.Process]bool)
+				learners[receivedLearnRequest.N] = acceptors
+			}
+			acceptors[receivedLearnRequest.Acceptor] = true
+
+			if len(acceptors) == ci.associatedView.QuorumSize() {
+				learned = true
 				ci.callbackLearnChan <- receivedLearnRequest.Value
 			}
 		default:
-			log.Fatalf("BUG in the ConsensusWorker switch, got %T %v\n", task, task)
+			log.Fatalf("FATAL: BUG in the ConsensusWorker switch, got %T %v\n", task, task)
 		}
 	}
 }
 
-// Propose proposes the value to be agreed upon on this consensus instance. It should be run only by the leader process to guarantee termination.
-func Propose(associatedView *view.View, thisProcess view.Process, defaultValue interface{}) {
+// Propose proposes the value to be agreed upon on this consensus instance. It
+// should be run only by the leader process to guarantee termination. It
+// returns a comm.NoQuorumError if a majority of associatedView fails or
+// promised to a higher numbered proposal; in the latter case, the next
+// Propose of this process uses a proposal number higher than the promised one.
+// Propose may be retried, and it proposes the value already accepted by the
+// acceptors, if any, instead of defaultValue. It returns a comm.CanceledError
+// if ctx is done before a quorum answers, and ErrClosed if c is closed.
+func (c *Consensus) Propose(ctx context.Context, associatedView *view.View, thisProcess view.Process, defaultValue interface{}) error {
 	log.Println("Running propose with:", defaultValue)
 
-	proposalNumber := getNextProposalNumber(associatedView, thisProcess)
+	proposalNumber, err := c.getNextProposalNumber(associatedView, thisProcess)
+	if err != nil {
+		return err
+	}
 	proposal := Proposal{AssociatedView: associatedView, N: proposalNumber}
 
-	value, err := prepare(proposal)
+	value, err := c.prepare(ctx, proposal)
 	if err != nil {
 		// Could not get quorum or old proposal number
-		log.Fatalf("Failed to propose. Could not pass prepare phase: %v\n", err)
-		return
+		return fmt.Errorf("Failed to propose. Could not pass prepare phase: %w", err)
 	}
 
 	if value == nil {
@@ -141,40 +312,45 @@ func Propose(associatedView *view.View, thisProcess view.Process, defaultValue i
 	}
 
 	proposal.Value = value
-	if err := accept(proposal); err != nil {
+	if err := c.accept(ctx, proposal); err != nil {
 		// Could not get quorum or old proposal number
-		log.Fatalf("Failed to propose. Could not pass accept phase: %v\n", err)
-		return
+		return fmt.Errorf("Failed to propose. Could not pass accept phase: %w", err)
 	}
+	return nil
 }
 
 // prepare is a stage of the Propose funcion
-func prepare(proposal Proposal) (interface{}, error) {
+func (c *Consensus) prepare(ctx context.Context, proposal Proposal) (interface{}, error) {
 	// Send read request to all
 	resultChan := make(chan Proposal, proposal.AssociatedView.NumberOfMembers())
-	go broadcastPrepareRequest(proposal.AssociatedView, proposal, resultChan)
+	go c.broadcastPrepareRequest(ctx, proposal.AssociatedView, proposal, resultChan)
 
 	// Wait for quorum
-	var successTotal int
-	var failedTotal int
+	var answers quorumCounter
 	var highestNumberedAcceptedProposal Proposal
 	for {
-		receivedProposal := <-resultChan
+		var receivedProposal Proposal
+		select {
+		case receivedProposal = <-resultChan:
+		case <-ctx.Done():
+			return nil, comm.CanceledError{Err: ctx.Err()}
+		case <-c.done:
+			return nil, ErrClosed
+		}
 
 		if receivedProposal.Err != nil {
 			log.Println("+1 error to prepare:", receivedProposal.Err)
-			failedTotal++
+			c.skipPromisedProposalNumbers(proposal.AssociatedView, receivedProposal.Err)
 
-			if failedTotal > proposal.AssociatedView.NumberOfToleratedFaults() {
-				return nil, errors.New("Failed to get prepare quorum")
+			if answers.fail(receivedProposal.process, proposal.AssociatedView) {
+				return nil, comm.NoQuorumError{Op: "prepare", View: proposal.AssociatedView, Failed: answers.failedProcesses(), Cause: receivedProposal.Err.Error()}
 			}
 		} else {
-			successTotal++
 			if highestNumberedAcceptedProposal.N < receivedProposal.N {
 				highestNumberedAcceptedProposal = receivedProposal
 			}
 
-			if successTotal == proposal.AssociatedView.QuorumSize() {
+			if answers.succeed(receivedProposal.process, proposal.AssociatedView) {
 				return highestNumberedAcceptedProposal.Value, nil
 			}
 		}
@@ -182,40 +358,83 @@ func prepare(proposal Proposal) (interface{}, error) {
 }
 
 // accept is a stage of the Propose funcion.
-func accept(proposal Proposal) error {
+func (c *Consensus) accept(ctx context.Context, proposal Proposal) error {
 	// Send accept request to all
 	resultChan := make(chan Proposal, proposal.AssociatedView.NumberOfMembers())
-	go broadcastAcceptRequest(proposal.AssociatedView, proposal, resultChan)
+	go c.broadcastAcceptRequest(ctx, proposal.AssociatedView, proposal, resultChan)
 
 	// Wait for quorum
-	var successTotal int
-	var failedTotal int
+	var answers quorumCounter
 	for {
-		receivedProposal := <-resultChan
+		var receivedProposal Proposal
+		select {
+		case receivedProposal = <-resultChan:
+		case <-ctx.Done():
+			return comm.CanceledError{Err: ctx.Err()}
+		case <-c.done:
+			return ErrClosed
+		}
 
 		if receivedProposal.Err != nil {
-			log.Println("+1 error to prepare:", receivedProposal.Err)
-			failedTotal++
+			log.Println("+1 error to accept:", receivedProposal.Err)
+			c.skipPromisedProposalNumbers(proposal.AssociatedView, receivedProposal.Err)
 
-			if failedTotal > proposal.AssociatedView.NumberOfToleratedFaults() {
-				return errors.New("Failed to get accept quorum")
+			if answers.fail(receivedProposal.process, proposal.AssociatedView) {
+				return comm.NoQuorumError{Op: "accept", View: proposal.AssociatedView, Failed: answers.failedProcesses(), Cause: receivedProposal.Err.Error()}
 			}
 		} else {
-			successTotal++
-
-			if successTotal == proposal.AssociatedView.QuorumSize() {
+			if answers.succeed(receivedProposal.process, proposal.AssociatedView) {
 				return nil
 			}
 		}
 	}
 }
 
+// quorumCounter counts the answers of the acceptors to a phase of Propose.
+// Each acceptor is counted once, and only the members of the view count.
+type quorumCounter struct {
+	succeeded map[view.Process]bool
+	failed    map[view.Process]bool
+}
+
+// succeed counts the success of process and tells if a quorum of associatedView just succeeded.
+func (q *quorumCounter) succeed(process view.Process, associatedView *view.View) bool {
+	if q.succeeded == nil {
+		q.succeeded = make(map[view.Process]bool)
+	}
+	if q.succeeded[process] || q.failed[process] || !associatedView.HasMember(process) {
+		return false
+	}
+	q.succeeded[process] = true
+	return len(q.succeeded) == associatedView.QuorumSize()
+}
+
+// fail counts the failure of process and tells if a quorum of associatedView cannot succeed anymore.
+func (q *quorumCounter) fail(process view.Process, associatedView *view.View) bool {
+	if q.failed == nil {
+		q.failed = make(map[view.Process]bool)
+	}
+	if q.succeeded[process] || q.failed[process] || !associatedView.HasMember(process) {
+		return false
+	}
+	q.failed[process] = true
+	return len(q.failed) == associatedView.NumberOfToleratedFaults()+1
+}
+
+func (q *quorumCounter) failedProcesses() []view.Process {
+	var failed []view.Process
+	for process := range q.failed {
+		failed = append(failed, process)
+	}
+	return failed
+}
+
 // CheckForChosenValue checks to see if any value has already been agreed upon on this consensus instance.
 //func CheckForChosenValue(ci consensusInstance) (interface{}, error) {
-//proposalNumber := getNextProposalNumber(ci.associatedView)
+//proposalNumber := c.getNextProposalNumber(ci.associatedView)
 
 //proposal := Proposal{N: proposalNumber, AssociatedView: ci.associatedView}
-//value, err := prepare(proposal)
+//value, err := c.prepare(proposal)
 //if err != nil {
 //return nil, errors.New("Could not read prepare consensus")
 //}
@@ -227,26 +446,57 @@ func accept(proposal Proposal) error {
 //}
 
 // getNextProposalNumber to be used by this process. This function is a stage of the Propose funcion.
-func getNextProposalNumber(associatedView *view.View, thisProcess view.Process) (proposalNumber int) {
+func (c *Consensus) getNextProposalNumber(associatedView *view.View, thisProcess view.Process) (int, error) {
 	if associatedView.NumberOfMembers() == 0 {
-		log.Fatalln("associatedView is empty")
+		return 0, errors.New("associatedView is empty")
 	}
 
 	thisProcessPosition := associatedView.GetProcessPosition(thisProcess)
 
-	lastProposalNumber, err := getLastProposalNumber(associatedView.NumberOfUpdates())
+	c.proposalNumberMu.Lock()
+	defer c.proposalNumberMu.Unlock()
+
+	var proposalNumber int
+	lastProposalNumber, err := c.getLastProposalNumber(associatedView.ViewRef)
 	if err != nil {
 		proposalNumber = associatedView.NumberOfMembers() + thisProcessPosition
 	} else {
 		proposalNumber = (lastProposalNumber - (lastProposalNumber % associatedView.NumberOfMembers()) + associatedView.NumberOfMembers()) + thisProcessPosition
 	}
 
-	saveProposalNumberOnStorage(associatedView.NumberOfUpdates(), proposalNumber)
-	return
+	c.saveProposalNumberOnStorage(associatedView.ViewRef, proposalNumber)
+	return proposalNumber, nil
+}
+
+// skipPromisedProposalNumbers makes the next proposal number of this process
+// higher than the one an acceptor promised to, if err is an
+// OldProposalNumberError, so that a retried Propose is not rejected again.
+func (c *Consensus) skipPromisedProposalNumbers(associatedView *view.View, err error) {
+	var promised int
+	switch err := err.(type) {
+	case OldProposalNumberError:
+		promised = err.Promised
+	case *OldProposalNumberError:
+		promised = err.Promised
+	default:
+		return
+	}
+
+	c.proposalNumberMu.Lock()
+	defer c.proposalNumberMu.Unlock()
+
+	lastProposalNumber, err := c.getLastProposalNumber(associatedView.ViewRef)
+	if err == nil && lastProposalNumber >= promised {
+		return
+	}
+	c.saveProposalNumberOnStorage(associatedView.ViewRef, promised)
 }
 
 // -------- REQUESTS -----------
-type ConsensusRequest int
+// ConsensusRequest is the RPC service of a Consensus.
+type ConsensusRequest struct {
+	consensus *Consensus
+}
 
 type Proposal struct {
 	AssociatedView *view.View
@@ -256,6 +506,11 @@ type Proposal struct {
 	Value interface{} // Value proposed
 
 	Err error // Err is used to return an error related to the proposal
+
+	// Acceptor is the process an accept request is sent to, which then sends the learn requests of the proposal
+	Acceptor view.Process
+
+	process view.Process // process that answered with this proposal
 }
 
 type Prepare struct {
@@ -277,16 +532,31 @@ type Learn struct {
 // Prepare Request
 func (r *ConsensusRequest) Prepare(arg Proposal, reply *Proposal) error {
 	log.Println("New Prepare Request")
-	ci := getOrCreateConsensus(arg.AssociatedView)
+	ci, ok := r.consensus.getOrCreateConsensus(arg.AssociatedView)
+	if !ok {
+		return ErrReleased
+	}
 
 	var prepare Prepare
 	prepare.Proposal = &arg
-	prepare.returnChan = make(chan bool)
+	prepare.returnChan = make(chan bool, 1)
 	prepare.reply = reply
 
-	ci.taskChan <- &prepare
+	select {
+	case ci.taskChan <- &prepare:
+	case <-ci.released:
+		return ErrReleased
+	case <-r.consensus.done:
+		return ErrClosed
+	}
 
-	<-prepare.returnChan
+	select {
+	case <-prepare.returnChan:
+	case <-ci.released:
+		return ErrReleased
+	case <-r.consensus.done:
+		return ErrClosed
+	}
 
 	return nil
 }
@@ -294,16 +564,31 @@ func (r *ConsensusRequest) Prepare(arg Proposal, reply *Proposal) error {
 // Accept Request
 func (r *ConsensusRequest) Accept(arg Proposal, reply *Proposal) error {
 	log.Println("New Accept Request")
-	ci := getOrCreateConsensus(arg.AssociatedView)
+	ci, ok := r.consensus.getOrCreateConsensus(arg.AssociatedView)
+	if !ok {
+		return ErrReleased
+	}
 
 	var accept Accept
 	accept.Proposal = &arg
-	accept.returnChan = make(chan bool)
+	accept.returnChan = make(chan bool, 1)
 	accept.reply = reply
 
-	ci.taskChan <- &accept
+	select {
+	case ci.taskChan <- &accept:
+	case <-ci.released:
+		return ErrReleased
+	case <-r.consensus.done:
+		return ErrClosed
+	}
 
-	<-accept.returnChan
+	select {
+	case <-accept.returnChan:
+	case <-ci.released:
+		return ErrReleased
+	case <-r.consensus.done:
+		return ErrClosed
+	}
 
 	return nil
 }
@@ -311,23 +596,34 @@ func (r *ConsensusRequest) Accept(arg Proposal, reply *Proposal) error {
 // Learn Request
 func (r *ConsensusRequest) Learn(arg Proposal, reply *struct{}) error {
 	log.Println("New Learn Request")
-	ci := getOrCreateConsensus(arg.AssociatedView)
+	ci, ok := r.consensus.getOrCreateConsensus(arg.AssociatedView)
+	if !ok {
+		return ErrReleased
+	}
 
 	var learn Learn
 	learn.Proposal = &arg
 
-	ci.taskChan <- &learn
+	select {
+	case ci.taskChan <- &learn:
+	case <-ci.released:
+		return ErrReleased
+	case <-r.consensus.done:
+		return ErrClosed
+	}
 
 	return nil
 }
 
-func init() { rpc.Register(new(ConsensusRequest)) }
-
 // ------- ERRORS -----------
-type OldProposalNumberError struct{}
+
+// OldProposalNumberError is the answer of an acceptor that promised to a higher numbered prepare request, Promised.
+type OldProposalNumberError struct {
+	Promised int
+}
 
 func (e OldProposalNumberError) Error() string {
-	return fmt.Sprint("Promised to a higher numbered prepare request")
+	return fmt.Sprintf("Promised to a higher numbered prepare request: %v", e.Promised)
 }
 
 func init() {
@@ -336,34 +632,38 @@ func init() {
 }
 
 // ------- Broadcast functions -----------
-func broadcastPrepareRequest(destinationView *view.View, proposal Proposal, resultChan chan Proposal) {
+func (c *Consensus) broadcastPrepareRequest(ctx context.Context, destinationView *view.View, proposal Proposal, resultChan chan Proposal) {
 	for _, process := range destinationView.GetMembers() {
 		go func(process view.Process) {
 			var result Proposal
-			err := comm.SendRPCRequest(process, "ConsensusRequest.Prepare", proposal, &result)
+			err := c.transport.Call(ctx, process, "ConsensusRequest.Prepare", proposal, &result)
 			if err != nil {
-				resultChan <- Proposal{Err: err}
+				resultChan <- Proposal{Err: err, process: process}
 				return
 			}
+			result.process = process
 			resultChan <- result
 		}(process)
 	}
 }
 
-func broadcastAcceptRequest(destinationView *view.View, proposal Proposal, resultChan chan Proposal) {
+func (c *Consensus) broadcastAcceptRequest(ctx context.Context, destinationView *view.View, proposal Proposal, resultChan chan Proposal) {
 	for _, process := range destinationView.GetMembers() {
-		go func(process view.Process) {
+		go func(process view.Process, proposal Proposal) {
+			proposal.Acceptor = process
+
 			var result Proposal
-			err := comm.SendRPCRequest(process, "ConsensusRequest.Accept", proposal, &result)
+			err := c.transport.Call(ctx, process, "ConsensusRequest.Accept", proposal, &result)
 			if err != nil {
-				resultChan <- Proposal{Err: err}
+				resultChan <- Proposal{Err: err, process: process}
 				return
 			}
+			result.process = process
 			resultChan <- result
-		}(process)
+		}(process, proposal)
 	}
 }
 
-func broadcastLearnRequest(destinationView *view.View, proposal Proposal) {
-	comm.BroadcastRPCRequest(destinationView, "ConsensusRequest.Learn", proposal)
+func (c *Consensus) broadcastLearnRequest(destinationView *view.View, proposal Proposal) {
+	comm.Broadcast(c.transport, destinationView, "ConsensusRequest.Learn", proposal)
 }
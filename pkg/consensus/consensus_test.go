@@ -0,0 +1,243 @@
+package consensus
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/comm"
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+var (
+	process1 = view.Process{Addr: "memory:1"}
+	process2 = view.Process{Addr: "memory:2"}
+	process3 = view.Process{Addr: "memory:3"}
+	process4 = view.Process{Addr: "memory:4"}
+)
+
+// TestInstancesOfViewsWithSameNumberOfUpdates checks that two different views with the same number of updates do not share Paxos state.
+func TestInstancesOfViewsWithSameNumberOfUpdates(t *testing.T) {
+	c, err := New(comm.NewMemoryTransport())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	r := &ConsensusRequest{consensus: c}
+
+	viewA := view.NewWithProcesses(process1, process2, process3)
+	viewB := view.NewWithProcesses(process1, process2, process4)
+	if viewA.NumberOfUpdates() != viewB.NumberOfUpdates() {
+		t.Fatalf("the views must collide on the number of updates: %v and %v", viewA, viewB)
+	}
+
+	var reply Proposal
+	if err := r.Prepare(Proposal{AssociatedView: viewA, N: 8}, &reply); err != nil || reply.Err != nil {
+		t.Fatalf("prepare 8 on %v failed: %v %v", viewA, err, reply.Err)
+	}
+	if err := r.Accept(Proposal{AssociatedView: viewA, N: 8, Value: "a", Acceptor: process1}, &reply); err != nil || reply.Err != nil {
+		t.Fatalf("accept 8 on %v failed: %v %v", viewA, err, reply.Err)
+	}
+
+	// the promise and the proposal accepted on viewA do not apply to viewB
+	reply = Proposal{}
+	if err := r.Prepare(Proposal{AssociatedView: viewB, N: 3}, &reply); err != nil || reply.Err != nil {
+		t.Fatalf("prepare 3 on %v was rejected by the promise on %v: %v %v", viewB, viewA, err, reply.Err)
+	}
+	if reply.Value != nil {
+		t.Errorf("the instance of %v answered the value accepted on %v: %v", viewB, viewA, reply.Value)
+	}
+
+	// the proposal numbers are also kept apart
+	if _, err := c.getNextProposalNumber(viewA, process1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.getLastProposalNumber(viewB.ViewRef); err == nil {
+		t.Errorf("the proposal number of %v was saved for %v", viewA, viewB)
+	}
+}
+
+// TestDuellingProposersLateAccept checks that an acceptor that accepted the
+// proposal of one proposer rejects the delayed accept of a lower numbered
+// proposal of another, and answers the higher one to the next prepare.
+func TestDuellingProposersLateAccept(t *testing.T) {
+	c, err := New(comm.NewMemoryTransport())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	r := &ConsensusRequest{consensus: c}
+	associatedView := view.NewWithProcesses(process1, process2, process3)
+
+	// the first proposer prepares 4 here; the second prepares 5 on the other acceptors and gets 5 accepted here too
+	var reply Proposal
+	if err := r.Prepare(Proposal{AssociatedView: associatedView, N: 4}, &reply); err != nil || reply.Err != nil {
+		t.Fatalf("prepare 4 failed: %v %v", err, reply.Err)
+	}
+	reply = Proposal{}
+	if err := r.Accept(Proposal{AssociatedView: associatedView, N: 5, Value: "b", Acceptor: process1}, &reply); err != nil || reply.Err != nil {
+		t.Fatalf("accept 5 failed: %v %v", err, reply.Err)
+	}
+
+	// the accept of the first proposer arrives late
+	reply = Proposal{}
+	if err := r.Accept(Proposal{AssociatedView: associatedView, N: 4, Value: "a", Acceptor: process1}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Err == nil {
+		t.Errorf("the late accept 4 was accepted after accept 5")
+	}
+
+	reply = Proposal{}
+	if err := r.Prepare(Proposal{AssociatedView: associatedView, N: 6}, &reply); err != nil || reply.Err != nil {
+		t.Fatalf("prepare 6 failed: %v %v", err, reply.Err)
+	}
+	if reply.N != 5 || reply.Value != "b" {
+		t.Errorf("expected prepare 6 to get the proposal 5 with b, got %v with %v", reply.N, reply.Value)
+	}
+}
+
+// TestProposeGivesUpWhenAcceptorsHang checks that Propose returns when its
+// context is done, even if a quorum of acceptors never answers.
+func TestProposeGivesUpWhenAcceptorsHang(t *testing.T) {
+	transport := comm.NewMemoryTransport()
+	// process2 and process3 listen but never accept a connection
+	for _, process := range []view.Process{process2, process3} {
+		l, err := transport.Listen(process.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+	}
+	c, err := New(transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	errChan := make(chan error, 1)
+	go func() { errChan <- c.Propose(ctx, view.NewWithProcesses(process1, process2, process3), process1, "a") }()
+
+	select {
+	case err := <-errChan:
+		if !errors.Is(err, comm.ErrCanceled) {
+			t.Errorf("expected a canceled error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Propose did not return after its context was done")
+	}
+}
+
+// TestLearnQuorumOfDistinctAcceptors checks that a value is learned only when
+// a quorum of different acceptors accepted the same proposal.
+func TestLearnQuorumOfDistinctAcceptors(t *testing.T) {
+	c, err := New(comm.NewMemoryTransport())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	r := &ConsensusRequest{consensus: c}
+
+	associatedView := view.NewWithProcesses(process1, process2, process3)
+	resultChan := c.GetConsensusResultChan(associatedView)
+
+	learn := func(acceptor view.Process, n int, value interface{}) {
+		if err := r.Learn(Proposal{AssociatedView: associatedView, N: n, Value: value, Acceptor: acceptor}, new(struct{})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the instance handles its requests in order, so a prepare returns after the learns sent before it are counted
+	waitForLearns := func() {
+		if err := r.Prepare(Proposal{AssociatedView: associatedView}, new(Proposal)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectNoValue := func(reason string) {
+		waitForLearns()
+		select {
+		case value := <-resultChan:
+			t.Fatalf("learned %v after %v", value, reason)
+		default:
+		}
+	}
+
+	learn(process1, 4, "x")
+	learn(process1, 4, "x")
+	expectNoValue("a duplicate learn")
+
+	learn(process4, 4, "x")
+	expectNoValue("a learn from a process outside the view")
+
+	learn(process2, 7, "y")
+	expectNoValue("learns of different proposals")
+
+	learn(process2, 4, "x")
+	waitForLearns()
+	select {
+	case value := <-resultChan:
+		if value != "x" {
+			t.Errorf("expected to learn x, got %v", value)
+		}
+	default:
+		t.Fatalf("did not learn after a quorum of learns")
+	}
+}
+
+// TestReleasedInstanceIsNotCreatedAgain checks that a late request for the
+// instance of a view older than the released one is rejected.
+func TestReleasedInstanceIsNotCreatedAgain(t *testing.T) {
+	c, err := New(comm.NewMemoryTransport())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	r := &ConsensusRequest{consensus: c}
+
+	oldView := view.NewWithProcesses(process1, process2, process3)
+	if err := r.Prepare(Proposal{AssociatedView: oldView, N: 1}, new(Proposal)); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Release(oldView.NewCopyWithUpdates(view.Update{Type: view.Join, Process: process4}))
+	if err := r.Prepare(Proposal{AssociatedView: oldView, N: 2}, new(Proposal)); err != ErrReleased {
+		t.Errorf("expected ErrReleased for a prepare on the released view, got %v", err)
+	}
+	if err := r.Learn(Proposal{AssociatedView: oldView, N: 2, Acceptor: process1}, new(struct{})); err != ErrReleased {
+		t.Errorf("expected ErrReleased for a learn on the released view, got %v", err)
+	}
+	if resultChan := c.GetConsensusResultChan(oldView); resultChan != nil {
+		t.Errorf("expected no result channel for the released view")
+	}
+	if n := c.NumberOfInstances(); n != 0 {
+		t.Errorf("expected no consensus instance after the release, got %v", n)
+	}
+}
+
+func TestQuorumCounter(t *testing.T) {
+	associatedView := view.NewWithProcesses(process1, process2, process3)
+
+	var answers quorumCounter
+	if answers.succeed(process1, associatedView) || answers.succeed(process1, associatedView) {
+		t.Errorf("one acceptor made a quorum")
+	}
+	if answers.succeed(process4, associatedView) {
+		t.Errorf("a process outside the view made a quorum")
+	}
+	if !answers.succeed(process2, associatedView) {
+		t.Errorf("two acceptors of three did not make a quorum")
+	}
+
+	var failures quorumCounter
+	if failures.fail(process1, associatedView) || failures.fail(process1, associatedView) {
+		t.Errorf("a repeated failure of one acceptor was taken for two")
+	}
+	if !failures.fail(process3, associatedView) {
+		t.Errorf("two failures of three did not prevent a quorum")
+	}
+	if failed := failures.failedProcesses(); len(failed) != 2 {
+		t.Errorf("expected 2 failed processes, got %v", failed)
+	}
+}
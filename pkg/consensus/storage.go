@@ -6,48 +6,49 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 
 	"github.com/cznic/kv"
+	"github.com/mateusbraga/freestore/pkg/view"
 )
 
-var (
-	storage *kv.DB
-)
+// storageFileName is the name of the file, inside the directory given to Open, that keeps the consensus state.
+const storageFileName = "consensus.db"
 
-// ------- Init storage -----------
-func initStorage() {
-	var err error
-	//TODO consensus requires persistent storage. Here we are using an in memory only. Fix it for real fault tolerance.
-	storage, err = kv.CreateMem(new(kv.Options))
-	if err != nil {
-		log.Fatalln("initStorage error:", err)
+// openStorage opens the consensus state saved in dir, creating it if needed.
+func openStorage(dir string) (*kv.DB, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
 	}
-}
 
-func init() {
-	initStorage()
+	path := filepath.Join(dir, storageFileName)
+	if _, err := os.Stat(path); err == nil {
+		return kv.Open(path, new(kv.Options))
+	}
+	return kv.Create(path, new(kv.Options))
 }
 
 // saveProposalNumberOnStorage to permanent storage.
-func saveProposalNumberOnStorage(consensusId int, proposalNumber int) {
+func (c *Consensus) saveProposalNumberOnStorage(consensusId view.ViewRef, proposalNumber int) {
 	proposalNumberBuffer := new(bytes.Buffer)
 	enc := gob.NewEncoder(proposalNumberBuffer)
 	err := enc.Encode(proposalNumber)
 	if err != nil {
-		log.Fatalln("enc.Encode failed:", err)
+		log.Fatalln("FATAL: enc.Encode failed:", err)
 	}
 
-	err = storage.Set([]byte(fmt.Sprintf("lastProposalNumber_%v", consensusId)), proposalNumberBuffer.Bytes())
+	err = c.storage.Set([]byte(fmt.Sprintf("lastProposalNumber_%v", consensusId)), proposalNumberBuffer.Bytes())
 	if err != nil {
-		log.Fatalln("storage.Set failed:", err)
+		log.Fatalln("FATAL: storage.Set failed:", err)
 	}
 }
 
 // getLastProposalNumber from permanent storage.
-func getLastProposalNumber(consensusId int) (int, error) {
-	lastProposalNumberBytes, err := storage.Get(nil, []byte(fmt.Sprintf("lastProposalNumber_%v", consensusId)))
+func (c *Consensus) getLastProposalNumber(consensusId view.ViewRef) (int, error) {
+	lastProposalNumberBytes, err := c.storage.Get(nil, []byte(fmt.Sprintf("lastProposalNumber_%v", consensusId)))
 	if err != nil {
-		log.Fatalln(err)
+		log.Fatalln("FATAL:", err)
 	} else if lastProposalNumberBytes == nil {
 		return 0, errors.New("Last proposal number not found")
 	} else {
@@ -58,45 +59,78 @@ func getLastProposalNumber(consensusId int) (int, error) {
 
 		err := dec.Decode(&lastProposalNumber)
 		if err != nil {
-			log.Fatalln("dec.Decode failed:", err)
+			log.Fatalln("FATAL: dec.Decode failed:", err)
 		}
 
 		return lastProposalNumber, nil
 	}
 
-	log.Fatalln("BUG! Should never execute this command on getLastProposalNumber")
+	log.Fatalln("FATAL: BUG! Should never execute this command on getLastProposalNumber")
 	return 0, nil
 }
 
 // saveAcceptedProposalOnStorage to permanent storage.
-// needs to be tested
-func saveAcceptedProposalOnStorage(ci consensusInstance, proposal *Proposal) {
+func (c *Consensus) saveAcceptedProposalOnStorage(ci consensusInstance, proposal *Proposal) {
 	proposalBuffer := new(bytes.Buffer)
 	enc := gob.NewEncoder(proposalBuffer)
 
 	err := enc.Encode(proposal)
 	if err != nil {
-		log.Fatalln("enc.Encode failed:", err)
+		log.Fatalln("FATAL: enc.Encode failed:", err)
 	}
 
-	err = storage.Set([]byte(fmt.Sprintf("acceptedProposal_%v", ci.Id())), proposalBuffer.Bytes())
+	err = c.storage.Set([]byte(fmt.Sprintf("acceptedProposal_%v", ci.Id())), proposalBuffer.Bytes())
 	if err != nil {
-		log.Fatalln("ERROR to save acceptedProposal:", err)
+		log.Fatalln("FATAL: failed to save acceptedProposal:", err)
 	}
 }
 
 // savePrepareRequestOnStorage to permanent storage
-// needs to be tested
-func savePrepareRequestOnStorage(ci consensusInstance, proposal *Prepare) {
+func (c *Consensus) savePrepareRequestOnStorage(ci consensusInstance, proposal *Prepare) {
 	proposalBuffer := new(bytes.Buffer)
 	enc := gob.NewEncoder(proposalBuffer)
 	err := enc.Encode(proposal)
 	if err != nil {
-		log.Fatalln("enc.Encode failed:", err)
+		log.Fatalln("FATAL: enc.Encode failed:", err)
 	}
 
-	err = storage.Set([]byte(fmt.Sprintf("prepareRequest_%v", ci.Id())), proposalBuffer.Bytes())
+	err = c.storage.Set([]byte(fmt.Sprintf("prepareRequest_%v", ci.Id())), proposalBuffer.Bytes())
 	if err != nil {
-		log.Fatalln("ERROR to save prepareRequest:", err)
+		log.Fatalln("FATAL: failed to save prepareRequest:", err)
+	}
+}
+
+// getAcceptedProposalFromStorage returns the proposal saved by saveAcceptedProposalOnStorage, if any.
+func (c *Consensus) getAcceptedProposalFromStorage(ci consensusInstance) (Proposal, bool) {
+	var proposal Proposal
+	if !c.getFromStorage(fmt.Sprintf("acceptedProposal_%v", ci.Id()), &proposal) {
+		return Proposal{}, false
+	}
+	return proposal, true
+}
+
+// getPrepareRequestFromStorage returns the prepare request saved by savePrepareRequestOnStorage, if any.
+func (c *Consensus) getPrepareRequestFromStorage(ci consensusInstance) (Prepare, bool) {
+	var prepare Prepare
+	if !c.getFromStorage(fmt.Sprintf("prepareRequest_%v", ci.Id()), &prepare) {
+		return Prepare{}, false
+	}
+	return prepare, true
+}
+
+// getFromStorage decodes the value saved at key into result. It returns false if there is no value at key.
+func (c *Consensus) getFromStorage(key string, result interface{}) bool {
+	valueBytes, err := c.storage.Get(nil, []byte(key))
+	if err != nil {
+		log.Fatalln("FATAL: storage.Get failed:", err)
+	}
+	if valueBytes == nil {
+		return false
+	}
+
+	dec := gob.NewDecoder(bytes.NewBuffer(valueBytes))
+	if err := dec.Decode(result); err != nil {
+		log.Fatalln("FATAL: dec.Decode failed:", err)
 	}
+	return true
 }
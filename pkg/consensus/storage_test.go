@@ -1,40 +1,156 @@
 package consensus
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
 
+	"github.com/mateusbraga/freestore/pkg/comm"
 	"github.com/mateusbraga/freestore/pkg/view"
 )
 
 func TestDatabaseFunctions(t *testing.T) {
-	initStorage()
+	c, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
 
-	updates := []view.Update{view.Update{Type: view.Join, Process: view.Process{"[::]:5000"}},
-		view.Update{Type: view.Join, Process: view.Process{"[::]:5001"}},
-		view.Update{Type: view.Join, Process: view.Process{"[::]:5002"}}}
+	updates := []view.Update{view.Update{Type: view.Join, Process: view.Process{Addr: "[::]:5000"}},
+		view.Update{Type: view.Join, Process: view.Process{Addr: "[::]:5001"}},
+		view.Update{Type: view.Join, Process: view.Process{Addr: "[::]:5002"}}}
 
 	associatedView := view.NewWithUpdates(updates...)
 
-	thisProcess := view.Process{"[::]:5001"}
+	thisProcess := view.Process{Addr: "[::]:5001"}
 
-	if key, value, _ := storage.First(); key != nil && value != nil {
+	if key, value, _ := c.storage.First(); key != nil && value != nil {
 		t.Errorf("storage is not empty or unitinialized")
 	}
 
-	if proposalNumber, err := getLastProposalNumber(associatedView.NumberOfUpdates()); proposalNumber != 0 || err == nil {
+	if proposalNumber, err := c.getLastProposalNumber(associatedView.ViewRef); proposalNumber != 0 || err == nil {
 		t.Errorf("getLastProposalNumber should return proposalNumber == 0 and err != nil, got %v and %v", proposalNumber, err)
 	}
 
-	saveProposalNumberOnStorage(associatedView.NumberOfUpdates(), 1)
-	if proposalNumber, err := getLastProposalNumber(associatedView.NumberOfUpdates()); proposalNumber != 1 || err != nil {
+	c.saveProposalNumberOnStorage(associatedView.ViewRef, 1)
+	if proposalNumber, err := c.getLastProposalNumber(associatedView.ViewRef); proposalNumber != 1 || err != nil {
 		t.Errorf("getLastProposalNumber should return proposalNumber == 1 and err == nil, got %v and %v", proposalNumber, err)
 	}
 
-	if proposalNumber := getNextProposalNumber(associatedView, thisProcess); proposalNumber != 4 {
-		t.Errorf("getNextProposalNumber: expected proposalNumber == 4, got %v", proposalNumber)
+	if proposalNumber, err := c.getNextProposalNumber(associatedView, thisProcess); proposalNumber != 4 || err != nil {
+		t.Errorf("getNextProposalNumber: expected proposalNumber == 4 and err == <nil>, got %v and %v", proposalNumber, err)
 	}
 
-	if proposalNumber, err := getLastProposalNumber(associatedView.NumberOfUpdates()); proposalNumber != 4 || err != nil {
+	if proposalNumber, err := c.getLastProposalNumber(associatedView.ViewRef); proposalNumber != 4 || err != nil {
 		t.Errorf("getNextProposalNumber: expted proposalNumber == 4 and err == <nil>, got %v and %v", proposalNumber, err)
 	}
 }
+
+// TestAcceptorRecovery restarts an acceptor and checks that it keeps the promises and the proposal it accepted before.
+func TestAcceptorRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freestore-consensus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	associatedView := view.NewWithProcesses(view.Process{Addr: "127.0.0.1:1"}, view.Process{Addr: "127.0.0.1:2"}, view.Process{Addr: "127.0.0.1:3"})
+
+	c, err := Open(dir, comm.NewMemoryTransport())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ci := startTestAcceptor(c, associatedView)
+
+	if reply := sendPrepare(ci, associatedView, 5); reply.Err != nil {
+		t.Fatalf("prepare 5 failed: %v", reply.Err)
+	}
+	if reply := sendAccept(ci, associatedView, 5, "chosen"); reply.Err != nil {
+		t.Fatalf("accept 5 failed: %v", reply.Err)
+	}
+	if reply := sendPrepare(ci, associatedView, 8); reply.Err != nil {
+		t.Fatalf("prepare 8 failed: %v", reply.Err)
+	}
+
+	// restart
+	close(ci.taskChan)
+	c.Close()
+	c, err = Open(dir, comm.NewMemoryTransport())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ci = startTestAcceptor(c, associatedView)
+
+	if reply := sendPrepare(ci, associatedView, 7); reply.Err == nil {
+		t.Errorf("recovered acceptor broke its promise to 8 by accepting prepare 7")
+	}
+	if reply := sendAccept(ci, associatedView, 6, "other"); reply.Err == nil {
+		t.Errorf("recovered acceptor broke its promise to 8 by accepting proposal 6")
+	}
+
+	reply := sendPrepare(ci, associatedView, 11)
+	if reply.Err != nil {
+		t.Fatalf("prepare 11 failed: %v", reply.Err)
+	}
+	if reply.N != 5 || reply.Value != "chosen" {
+		t.Errorf("recovered acceptor forgot accepted proposal: got %v %v, expected 5 chosen", reply.N, reply.Value)
+	}
+	close(ci.taskChan)
+}
+
+func startTestAcceptor(c *Consensus, associatedView *view.View) consensusInstance {
+	ci := consensusInstance{associatedView: associatedView, taskChan: make(chan consensusTask, CHANNEL_DEFAULT_BUFFER_SIZE), callbackLearnChan: make(chan interface{}, 1)}
+	go c.consensusWorker(ci)
+	return ci
+}
+
+func sendPrepare(ci consensusInstance, associatedView *view.View, n int) Proposal {
+	var reply Proposal
+	prepare := Prepare{Proposal: &Proposal{AssociatedView: associatedView, N: n}, reply: &reply, returnChan: make(chan bool)}
+	ci.taskChan <- &prepare
+	<-prepare.returnChan
+	return reply
+}
+
+func sendAccept(ci consensusInstance, associatedView *view.View, n int, value interface{}) Proposal {
+	var reply Proposal
+	accept := Accept{Proposal: &Proposal{AssociatedView: associatedView, N: n, Value: value}, reply: &reply, returnChan: make(chan bool)}
+	ci.taskChan <- &accept
+	<-accept.returnChan
+	return reply
+}
+
+// TestSkipPromisedProposalNumbers checks that a proposer rejected by an acceptor retries with a higher proposal number.
+func TestSkipPromisedProposalNumbers(t *testing.T) {
+	c, err := New(comm.NewMemoryTransport())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	associatedView := view.NewWithProcesses(view.Process{Addr: "memory:1"}, view.Process{Addr: "memory:2"}, view.Process{Addr: "memory:3"})
+	thisProcess := view.Process{Addr: "memory:1"}
+
+	first, err := c.getNextProposalNumber(associatedView, thisProcess)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another proposer got a promise for 13, through the network the error is a pointer
+	c.skipPromisedProposalNumbers(associatedView, &OldProposalNumberError{Promised: 13})
+	next, err := c.getNextProposalNumber(associatedView, thisProcess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next <= 13 || next%3 != first%3 {
+		t.Errorf("expected a proposal number of this process higher than 13, got %v (first was %v)", next, first)
+	}
+
+	// lower promises and other errors do not change the numbers
+	c.skipPromisedProposalNumbers(associatedView, OldProposalNumberError{Promised: 2})
+	c.skipPromisedProposalNumbers(associatedView, comm.ErrUnreachable)
+	if last, err := c.getLastProposalNumber(associatedView.ViewRef); err != nil || last != next {
+		t.Errorf("expected last proposal number %v, got %v and %v", next, last, err)
+	}
+}
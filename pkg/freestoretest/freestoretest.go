@@ -0,0 +1,353 @@
+// Package freestoretest runs freestore clusters inside tests.
+//
+// A Cluster is a set of servers running in the test process, listening on
+// ephemeral TCP ports of the loopback interface. The servers and the clients
+// created with the cluster talk through comm.DefaultTransport, as in
+// production. Nodes join and leave through the reconfiguration protocol,
+// and crashed nodes restart with the state they saved. The cluster is closed
+// when the test finishes.
+//
+//	cluster := freestoretest.Start(t, 3)
+//	cl, err := client.New(cluster.GetViewFunc(), cluster.GetViewFunc())
+package freestoretest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/client"
+	"github.com/mateusbraga/freestore/pkg/server"
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// Timeout is how long the helpers wait for the cluster before failing the test.
+var Timeout = 30 * time.Second
+
+// Config sets up the servers of a cluster.
+type Config struct {
+	UseConsensus bool
+
+	// ReconfigurationPeriod is the time between the reconfigurations of the
+	// servers, which is also about how long a join or a removal takes. If
+	// zero, it is 100ms.
+	ReconfigurationPeriod time.Duration
+}
+
+// Cluster is a freestore cluster running in the test process.
+type Cluster struct {
+	t       testing.TB
+	config  Config
+	dataDir string
+
+	mu    sync.Mutex
+	nodes []*Node
+}
+
+// Node is a server of a Cluster.
+type Node struct {
+	process view.Process
+	dataDir string
+
+	mu     sync.Mutex
+	server *server.Server // nil while crashed
+}
+
+// Process returns the process of the node.
+func (n *Node) Process() view.Process {
+	return n.process
+}
+
+// Server returns the running server of the node, or nil if it crashed.
+func (n *Node) Server() *server.Server {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.server
+}
+
+// Start starts a cluster of n servers with the default configuration. See StartWithConfig.
+func Start(t testing.TB, n int) *Cluster {
+	t.Helper()
+	return StartWithConfig(t, n, Config{})
+}
+
+// StartWithConfig starts a cluster of n servers whose initial view has all
+// of them. The cluster is closed with t.Cleanup.
+func StartWithConfig(t testing.TB, n int, config Config) *Cluster {
+	t.Helper()
+
+	if config.ReconfigurationPeriod == 0 {
+		config.ReconfigurationPeriod = 100 * time.Millisecond
+	}
+	dataDir, err := ioutil.TempDir("", "freestoretest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Cluster{t: t, config: config, dataDir: dataDir}
+	t.Cleanup(c.close)
+
+	// the listeners are handed to the servers, so no one else can take their ports meanwhile
+	var listeners []net.Listener
+	var processes []view.Process
+	for i := 0; i < n; i++ {
+		listener, err := listenLoopback()
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			t.Fatal(err)
+		}
+		listeners = append(listeners, listener)
+		processes = append(processes, view.Process{Addr: listener.Addr().String()})
+	}
+	initialView := view.NewWithProcesses(processes...)
+
+	for i, process := range processes {
+		node := c.newNode(process)
+		if err := c.startNode(node, initialView, listeners[i]); err != nil {
+			for _, listener := range listeners[i+1:] {
+				listener.Close()
+			}
+			t.Fatalf("failed to start server %v: %v", process, err)
+		}
+	}
+	return c
+}
+
+// listenLoopback listens to an ephemeral port of the loopback interface.
+func listenLoopback() (net.Listener, error) {
+	return net.Listen("tcp", "127.0.0.1:0")
+}
+
+func (c *Cluster) newNode(process view.Process) *Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node := &Node{
+		process: process,
+		dataDir: filepath.Join(c.dataDir, fmt.Sprintf("node-%v", len(c.nodes)+1)),
+	}
+	c.nodes = append(c.nodes, node)
+	return node
+}
+
+// startNode starts the server of node with listener, or listening to the address of node if listener is nil.
+func (c *Cluster) startNode(node *Node, initialView *view.View, listener net.Listener) error {
+	s, err := server.New(server.Config{
+		BindAddr:              node.process.Addr,
+		Listener:              listener,
+		InitialView:           initialView,
+		UseConsensus:          c.config.UseConsensus,
+		DataDir:               node.dataDir,
+		ReconfigurationPeriod: c.config.ReconfigurationPeriod,
+	})
+	if err != nil {
+		return err
+	}
+	go s.Run()
+
+	node.mu.Lock()
+	node.server = s
+	node.mu.Unlock()
+	return nil
+}
+
+// Nodes returns the nodes of the cluster, the crashed ones included.
+func (c *Cluster) Nodes() []*Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*Node(nil), c.nodes...)
+}
+
+// running returns the nodes that did not crash.
+func (c *Cluster) running() []*Node {
+	var nodes []*Node
+	for _, node := range c.Nodes() {
+		if node.Server() != nil {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+func processesOf(nodes []*Node) []view.Process {
+	var processes []view.Process
+	for _, node := range nodes {
+		processes = append(processes, node.process)
+	}
+	return processes
+}
+
+// CurrentView returns the most updated view of the running nodes.
+func (c *Cluster) CurrentView() *view.View {
+	c.t.Helper()
+
+	var mostUpdated *view.View
+	for _, node := range c.running() {
+		v, err := client.GetCurrentView(node.process)
+		if err != nil {
+			continue
+		}
+		if mostUpdated == nil || v.MoreUpdatedThan(mostUpdated) {
+			mostUpdated = v
+		}
+	}
+	if mostUpdated == nil {
+		c.t.Fatal("no server of the cluster answered")
+	}
+	return mostUpdated
+}
+
+// GetViewFunc returns a function that gets the current view from the
+// running nodes of the cluster, to be given to client.New.
+func (c *Cluster) GetViewFunc() client.GetViewFunc {
+	return func() (*view.View, error) {
+		return client.GetCurrentView(processesOf(c.running())...)
+	}
+}
+
+// NewClient returns a client of the cluster.
+func (c *Cluster) NewClient() *client.Client {
+	c.t.Helper()
+
+	cl, err := client.New(c.GetViewFunc(), c.GetViewFunc())
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return cl
+}
+
+// WaitForView waits until cond holds for the current view of all the running nodes.
+func (c *Cluster) WaitForView(cond func(*view.View) bool) {
+	c.t.Helper()
+
+	deadline := time.Now().Add(Timeout)
+	for _, node := range c.running() {
+		for {
+			v, err := client.GetCurrentView(node.process)
+			if err == nil && cond(v) {
+				break
+			}
+			if time.Now().After(deadline) {
+				c.t.Fatalf("server %v did not install the expected view, its view is %v (error: %v)", node.process, v, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// Join starts a new server, which asks to join the cluster, and waits until all running nodes installed a view with it.
+func (c *Cluster) Join() *Node {
+	c.t.Helper()
+
+	listener, err := listenLoopback()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	node := c.newNode(view.Process{Addr: listener.Addr().String()})
+	if err := c.startNode(node, c.CurrentView(), listener); err != nil {
+		c.t.Fatalf("failed to start server %v: %v", node.process, err)
+	}
+
+	c.WaitForView(func(v *view.View) bool { return v.HasMember(node.process) })
+	return node
+}
+
+// Remove makes node leave the cluster and waits until all running nodes installed a view without it.
+func (c *Cluster) Remove(node *Node) {
+	c.t.Helper()
+
+	s := node.Server()
+	if s == nil {
+		c.t.Fatalf("cannot remove crashed server %v", node.process)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	if err := s.Leave(ctx); err != nil {
+		c.t.Fatalf("server %v failed to leave: %v", node.process, err)
+	}
+
+	node.mu.Lock()
+	node.server = nil
+	node.mu.Unlock()
+	c.forget(node)
+
+	c.WaitForView(func(v *view.View) bool { return !v.HasMember(node.process) })
+}
+
+func (c *Cluster) forget(node *Node) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, n := range c.nodes {
+		if n == node {
+			c.nodes = append(c.nodes[:i], c.nodes[i+1:]...)
+			return
+		}
+	}
+}
+
+// Crash stops node without leaving the cluster. It keeps its saved state and can be restarted.
+func (c *Cluster) Crash(node *Node) {
+	c.t.Helper()
+
+	node.mu.Lock()
+	s := node.server
+	node.server = nil
+	node.mu.Unlock()
+
+	if s == nil {
+		c.t.Fatalf("server %v already crashed", node.process)
+	}
+	if err := s.Close(); err != nil {
+		c.t.Errorf("server %v failed to close: %v", node.process, err)
+	}
+}
+
+// Restart starts a crashed node again, with the state it saved, and waits until it answers.
+func (c *Cluster) Restart(node *Node) {
+	c.t.Helper()
+
+	if node.Server() != nil {
+		c.t.Fatalf("server %v is running", node.process)
+	}
+
+	var initialView *view.View
+	if len(c.running()) != 0 {
+		initialView = c.CurrentView()
+	}
+	// the node must keep its address, so its port is bound again
+	if err := c.startNode(node, initialView, nil); err != nil {
+		c.t.Fatalf("failed to restart server %v: %v", node.process, err)
+	}
+
+	// the connections to the crashed server are repaired in the background
+	deadline := time.Now().Add(Timeout)
+	for {
+		_, err := client.GetCurrentView(node.process)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			c.t.Fatalf("server %v did not answer after restart: %v", node.process, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (c *Cluster) close() {
+	for _, node := range c.Nodes() {
+		if s := node.Server(); s != nil {
+			s.Close()
+		}
+	}
+	os.RemoveAll(c.dataDir)
+}
@@ -0,0 +1,52 @@
+package freestoretest
+
+import (
+	"testing"
+
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+func TestCluster(t *testing.T) {
+	cluster := Start(t, 3)
+	cl := cluster.NewClient()
+
+	if err := cl.Write(1); err != nil {
+		t.Fatal(err)
+	}
+
+	joiner := cluster.Join()
+	if v := cluster.CurrentView(); v.NumberOfMembers() != 4 || !v.HasMember(joiner.Process()) {
+		t.Errorf("expected a view of 4 members with %v, got %v", joiner.Process(), v)
+	}
+	if v, err := cl.Read(); err != nil || v != 1 {
+		t.Errorf("expected 1 and no error after the join, got %v and %v", v, err)
+	}
+
+	crashed := cluster.Nodes()[0]
+	cluster.Crash(crashed)
+	if err := cl.Write(2); err != nil {
+		t.Errorf("write with one crashed server failed: %v", err)
+	}
+
+	cluster.Restart(crashed)
+	cluster.Remove(cluster.Nodes()[1])
+	cluster.WaitForView(func(v *view.View) bool { return v.NumberOfMembers() == 3 })
+
+	if v, err := cl.Read(); err != nil || v != 2 {
+		t.Errorf("expected 2 and no error after the removal, got %v and %v", v, err)
+	}
+	if len(cluster.Nodes()) != 3 {
+		t.Errorf("expected 3 nodes, got %v", cluster.Nodes())
+	}
+}
+
+func TestClustersAreIndependent(t *testing.T) {
+	first, second := Start(t, 1), Start(t, 1)
+
+	if err := first.NewClient().Write("first"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := second.NewClient().Read(); err != nil || v != nil {
+		t.Errorf("expected empty register in the second cluster, got %v and %v", v, err)
+	}
+}
@@ -0,0 +1,280 @@
+package linearizability
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+// Result is the result of Check.
+type Result struct {
+	Ok bool
+
+	// Key and Counterexample are set if the history is not linearizable.
+	// Counterexample is a minimal subset of the operations on Key that is
+	// not linearizable: removing any of its operations makes it linearizable,
+	// or leaves a read of a value that no operation wrote.
+	Key            string
+	Counterexample []Operation
+}
+
+func (r Result) String() string {
+	if r.Ok {
+		return "history is linearizable"
+	}
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "history of register %q is not linearizable, minimal counterexample:\n", r.Key)
+	for _, op := range r.Counterexample {
+		fmt.Fprintf(&buffer, "\t%v\n", op)
+	}
+	return buffer.String()
+}
+
+// Check checks if history is linearizable, each register being independent of the others.
+//
+// The reads that failed are ignored. The writes that failed may or may not
+// have taken effect, so they are considered in progress until the end of
+// the history.
+func Check(history []Operation) Result {
+	keyHistories := make(map[string][]Operation)
+	var keys []string
+	for _, op := range history {
+		if op.Kind == Read && op.pending() {
+			continue
+		}
+		if _, ok := keyHistories[op.Key]; !ok {
+			keys = append(keys, op.Key)
+		}
+		keyHistories[op.Key] = append(keyHistories[op.Key], op)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !linearizable(keyHistories[key]) {
+			return Result{Key: key, Counterexample: minimize(keyHistories[key])}
+		}
+	}
+	return Result{Ok: true}
+}
+
+// minimize removes operations from ops while the rest is still not
+// linearizable. Unless ops already has a read of a value never written, the
+// writes of the values read are kept: such a read is not linearizable but
+// says little about the failure.
+func minimize(ops []Operation) []Operation {
+	ops = append([]Operation(nil), ops...)
+	keepWrites := readsAreWritten(ops)
+
+	for chunk := len(ops) / 2; chunk >= 1; chunk /= 2 {
+		for {
+			removed := false
+			for start := 0; start < len(ops); {
+				end := start + chunk
+				if end > len(ops) {
+					end = len(ops)
+				}
+				candidate := append(append([]Operation(nil), ops[:start]...), ops[end:]...)
+				if len(candidate) != 0 && (!keepWrites || readsAreWritten(candidate)) && !linearizable(candidate) {
+					ops = candidate
+					removed = true
+				} else {
+					start += chunk
+				}
+			}
+			// keep trying single operations until none can go, a removal may free the ones before it
+			if !removed || chunk > 1 {
+				break
+			}
+		}
+	}
+
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Invoke < ops[j].Invoke })
+	return ops
+}
+
+// readsAreWritten returns true if every value read in ops is written in ops, or is the empty register.
+func readsAreWritten(ops []Operation) bool {
+	written := map[string]bool{valueString(nil): true}
+	for _, op := range ops {
+		if op.Kind == Write {
+			written[valueString(op.Value)] = true
+		}
+	}
+	for _, op := range ops {
+		if op.Kind == Read && !written[valueString(op.Value)] {
+			return false
+		}
+	}
+	return true
+}
+
+// entry is a call or return event of the history of a register, in a list sorted by time.
+type entry struct {
+	id       int
+	op       *Operation
+	isReturn bool
+	match    *entry // the return of a call
+	prev     *entry
+	next     *entry
+}
+
+// newEntryList returns the sentinel head of the list of events of ops.
+func newEntryList(ops []Operation) *entry {
+	type event struct {
+		time  int64
+		entry *entry
+	}
+
+	var events []event
+	for i := range ops {
+		call := &entry{id: i, op: &ops[i]}
+		ret := &entry{id: i, op: &ops[i], isReturn: true}
+		call.match = ret
+
+		complete := ops[i].Complete
+		if ops[i].pending() {
+			complete = math.MaxInt64
+		}
+		events = append(events, event{ops[i].Invoke, call}, event{complete, ret})
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].time < events[j].time })
+
+	head := &entry{}
+	last := head
+	for _, e := range events {
+		last.next = e.entry
+		e.entry.prev = last
+		last = e.entry
+	}
+	return head
+}
+
+// lift removes the call e and its return from the list.
+func lift(e *entry) {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	ret := e.match
+	ret.prev.next = ret.next
+	if ret.next != nil {
+		ret.next.prev = ret.prev
+	}
+}
+
+// unlift puts back the call e and its return in the list.
+func unlift(e *entry) {
+	ret := e.match
+	ret.prev.next = ret
+	if ret.next != nil {
+		ret.next.prev = ret
+	}
+	e.prev.next = e
+	e.next.prev = e
+}
+
+// step applies op to the register with value state. It returns false if op is not possible.
+func step(state string, op *Operation) (bool, string) {
+	switch op.Kind {
+	case Write:
+		return true, valueString(op.Value)
+	default:
+		return valueString(op.Value) == state, state
+	}
+}
+
+func valueString(v interface{}) string {
+	return fmt.Sprintf("%#v", v)
+}
+
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) set(i int)   { b[i/64] |= 1 << uint(i%64) }
+func (b bitset) clear(i int) { b[i/64] &^= 1 << uint(i%64) }
+
+func (b bitset) clone() bitset {
+	return append(bitset(nil), b...)
+}
+
+func (b bitset) equal(b2 bitset) bool {
+	for i := range b {
+		if b[i] != b2[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type cacheEntry struct {
+	linearized bitset
+	state      string
+}
+
+// linearizable returns true if the operations on a register are linearizable, starting with the register empty.
+func linearizable(ops []Operation) bool {
+	head := newEntryList(ops)
+
+	type call struct {
+		entry *entry
+		state string
+	}
+	var calls []call
+
+	// cache has the sets of linearized operations and register states already explored
+	cache := make(map[uint64][]cacheEntry)
+	seen := func(linearized bitset, state string) bool {
+		hash := fnv.New64a()
+		for _, word := range linearized {
+			fmt.Fprint(hash, word, ",")
+		}
+		hash.Write([]byte(state))
+		key := hash.Sum64()
+
+		for _, cached := range cache[key] {
+			if cached.state == state && cached.linearized.equal(linearized) {
+				return true
+			}
+		}
+		cache[key] = append(cache[key], cacheEntry{linearized, state})
+		return false
+	}
+
+	state := valueString(nil)
+	linearized := newBitset(len(ops))
+	e := head.next
+	for head.next != nil {
+		if !e.isReturn {
+			ok, newState := step(state, e.op)
+			if ok {
+				newLinearized := linearized.clone()
+				newLinearized.set(e.id)
+				if !seen(newLinearized, newState) {
+					calls = append(calls, call{e, state})
+					state = newState
+					linearized.set(e.id)
+					lift(e)
+					e = head.next
+					continue
+				}
+			}
+			e = e.next
+		} else {
+			// the operation returned before being linearized, undo the last linearized call
+			if len(calls) == 0 {
+				return false
+			}
+			last := calls[len(calls)-1]
+			calls = calls[:len(calls)-1]
+			state = last.state
+			linearized.clear(last.entry.id)
+			unlift(last.entry)
+			e = last.entry.next
+		}
+	}
+	return true
+}
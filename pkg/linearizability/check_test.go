@@ -0,0 +1,121 @@
+package linearizability
+
+import (
+	"errors"
+	"testing"
+)
+
+// history builds a history from operations whose Invoke and Complete are given.
+func history(ops ...Operation) []Operation {
+	return ops
+}
+
+func write(client int, key string, value interface{}, invoke, complete int64) Operation {
+	return Operation{Client: client, Kind: Write, Key: key, Value: value, Invoke: invoke, Complete: complete}
+}
+
+func read(client int, key string, value interface{}, invoke, complete int64) Operation {
+	return Operation{Client: client, Kind: Read, Key: key, Value: value, Invoke: invoke, Complete: complete}
+}
+
+func TestCheckLinearizable(t *testing.T) {
+	histories := [][]Operation{
+		// sequential
+		history(read(1, "", nil, 1, 2), write(1, "", 1, 3, 4), read(2, "", 1, 5, 6)),
+		// a read concurrent with a write can return the old or the new value
+		history(write(1, "", 1, 1, 2), write(1, "", 2, 3, 8), read(2, "", 1, 4, 5), read(3, "", 2, 6, 7)),
+		// a read can see a write that is invoked after it, if they overlap
+		history(read(2, "", 1, 1, 4), write(1, "", 1, 2, 3)),
+		// the registers are independent
+		history(write(1, "a", 1, 1, 2), read(2, "b", nil, 3, 4), read(2, "a", 1, 5, 6)),
+	}
+
+	for i, h := range histories {
+		if result := Check(h); !result.Ok {
+			t.Errorf("history %v should be linearizable, got %v", i, result)
+		}
+	}
+}
+
+func TestCheckNotLinearizable(t *testing.T) {
+	// read 2 sees an older value after read 1 saw the new one
+	h := history(
+		write(1, "", 1, 1, 2),
+		write(1, "", 2, 3, 10),
+		read(2, "other", nil, 4, 5),
+		read(2, "", 2, 4, 6),
+		read(3, "", 1, 7, 8),
+		write(4, "", 3, 11, 12),
+	)
+
+	result := Check(h)
+	if result.Ok {
+		t.Fatal("expected history not to be linearizable")
+	}
+	if result.Key != "" {
+		t.Errorf("expected counterexample on the default register, got %q", result.Key)
+	}
+
+	// the operations on the other register and the write after all the reads are not needed
+	expected := []Operation{h[0], h[1], h[3], h[4]}
+	if len(result.Counterexample) != len(expected) {
+		t.Fatalf("expected minimal counterexample %v, got %v", expected, result)
+	}
+	for i := range expected {
+		if result.Counterexample[i] != expected[i] {
+			t.Errorf("expected minimal counterexample %v, got %v", expected, result)
+		}
+	}
+}
+
+func TestCheckStaleRead(t *testing.T) {
+	h := history(write(1, "", 1, 1, 2), read(2, "", nil, 3, 4))
+
+	if result := Check(h); result.Ok || len(result.Counterexample) != 2 {
+		t.Errorf("expected the stale read to be reported, got %v", result)
+	}
+}
+
+func TestCheckFailedOperations(t *testing.T) {
+	failed := errors.New("no quorum")
+
+	// a failed write may have taken effect
+	h := history(
+		Operation{Client: 1, Kind: Write, Value: 1, Invoke: 1, Complete: 2, Err: failed},
+		read(2, "", 1, 3, 4),
+		Operation{Client: 3, Kind: Read, Value: 5, Invoke: 5, Complete: 6, Err: failed},
+	)
+	if result := Check(h); !result.Ok {
+		t.Errorf("expected failed write to be allowed to take effect and failed read to be ignored, got %v", result)
+	}
+
+	// or not
+	h = history(
+		Operation{Client: 1, Kind: Write, Value: 1, Invoke: 1, Complete: 2, Err: failed},
+		read(2, "", nil, 3, 4),
+	)
+	if result := Check(h); !result.Ok {
+		t.Errorf("expected failed write to be allowed not to take effect, got %v", result)
+	}
+
+	// but not both
+	h = append(h, read(2, "", 1, 5, 6), read(2, "", nil, 7, 8))
+	if result := Check(h); result.Ok {
+		t.Error("expected a failed write that both took effect and did not to be reported")
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	var recorder Recorder
+
+	w := recorder.Invoke(1, Write, "", 1)
+	r := recorder.Invoke(2, Read, "", nil)
+	recorder.Complete(r, 1, nil)
+	recorder.Complete(w, nil, nil)
+
+	h := recorder.History()
+	expected := []Operation{write(1, "", 1, 1, 4), read(2, "", 1, 2, 3)}
+	if len(h) != len(expected) || h[0] != expected[0] || h[1] != expected[1] {
+		t.Errorf("expected history %v, got %v", expected, h)
+	}
+}
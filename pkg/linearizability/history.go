@@ -0,0 +1,154 @@
+// Package linearizability checks that the histories of freestore's registers are linearizable.
+//
+// A Recorder records when each operation of the clients was invoked and
+// when it completed. Check then searches for an order of the operations that
+// respects their real time order and the semantics of a read/write register,
+// with the algorithm of Wing and Gong improved by Lowe. If there is none, it
+// returns a minimal set of operations that is not linearizable either.
+package linearizability
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/mateusbraga/freestore/pkg/client"
+)
+
+// Kind is the kind of an operation.
+type Kind int
+
+const (
+	Read Kind = iota
+	Write
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Read:
+		return "read"
+	case Write:
+		return "write"
+	}
+	return "unknown"
+}
+
+// Operation is an operation of a client on a register.
+type Operation struct {
+	Client int
+	Kind   Kind
+	Key    string
+	Value  interface{} // The value written or the value read
+
+	// Invoke and Complete are the logical times the operation was invoked
+	// and completed. Complete is 0 while the operation is in progress.
+	Invoke   int64
+	Complete int64
+	Err      error
+}
+
+// pending returns true if the operation did not complete successfully, so it may or may not have taken effect.
+func (op Operation) pending() bool {
+	return op.Complete == 0 || op.Err != nil
+}
+
+func (op Operation) String() string {
+	var call string
+	switch op.Kind {
+	case Read:
+		call = fmt.Sprintf("read(%q) -> %#v", op.Key, op.Value)
+	case Write:
+		call = fmt.Sprintf("write(%q, %#v)", op.Key, op.Value)
+	}
+
+	complete := fmt.Sprint(op.Complete)
+	if op.pending() {
+		complete = "?"
+	}
+	if op.Err != nil {
+		call += fmt.Sprintf(" failed: %v", op.Err)
+	}
+	return fmt.Sprintf("[%v, %v] client %v: %v", op.Invoke, complete, op.Client, call)
+}
+
+// Recorder records the operations of many concurrent clients. Its zero value is ready to use.
+type Recorder struct {
+	mu      sync.Mutex
+	clock   int64
+	clients int
+	history []Operation
+}
+
+// Invoke records the invocation of an operation and returns its id, to be given to Complete.
+func (r *Recorder) Invoke(clientID int, kind Kind, key string, value interface{}) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.clock++
+	r.history = append(r.history, Operation{Client: clientID, Kind: kind, Key: key, Value: value, Invoke: r.clock})
+	return len(r.history) - 1
+}
+
+// Complete records the completion of operation id. value is the value read, it is ignored for writes.
+func (r *Recorder) Complete(id int, value interface{}, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.clock++
+	op := &r.history[id]
+	op.Complete = r.clock
+	op.Err = err
+	if op.Kind == Read {
+		op.Value = value
+	}
+}
+
+// History returns the operations recorded so far.
+func (r *Recorder) History() []Operation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Operation(nil), r.history...)
+}
+
+// Client returns a client that records the operations of cl in r.
+func (r *Recorder) Client(cl *client.Client) *Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.clients++
+	return &Client{ID: r.clients, client: cl, recorder: r}
+}
+
+// Client is a client.Client whose operations are recorded.
+type Client struct {
+	ID int
+
+	client   *client.Client
+	recorder *Recorder
+}
+
+// Write writes v to the default register.
+func (c *Client) Write(v interface{}) error {
+	return c.WriteKey("", v)
+}
+
+// Read reads the default register.
+func (c *Client) Read() (interface{}, error) {
+	return c.ReadKey("")
+}
+
+// WriteKey writes v to the register named key.
+func (c *Client) WriteKey(key string, v interface{}) error {
+	id := c.recorder.Invoke(c.ID, Write, key, v)
+	err := c.client.WriteKey(key, v)
+	c.recorder.Complete(id, nil, err)
+	return err
+}
+
+// ReadKey reads the register named key.
+func (c *Client) ReadKey(key string) (interface{}, error) {
+	id := c.recorder.Invoke(c.ID, Read, key, nil)
+	v, err := c.client.ReadKey(key)
+	c.recorder.Complete(id, v, err)
+	return v, err
+}
@@ -1,16 +1,81 @@
 package server
 
 import (
+	"errors"
 	"log"
-	"net/rpc"
-)
 
-type AdminService struct{}
+	"github.com/mateusbraga/freestore/pkg/comm"
+	"github.com/mateusbraga/freestore/pkg/view"
+)
 
-func init() { rpc.Register(new(AdminService)) }
+// AdminService is the RPC service used to administrate a server.
+type AdminService struct {
+	server *Server
+}
 
 func (r *AdminService) Leave(anything struct{}, reply *struct{}) error {
-    log.Println("AdminService requested to leave view")
-	globalServer.leave()
+	if !r.server.track() {
+		return ErrServerClosed
+	}
+	defer r.server.untrack()
+
+	log.Println("AdminService requested to leave view")
+	r.server.leave()
+	return nil
+}
+
+// InitMsg carries the genesis view, the first view of a new cluster.
+type InitMsg struct {
+	Genesis *view.View
+}
+
+// Init initializes the server with the genesis view of a new cluster, which
+// the server saves and starts serving. The server must have been started
+// without an initial view. It returns a GenesisMismatchError if the server
+// was initialized with another genesis view.
+func (r *AdminService) Init(arg InitMsg, reply *struct{}) error {
+	if !r.server.track() {
+		return ErrServerClosed
+	}
+	defer r.server.untrack()
+
+	if arg.Genesis == nil || arg.Genesis.NumberOfMembers() == 0 {
+		return errors.New("genesis view has no members")
+	}
+	return r.server.initialize(arg.Genesis)
+}
+
+// Genesis answers the genesis view the server was initialized with, nil if it was not initialized by Init.
+func (r *AdminService) Genesis(anything struct{}, reply **view.View) error {
+	r.server.currentViewMu.RLock()
+	defer r.server.currentViewMu.RUnlock()
+
+	*reply = r.server.genesis
+	return nil
+}
+
+// Process answers the process of the server, as it must appear in the genesis view.
+func (r *AdminService) Process(anything struct{}, reply *view.Process) error {
+	*reply = r.server.thisProcess
+	return nil
+}
+
+// SuspicionLevels answers, for each process this server talks to, how many
+// heartbeats in a row it failed to answer. It is empty if the transport of the
+// server has no failure detector.
+func (r *AdminService) SuspicionLevels(anything struct{}, reply *map[string]int) error {
+	levels := make(map[string]int)
+	if detector, ok := r.server.transport.(comm.FailureDetector); ok {
+		for process, level := range detector.SuspicionLevels() {
+			levels[process.String()] = level
+		}
+	}
+	*reply = levels
+	return nil
+}
+
+// ProtocolInstances answers how many per-view protocol instances the server keeps.
+func (r *AdminService) ProtocolInstances(anything struct{}, reply *ProtocolInstances) error {
+	*reply = r.server.ProtocolInstances()
 	return nil
 }
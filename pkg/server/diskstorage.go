@@ -0,0 +1,395 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+const (
+	// diskStorageSnapshotInterval is the number of records written to the
+	// write-ahead log before a new snapshot is taken and the log is emptied.
+	diskStorageSnapshotInterval = 1000
+
+	snapshotFileName = "snapshot"
+	walFileName      = "wal"
+)
+
+type walRecordType int
+
+const (
+	registersRecord walRecordType = iota
+	currentViewRecord
+	recvRecord
+	genesisRecord
+)
+
+// walRecord is a change appended to the write-ahead log. Only the fields of its Type are set.
+type walRecord struct {
+	Type        walRecordType
+	Registers   map[string]RegisterValue
+	CurrentView *view.View
+	Recv        map[view.Update]bool
+	Genesis     *view.View
+}
+
+// diskSnapshot is the whole state of a diskStorage.
+type diskSnapshot struct {
+	Registers   map[string]RegisterValue
+	CurrentView *view.View
+	Recv        map[view.Update]bool
+	Genesis     *view.View
+}
+
+// diskStorage is a Storage that survives restarts. Every change is appended
+// to a write-ahead log and synced to disk before it is acknowledged. From
+// time to time the whole state is written to a snapshot and the log is
+// emptied.
+type diskStorage struct {
+	// memoryStorage keeps the state in memory, the disk is only read on openDiskStorage.
+	memoryStorage
+
+	dir string
+
+	// wal and walRecords are protected by memoryStorage.kvMu
+	wal        *os.File
+	walRecords int
+
+	snapshotInterval int
+}
+
+var _ Storage = new(diskStorage)
+
+// OpenDiskStorage returns a Storage that keeps its state in dir, loading the state already saved there.
+func OpenDiskStorage(dir string) (Storage, error) {
+	return openDiskStorage(dir)
+}
+
+func openDiskStorage(dir string) (*diskStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &diskStorage{dir: dir, snapshotInterval: diskStorageSnapshotInterval}
+	s.keyvalues = make(map[string]RegisterValue)
+	s.versions = make(map[string]uint64)
+	s.recv = make(map[view.Update]bool)
+
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := s.replayWal(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *diskStorage) Write(name string, newValue RegisterValue) error {
+	s.storageMu.RLock()
+	defer s.storageMu.RUnlock()
+
+	s.kvMu.Lock()
+	defer s.kvMu.Unlock()
+
+	if currentValue := s.keyvalues[name]; !currentValue.Timestamp.Less(newValue.Timestamp) {
+		return nil
+	}
+
+	if err := s.appendLocked(walRecord{Type: registersRecord, Registers: map[string]RegisterValue{name: newValue}}); err != nil {
+		return err
+	}
+	s.writeLocked(name, newValue)
+	s.maybeSnapshotLocked()
+	return nil
+}
+
+func (s *diskStorage) MergeState(state map[string]RegisterValue) error {
+	s.kvMu.Lock()
+	defer s.kvMu.Unlock()
+
+	newerRegisters := make(map[string]RegisterValue)
+	for name, value := range state {
+		if currentValue := s.keyvalues[name]; currentValue.Timestamp.Less(value.Timestamp) {
+			newerRegisters[name] = value
+		}
+	}
+	if len(newerRegisters) == 0 {
+		return nil
+	}
+
+	if err := s.appendLocked(walRecord{Type: registersRecord, Registers: newerRegisters}); err != nil {
+		return err
+	}
+	for name, value := range newerRegisters {
+		s.writeLocked(name, value)
+	}
+	s.maybeSnapshotLocked()
+	return nil
+}
+
+func (s *diskStorage) SaveCurrentView(currentView *view.View) error {
+	s.kvMu.Lock()
+	defer s.kvMu.Unlock()
+
+	if err := s.appendLocked(walRecord{Type: currentViewRecord, CurrentView: currentView}); err != nil {
+		return err
+	}
+	s.currentView = currentView
+	s.maybeSnapshotLocked()
+	return nil
+}
+
+func (s *diskStorage) SaveRecv(recv map[view.Update]bool) error {
+	s.kvMu.Lock()
+	defer s.kvMu.Unlock()
+
+	recvCopy := copyRecv(recv)
+	if err := s.appendLocked(walRecord{Type: recvRecord, Recv: recvCopy}); err != nil {
+		return err
+	}
+	s.recv = recvCopy
+	s.maybeSnapshotLocked()
+	return nil
+}
+
+func (s *diskStorage) SaveGenesis(genesis *view.View) error {
+	s.kvMu.Lock()
+	defer s.kvMu.Unlock()
+
+	if err := s.appendLocked(walRecord{Type: genesisRecord, Genesis: genesis}); err != nil {
+		return err
+	}
+	s.genesis = genesis
+	s.maybeSnapshotLocked()
+	return nil
+}
+
+func (s *diskStorage) Close() error {
+	s.kvMu.Lock()
+	defer s.kvMu.Unlock()
+
+	if s.wal == nil {
+		return nil
+	}
+	err := s.wal.Close()
+	s.wal = nil
+	return err
+}
+
+// appendLocked writes record to the write-ahead log and syncs it to disk.
+func (s *diskStorage) appendLocked(record walRecord) error {
+	if s.wal == nil {
+		return errors.New("disk storage is closed")
+	}
+
+	frame, err := encodeWalRecord(record)
+	if err != nil {
+		return err
+	}
+	if _, err := s.wal.Write(frame); err != nil {
+		return err
+	}
+	if err := s.wal.Sync(); err != nil {
+		return err
+	}
+	s.walRecords++
+	return nil
+}
+
+// maybeSnapshotLocked takes a snapshot if the write-ahead log is long enough. It must be called after the appended records are applied.
+func (s *diskStorage) maybeSnapshotLocked() {
+	if s.walRecords < s.snapshotInterval {
+		return
+	}
+
+	// the records are already safe on the log, failing to take a snapshot only makes the log longer
+	if err := s.snapshotLocked(); err != nil {
+		log.Println("WARN: failed to take disk storage snapshot:", err)
+	}
+}
+
+// snapshotLocked writes the whole state to the snapshot file and empties the
+// write-ahead log. If it crashes after the snapshot is renamed but before the
+// log is emptied, replaying the log is harmless: registers only keep the most
+// recent timestamp and the last currentView and recv records of the log are
+// the ones in the snapshot.
+func (s *diskStorage) snapshotLocked() error {
+	snapshot := diskSnapshot{Registers: s.keyvalues, CurrentView: s.currentView, Recv: s.recv, Genesis: s.genesis}
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(snapshot); err != nil {
+		return err
+	}
+
+	tmpPath := filepath.Join(s.dir, snapshotFileName+".tmp")
+	if err := writeFileSync(tmpPath, buf.Bytes()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(s.dir, snapshotFileName)); err != nil {
+		return err
+	}
+	if err := syncDir(s.dir); err != nil {
+		return err
+	}
+
+	if err := s.wal.Truncate(0); err != nil {
+		return err
+	}
+	if err := s.wal.Sync(); err != nil {
+		return err
+	}
+	s.walRecords = 0
+	return nil
+}
+
+func (s *diskStorage) loadSnapshot() error {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, snapshotFileName))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var snapshot diskSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snapshot); err != nil {
+		return fmt.Errorf("corrupted snapshot in %v: %v", s.dir, err)
+	}
+
+	for name, value := range snapshot.Registers {
+		s.keyvalues[name] = value
+	}
+	s.currentView = snapshot.CurrentView
+	s.genesis = snapshot.Genesis
+	if snapshot.Recv != nil {
+		s.recv = snapshot.Recv
+	}
+	return nil
+}
+
+// replayWal applies the records of the write-ahead log and opens it for
+// appending. A record that was not completely written (the process crashed
+// while writing it) is discarded, it was never acknowledged.
+func (s *diskStorage) replayWal() error {
+	walPath := filepath.Join(s.dir, walFileName)
+
+	data, err := ioutil.ReadFile(walPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var validLength int
+	for validLength < len(data) {
+		record, frameLength, err := decodeWalRecord(data[validLength:])
+		if err != nil {
+			log.Printf("Discarding incomplete record at the end of %v: %v\n", walPath, err)
+			break
+		}
+
+		s.applyRecord(record)
+		validLength += frameLength
+		s.walRecords++
+	}
+
+	if validLength < len(data) {
+		if err := os.Truncate(walPath, int64(validLength)); err != nil {
+			return err
+		}
+	}
+
+	s.wal, err = os.OpenFile(walPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	return err
+}
+
+func (s *diskStorage) applyRecord(record walRecord) {
+	switch record.Type {
+	case registersRecord:
+		for name, value := range record.Registers {
+			s.writeLocked(name, value)
+		}
+	case currentViewRecord:
+		if s.currentView == nil || !record.CurrentView.LessUpdatedThan(s.currentView) {
+			s.currentView = record.CurrentView
+		}
+	case recvRecord:
+		s.recv = record.Recv
+		if s.recv == nil {
+			s.recv = make(map[view.Update]bool)
+		}
+	case genesisRecord:
+		s.genesis = record.Genesis
+	}
+}
+
+// A frame of the write-ahead log is the length and the CRC-32 of the gob encoded record, followed by it.
+const walFrameHeaderSize = 8
+
+func encodeWalRecord(record walRecord) ([]byte, error) {
+	payload := new(bytes.Buffer)
+	if err := gob.NewEncoder(payload).Encode(record); err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, walFrameHeaderSize, walFrameHeaderSize+payload.Len())
+	binary.BigEndian.PutUint32(frame[0:4], uint32(payload.Len()))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload.Bytes()))
+	return append(frame, payload.Bytes()...), nil
+}
+
+// decodeWalRecord decodes the record at the beginning of data and returns it with the length of its frame.
+func decodeWalRecord(data []byte) (walRecord, int, error) {
+	var record walRecord
+
+	if len(data) < walFrameHeaderSize {
+		return record, 0, errors.New("truncated header")
+	}
+
+	payloadLength := int(binary.BigEndian.Uint32(data[0:4]))
+	if payloadLength > len(data)-walFrameHeaderSize {
+		return record, 0, errors.New("truncated record")
+	}
+
+	payload := data[walFrameHeaderSize : walFrameHeaderSize+payloadLength]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(data[4:8]) {
+		return record, 0, errors.New("checksum mismatch")
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&record); err != nil {
+		return record, 0, err
+	}
+	return record, walFrameHeaderSize + payloadLength, nil
+}
+
+func writeFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+func TestDiskStorageRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freestore-diskstorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage, err := openDiskStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// take a few snapshots on the way
+	storage.snapshotInterval = 7
+
+	for i := 1; i <= 20; i++ {
+		storage.Write(fmt.Sprint("key", i%3), RegisterValue{Value: i, Timestamp: ts(i)})
+	}
+	storage.MergeState(map[string]RegisterValue{"merged": RegisterValue{Value: "m", Timestamp: ts(1)}})
+
+	v1 := view.NewWithProcesses(view.Process{Addr: "[::]:5000"}, view.Process{Addr: "[::]:5001"})
+	storage.SaveGenesis(v1)
+	storage.SaveCurrentView(v1)
+	recv := map[view.Update]bool{view.Update{Type: view.Join, Process: view.Process{Addr: "[::]:5002"}}: true}
+	storage.SaveRecv(recv)
+
+	if err := storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err = openDiskStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	for i := 18; i <= 20; i++ {
+		if v, _ := storage.Read(fmt.Sprint("key", i%3)); v.Timestamp != ts(i) || v.Value != i {
+			t.Errorf("key%v: expected value %v, got %v", i%3, i, v)
+		}
+	}
+	if v, _ := storage.Read("merged"); v.Value != "m" {
+		t.Errorf("merged: expected value m, got %v", v)
+	}
+
+	savedView, savedRecv, err := storage.LoadServerState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if savedView == nil || !savedView.Equal(v1) {
+		t.Errorf("expected current view %v, got %v", v1, savedView)
+	}
+	if len(savedRecv) != 1 || !savedRecv[view.Update{Type: view.Join, Process: view.Process{Addr: "[::]:5002"}}] {
+		t.Errorf("expected recv %v, got %v", recv, savedRecv)
+	}
+	if genesis, err := storage.LoadGenesis(); err != nil || genesis == nil || !genesis.Equal(v1) {
+		t.Errorf("expected genesis %v and no error, got %v and %v", v1, genesis, err)
+	}
+}
+
+func TestDiskStorageTornWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freestore-diskstorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage, err := openDiskStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.Write("a", RegisterValue{Value: "a1", Timestamp: ts(1)})
+	storage.Close()
+
+	// simulate a crash in the middle of writing a record
+	frame, err := encodeWalRecord(walRecord{Type: registersRecord, Registers: map[string]RegisterValue{"a": RegisterValue{Value: "a2", Timestamp: ts(2)}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal.Write(frame[:len(frame)/2])
+	wal.Close()
+
+	storage, err = openDiskStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := storage.Read("a"); v.Value != "a1" {
+		t.Errorf("expected a1 after torn write, got %v", v)
+	}
+
+	// the log must still be usable after the torn record is discarded
+	storage.Write("a", RegisterValue{Value: "a3", Timestamp: ts(3)})
+	storage.Close()
+
+	storage, err = openDiskStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	if v, _ := storage.Read("a"); v.Value != "a3" {
+		t.Errorf("expected a3, got %v", v)
+	}
+}
+
+// TestDiskStorageKilledMidWrite kills a process that is writing to a disk
+// storage and checks that no acknowledged write was lost.
+func TestDiskStorageKilledMidWrite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping crash test in short mode")
+	}
+
+	dir, err := ioutil.TempDir("", "freestore-diskstorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestDiskStorageWriterProcess")
+	cmd.Env = append(os.Environ(), "FREESTORE_DISKSTORAGE_DIR="+dir)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the writer process prints each timestamp after its write returns
+	var lastAcknowledged int
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		timestamp, err := strconv.Atoi(scanner.Text())
+		if err != nil {
+			continue
+		}
+		lastAcknowledged = timestamp
+		if lastAcknowledged == 300 {
+			break
+		}
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+
+	if lastAcknowledged == 0 {
+		t.Fatal("writer process acknowledged no writes")
+	}
+
+	storage, err := openDiskStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	v, _ := storage.Read("key")
+	if v.Timestamp.Less(ts(lastAcknowledged)) {
+		t.Errorf("storage restarted with timestamp %v, but %v was acknowledged", v.Timestamp, lastAcknowledged)
+	}
+}
+
+// TestDiskStorageWriterProcess is run as a separate process by TestDiskStorageKilledMidWrite.
+func TestDiskStorageWriterProcess(t *testing.T) {
+	dir := os.Getenv("FREESTORE_DISKSTORAGE_DIR")
+	if dir == "" {
+		return
+	}
+
+	storage, err := openDiskStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.snapshotInterval = 50
+
+	for counter := 1; ; counter++ {
+		if err := storage.Write("key", RegisterValue{Value: counter, Timestamp: ts(counter)}); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Println(counter)
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// ErrNotInitialized is returned by the requests that arrive before the server is initialized or joins a view.
+var ErrNotInitialized = errors.New("server not initialized")
+
+// GenesisMismatchError is returned by Init when the server was already initialized with a different genesis view.
+type GenesisMismatchError struct {
+	Process view.Process
+	Genesis view.ViewRef // genesis of the server
+	Other   view.ViewRef // genesis given to Init
+}
+
+func (e GenesisMismatchError) Error() string {
+	return fmt.Sprintf("process %v was initialized with genesis view %v, not %v", e.Process, e.Genesis, e.Other)
+}
+
+// initializedLocked tells if the server has a view, either because it was initialized or because it started with or joined one.
+func (s *Server) initializedLocked() bool {
+	return s.currentView.NumberOfUpdates() != 0
+}
+
+// checkInitializedLocked returns ErrNotInitialized if the server has no view yet.
+func (s *Server) checkInitializedLocked() error {
+	if !s.initializedLocked() {
+		return ErrNotInitialized
+	}
+	return nil
+}
+
+// initialize makes genesis the current view of the server, which must be one
+// of its members, and starts serving the registers. Initializing again with
+// the same genesis does nothing, so Init can be retried.
+func (s *Server) initialize(genesis *view.View) error {
+	s.currentViewMu.Lock()
+	defer s.currentViewMu.Unlock()
+
+	if s.genesis != nil {
+		if s.genesis.ViewRef != genesis.ViewRef {
+			return GenesisMismatchError{Process: s.thisProcess, Genesis: s.genesis.ViewRef, Other: genesis.ViewRef}
+		}
+		return nil
+	}
+	if s.initializedLocked() {
+		return fmt.Errorf("process %v already has view %v, it cannot be initialized", s.thisProcess, s.currentView)
+	}
+	if !genesis.HasMember(s.thisProcess) {
+		return fmt.Errorf("process %v is not a member of genesis view %v", s.thisProcess, genesis)
+	}
+
+	if err := s.storage.SaveGenesis(genesis); err != nil {
+		return err
+	}
+	if err := s.storage.SaveCurrentView(genesis); err != nil {
+		return err
+	}
+	s.genesis = genesis
+	s.currentView = genesis
+	// the registers of a new cluster are all written in the genesis view
+	s.setSyncedView(genesis)
+	s.unlockRegisters()
+
+	log.Printf("Initialized with genesis view %v, ref: %v\n", genesis, genesis.ViewRef)
+	return nil
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mateusbraga/freestore/pkg/client"
+	"github.com/mateusbraga/freestore/pkg/comm"
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+func TestServerInit(t *testing.T) {
+	transport := comm.NewMemoryTransport()
+	var servers []*Server
+	for _, addr := range []string{"memory:1", "memory:2", "memory:3", "memory:4"} {
+		servers = append(servers, startTestServer(t, transport, view.Process{Addr: addr}, nil, false))
+	}
+	defer func() {
+		for _, s := range servers {
+			s.Close()
+		}
+	}()
+
+	if _, err := client.GetCurrentViewWithTransport(transport, servers[0].Process()); err == nil || !strings.Contains(err.Error(), ErrNotInitialized.Error()) {
+		t.Fatalf("expected a not initialized error, got %v", err)
+	}
+
+	ctx := context.Background()
+	var members []view.Process
+	for _, s := range servers[:3] {
+		var process view.Process
+		if err := transport.Call(ctx, s.Process(), "AdminService.Process", struct{}{}, &process); err != nil {
+			t.Fatal(err)
+		}
+		members = append(members, process)
+	}
+	genesis := view.NewWithProcesses(members...)
+
+	for _, process := range members {
+		if err := transport.Call(ctx, process, "AdminService.Init", InitMsg{Genesis: genesis}, new(struct{})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// retrying is harmless
+	if err := transport.Call(ctx, members[0], "AdminService.Init", InitMsg{Genesis: genesis}, new(struct{})); err != nil {
+		t.Errorf("expected the same genesis to be accepted again, got %v", err)
+	}
+
+	getView := func() (*view.View, error) { return client.GetCurrentViewWithTransport(transport, members...) }
+	cl, err := client.NewWithTransport(transport, getView, getView)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Write(42); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := cl.Read(); err != nil || value != 42 {
+		t.Fatalf("expected 42 and no error, got %v and %v", value, err)
+	}
+
+	// a server of another cluster reports the mismatch
+	otherGenesis := view.NewWithProcesses(members[0], servers[3].Process())
+	err = transport.Call(ctx, members[0], "AdminService.Init", InitMsg{Genesis: otherGenesis}, new(struct{}))
+	if err == nil || !strings.Contains(err.Error(), genesis.ViewRef.String()) {
+		t.Errorf("expected a genesis mismatch error, got %v", err)
+	}
+
+	var serverGenesis *view.View
+	if err := transport.Call(ctx, members[1], "AdminService.Genesis", struct{}{}, &serverGenesis); err != nil {
+		t.Fatal(err)
+	}
+	if serverGenesis == nil || serverGenesis.ViewRef != genesis.ViewRef {
+		t.Errorf("expected genesis %v, got %v", genesis, serverGenesis)
+	}
+
+	// the server outside the genesis view still refuses requests
+	if _, err := client.GetCurrentViewWithTransport(transport, servers[3].Process()); err == nil {
+		t.Errorf("expected server %v to refuse requests", servers[3].Process())
+	}
+}
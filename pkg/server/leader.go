@@ -0,0 +1,58 @@
+package server
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/comm"
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+const (
+	// consensusRetryPeriod is how long a server waits for the result of a consensus instance before checking the leader and proposing again.
+	consensusRetryPeriod = 500 * time.Millisecond
+	// maxConsensusRetryPeriod bounds the back-off between the proposals of a server on the same consensus instance.
+	maxConsensusRetryPeriod = 8 * time.Second
+	// leaderPingTimeout is how long a server waits for the leader to answer a ping before suspecting it.
+	leaderPingTimeout = 250 * time.Millisecond
+)
+
+// consensusLeader returns the process that proposes on the consensus instance
+// of associatedView: the member at CONSENSUS_LEADER_PROCESS_POSITION, or, if
+// it is suspected, the next member in the order of the view that is not.
+// Every server that suspects the same members chooses the same leader.
+func (s *Server) consensusLeader(associatedView *view.View, detector comm.FailureDetector) view.Process {
+	members := associatedView.GetMembers()
+	sort.Slice(members, func(i, j int) bool { return members[i].Less(members[j]) })
+
+	for i := range members {
+		process := members[(CONSENSUS_LEADER_PROCESS_POSITION+i)%len(members)]
+		if process == s.thisProcess || !s.suspects(detector, process, leaderPingTimeout) {
+			return process
+		}
+	}
+	return s.thisProcess
+}
+
+// installedViewNewerThan tells if the current view of the server is more updated than associatedView, whose consensus instance is then over.
+func (s *Server) installedViewNewerThan(associatedView *view.View) bool {
+	s.currentViewMu.RLock()
+	defer s.currentViewMu.RUnlock()
+	return s.currentView.MoreUpdatedThan(associatedView)
+}
+
+// nextConsensusRetryPeriod doubles retryPeriod up to maxConsensusRetryPeriod.
+func nextConsensusRetryPeriod(retryPeriod time.Duration) time.Duration {
+	retryPeriod *= 2
+	if retryPeriod > maxConsensusRetryPeriod {
+		retryPeriod = maxConsensusRetryPeriod
+	}
+	return retryPeriod
+}
+
+// jitter returns a random duration between d/2 and 3d/2, so that the servers
+// that retry at the same time do not keep duelling with their proposals.
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/mateusbraga/freestore/pkg/comm"
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+func TestConsensusLeaderFailover(t *testing.T) {
+	transport := comm.NewMemoryTransport()
+	servers := startTestCluster(t, transport, 4, true)
+
+	initialView := servers[0].getCurrentView()
+	var leader *Server
+	var others []*Server
+	for _, s := range servers {
+		if initialView.GetProcessPosition(s.Process()) == CONSENSUS_LEADER_PROCESS_POSITION {
+			leader = s
+		} else {
+			others = append(others, s)
+		}
+	}
+	defer func() {
+		for _, s := range others {
+			s.Close()
+		}
+	}()
+
+	// the leader crashes, so the next member must propose the join
+	leader.Close()
+	if next := others[0].consensusLeader(initialView, nil); next == leader.Process() {
+		t.Fatalf("crashed leader %v was chosen again", next)
+	}
+
+	joiner := startTestServer(t, transport, view.Process{Addr: "memory:5"}, initialView, true)
+	others = append(others, joiner)
+
+	waitForServers(t, others, func(s *Server) bool { return s.getCurrentView().HasMember(joiner.Process()) }, "the join was not installed without the leader")
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/client"
+	"github.com/mateusbraga/freestore/pkg/linearizability"
+	"github.com/mateusbraga/freestore/pkg/simnet"
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// TestLinearizability runs concurrent clients doing random reads and writes
+// while a server joins and another leaves, and checks that the recorded
+// history is linearizable. The requests are delayed and reordered, so some
+// reads find different values and take the second phase. It runs with and
+// without reads during the state transfers of the reconfigurations.
+func TestLinearizability(t *testing.T) {
+	for _, readsDuringReconfiguration := range []bool{false, true} {
+		testLinearizability(t, readsDuringReconfiguration)
+	}
+}
+
+func testLinearizability(t *testing.T, readsDuringReconfiguration bool) {
+	const numClients = 4
+	opsPerClient := 60
+	if testing.Short() {
+		opsPerClient = 15
+	}
+	keys := []string{"", "a"}
+
+	var num2ndPhaseReads int
+	for _, seed := range simSeeds {
+		network := simnet.New(seed)
+		config := Config{ReadsDuringReconfiguration: readsDuringReconfiguration}
+		servers := startSimClusterWithConfig(t, network, 4, config)
+		network.AddRule(simnet.Rule{Method: "RegisterService", Duplicate: 0.1, MaxDelay: 3 * time.Millisecond})
+		network.AddRule(simnet.Rule{Method: "ReconfigurationRequest", MaxDelay: 10 * time.Millisecond})
+		network.AddRule(simnet.Rule{Method: "ViewGeneratorRequest", MaxDelay: 10 * time.Millisecond})
+		if readsDuringReconfiguration {
+			// make the transfers long enough for many reads to be answered during them
+			network.AddRule(simnet.Rule{Method: "ReconfigurationRequest.StateUpdate", MinDelay: 20 * time.Millisecond, MaxDelay: 50 * time.Millisecond})
+		}
+
+		var recorder linearizability.Recorder
+		var clients []*client.Client
+		var wg sync.WaitGroup
+		for i := 0; i < numClients; i++ {
+			clients = append(clients, newSimClient(t, network, servers[0]))
+			cl := recorder.Client(clients[i])
+			random := rand.New(rand.NewSource(seed*numClients + int64(i)))
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < opsPerClient; j++ {
+					key := keys[random.Intn(len(keys))]
+					if random.Intn(2) == 0 {
+						cl.WriteKey(key, fmt.Sprintf("%v-%v", cl.ID, j))
+					} else {
+						cl.ReadKey(key)
+					}
+				}
+			}()
+		}
+
+		// reconfigure while the clients run
+		joinerProcess := view.Process{Addr: "memory:5"}
+		config.InitialView = servers[0].getCurrentView()
+		joiner := startTestServerWithConfig(t, network.Transport(joinerProcess), joinerProcess, config)
+		waitForViews(t, network, []*Server{servers[0], joiner}, func(v *view.View) bool { return v.HasMember(joinerProcess) })
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := servers[3].Leave(ctx); err != nil {
+			t.Errorf("seed %v, reads during reconfiguration %v: Leave failed: %v", seed, readsDuringReconfiguration, err)
+		}
+		cancel()
+
+		wg.Wait()
+		for _, cl := range clients {
+			num2ndPhaseReads += cl.Num2ndPhaseReads()
+		}
+
+		if result := linearizability.Check(recorder.History()); !result.Ok {
+			t.Errorf("seed %v, reads during reconfiguration %v: %v", seed, readsDuringReconfiguration, result)
+		}
+
+		closeServers(append(servers, joiner))
+	}
+
+	if num2ndPhaseReads == 0 {
+		t.Error("no read took the second phase")
+	}
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+const (
+	// snapshotPageSize is the number of registers sent in each page of a snapshot.
+	snapshotPageSize = 1000
+	// snapshotPageTimeout is how long a joining server waits for a page of a snapshot before giving up on that member.
+	snapshotPageTimeout = 10 * time.Second
+)
+
+// A joining server pre-warms its registers before it asks to join: it pulls a
+// snapshot of the registers of each member of the view with Snapshot, and
+// tells the members which snapshots it merged in its ReconfigMsg. When the
+// view with the joining server is installed, those members send it only the
+// registers changed since its snapshot, so the state transfer that happens
+// while the R/W operations are disabled does not grow with the number of
+// registers. A member that did not serve a complete snapshot to the joining
+// server sends it all of its registers, as before.
+
+// SnapshotMsg asks a member for the page of its snapshot starting at Offset.
+// Offset 0 starts a new snapshot for Joiner, ID identifies it in the other pages.
+type SnapshotMsg struct {
+	Joiner view.Process
+	ID     uint64
+	Offset int
+}
+
+// SnapshotPage is a page of the snapshot ID. More tells if there are pages after it.
+type SnapshotPage struct {
+	ID    uint64
+	State map[string]RegisterValue
+	More  bool
+}
+
+// snapshotSession is a snapshot being pulled by a joining server. Every register
+// changed after version is sent again at installation time.
+type snapshotSession struct {
+	id      uint64
+	version uint64
+	names   []string
+}
+
+// snapshots keeps the snapshot sessions of the joining servers and the storage
+// version of the snapshot each of them confirmed it merged.
+type snapshots struct {
+	sessions  map[view.Process]*snapshotSession
+	confirmed map[view.Process]uint64
+	mu        sync.Mutex
+}
+
+func newSnapshots() *snapshots {
+	return &snapshots{
+		sessions:  make(map[view.Process]*snapshotSession),
+		confirmed: make(map[view.Process]uint64),
+	}
+}
+
+func (r *ReconfigurationRequest) Snapshot(arg SnapshotMsg, reply *SnapshotPage) error {
+	if !r.server.track() {
+		return ErrServerClosed
+	}
+	defer r.server.untrack()
+
+	r.server.currentViewMu.RLock()
+	err := r.server.checkInitializedLocked()
+	r.server.currentViewMu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	session, err := r.server.snapshotSession(arg)
+	if err != nil {
+		return err
+	}
+
+	end := arg.Offset + snapshotPageSize
+	if end > len(session.names) {
+		end = len(session.names)
+	}
+	reply.ID = session.id
+	reply.State = make(map[string]RegisterValue, end-arg.Offset)
+	for _, name := range session.names[arg.Offset:end] {
+		value, err := r.server.storage.Peek(name)
+		if err != nil {
+			return err
+		}
+		reply.State[name] = value
+	}
+	reply.More = end < len(session.names)
+	return nil
+}
+
+// snapshotSession returns the session of the snapshot arg asks a page of,
+// starting a new one if arg.Offset is 0.
+func (s *Server) snapshotSession(arg SnapshotMsg) (*snapshotSession, error) {
+	s.snapshots.mu.Lock()
+	defer s.snapshots.mu.Unlock()
+
+	if arg.Offset == 0 {
+		// take the version first: a register changed while its names are listed is sent again at installation time
+		session := &snapshotSession{id: uint64(rand.Int63()), version: s.storage.Version()}
+		session.names = s.storage.Names()
+		sort.Strings(session.names)
+
+		s.snapshots.sessions[arg.Joiner] = session
+		return session, nil
+	}
+
+	session, ok := s.snapshots.sessions[arg.Joiner]
+	if !ok || session.id != arg.ID || arg.Offset < 0 || arg.Offset > len(session.names) {
+		return nil, fmt.Errorf("unknown snapshot %v at offset %v for %v", arg.ID, arg.Offset, arg.Joiner)
+	}
+	return session, nil
+}
+
+// confirmSnapshot records that joiner merged the snapshot id of this server.
+func (s *Server) confirmSnapshot(joiner view.Process, id uint64) {
+	s.snapshots.mu.Lock()
+	defer s.snapshots.mu.Unlock()
+
+	session, ok := s.snapshots.sessions[joiner]
+	if !ok || session.id != id {
+		return
+	}
+	s.snapshots.confirmed[joiner] = session.version
+	delete(s.snapshots.sessions, joiner)
+}
+
+// prewarmedStateUpdates returns the state update for each member of
+// installView that confirmed a snapshot of this server: the registers changed
+// since the snapshot, instead of syncStateMsg.State.
+func (s *Server) prewarmedStateUpdates(installView *view.View, syncStateMsg SyncStateMsg) map[view.Process]SyncStateMsg {
+	s.snapshots.mu.Lock()
+	defer s.snapshots.mu.Unlock()
+
+	stateUpdates := make(map[view.Process]SyncStateMsg)
+	for joiner, version := range s.snapshots.confirmed {
+		if !installView.HasMember(joiner) {
+			continue
+		}
+		stateUpdate := syncStateMsg
+		stateUpdate.State = s.storage.StateSince(version)
+		stateUpdates[joiner] = stateUpdate
+	}
+	return stateUpdates
+}
+
+// releaseSnapshotsLocked forgets the snapshots of the joining servers that are
+// members of installedView, which was just installed.
+func (s *Server) releaseSnapshotsLocked(installedView *view.View) {
+	s.snapshots.mu.Lock()
+	defer s.snapshots.mu.Unlock()
+
+	for joiner := range s.snapshots.sessions {
+		if installedView.HasMember(joiner) {
+			delete(s.snapshots.sessions, joiner)
+		}
+	}
+	for joiner := range s.snapshots.confirmed {
+		if installedView.HasMember(joiner) {
+			delete(s.snapshots.confirmed, joiner)
+		}
+	}
+}
+
+// prewarm pulls the snapshots of the members of currentView and merges them
+// with the registers of this server. It returns the snapshot merged from each
+// member; a member that fails to send its whole snapshot is left out.
+func (s *Server) prewarm(currentView *view.View) map[view.Process]uint64 {
+	type result struct {
+		process view.Process
+		id      uint64
+		err     error
+	}
+	members := currentView.GetMembers()
+	resultChan := make(chan result, len(members))
+
+	for _, process := range members {
+		go func(process view.Process) {
+			id, err := s.pullSnapshot(process)
+			resultChan <- result{process, id, err}
+		}(process)
+	}
+
+	merged := make(map[view.Process]uint64)
+	for range members {
+		result := <-resultChan
+		if result.err != nil {
+			log.Printf("WARN: failed to pre-warm from %v: %v\n", result.process, result.err)
+			continue
+		}
+		merged[result.process] = result.id
+	}
+	log.Printf("Pre-warmed from %v of %v members\n", len(merged), len(members))
+	return merged
+}
+
+// pullSnapshot pulls all the pages of a snapshot of process and merges them. It returns the id of the snapshot.
+func (s *Server) pullSnapshot(process view.Process) (uint64, error) {
+	arg := SnapshotMsg{Joiner: s.thisProcess}
+	for {
+		var page SnapshotPage
+		if err := s.callUntilDone(process, "ReconfigurationRequest.Snapshot", arg, &page, snapshotPageTimeout); err != nil {
+			return 0, err
+		}
+		if err := s.storage.MergeState(page.State); err != nil {
+			return 0, err
+		}
+		if !page.More {
+			return page.ID, nil
+		}
+
+		arg.ID = page.ID
+		arg.Offset += len(page.State)
+	}
+}
+
+// callUntilDone invokes serviceMethod at process, giving up after timeout or when the server is closed.
+func (s *Server) callUntilDone(process view.Process, serviceMethod string, arg interface{}, result interface{}, timeout time.Duration) error {
+	ctx, cancel := s.contextUntilDone(timeout)
+	defer cancel()
+
+	return s.transport.Call(ctx, process, serviceMethod, arg, result)
+}
+
+// contextUntilDone returns a context that is done after timeout or when the server is closed.
+func (s *Server) contextUntilDone(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
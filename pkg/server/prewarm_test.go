@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/simnet"
+	"github.com/mateusbraga/freestore/pkg/timestamp"
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+func TestStateSince(t *testing.T) {
+	storage := newMemoryStorage()
+	storage.Write("a", RegisterValue{Value: 1, Timestamp: timestamp.Timestamp{Counter: 1}})
+	version := storage.Version()
+
+	storage.Write("b", RegisterValue{Value: 2, Timestamp: timestamp.Timestamp{Counter: 1}})
+	// an older value is not a change
+	storage.MergeState(map[string]RegisterValue{"a": {Value: 0}})
+
+	state := storage.StateSince(version)
+	if len(state) != 1 || state["b"].Value != 2 {
+		t.Errorf("expected only b to change since version %v, got %v", version, state)
+	}
+	if state := storage.StateSince(0); len(state) != 2 {
+		t.Errorf("expected a and b to change since version 0, got %v", state)
+	}
+}
+
+// TestPrewarm checks that a joining server pulls the registers of the members
+// before it joins, and that the members then only send it the registers
+// written since.
+func TestPrewarm(t *testing.T) {
+	const numberOfRegisters = 2*snapshotPageSize + 1
+
+	network := simnet.New(1)
+	servers := startSimCluster(t, network, 3, false)
+	for i := 0; i < numberOfRegisters; i++ {
+		value := RegisterValue{Value: i, Timestamp: timestamp.Timestamp{Counter: 1, WriterID: "preloaded"}}
+		for _, s := range servers {
+			s.storage.Write(fmt.Sprint(i), value)
+		}
+	}
+
+	// hold the join request and the state transfer, so a register is written after the snapshot and before the installation
+	network.AddRule(simnet.Rule{Method: "ReconfigurationRequest.Reconfig", MinDelay: 500 * time.Millisecond, MaxDelay: 500 * time.Millisecond})
+	network.AddRule(simnet.Rule{Method: "ReconfigurationRequest.StateUpdate", MinDelay: time.Second, MaxDelay: time.Second})
+	joinerProcess := view.Process{Addr: "memory:4"}
+	joiner := startTestServerWithConfig(t, network.Transport(joinerProcess), joinerProcess, Config{InitialView: servers[0].getCurrentView()})
+	defer closeServers(append(servers, joiner))
+
+	waitForServers(t, []*Server{joiner}, func(s *Server) bool { return len(s.storage.Names()) == numberOfRegisters }, "the joining server did not pull the registers of the members")
+
+	cl := newSimClient(t, network, servers[0])
+	if err := cl.WriteKey("late", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	viewWithJoiner := view.NewWithProcesses(append(servers[0].getCurrentView().GetMembers(), joinerProcess)...)
+	waitForServers(t, servers, func(s *Server) bool {
+		_, ok := s.prewarmedStateUpdates(viewWithJoiner, SyncStateMsg{})[joinerProcess]
+		return ok
+	}, "the snapshot of the joining server was not recorded")
+	for _, s := range servers {
+		stateUpdate := s.prewarmedStateUpdates(viewWithJoiner, SyncStateMsg{})[joinerProcess]
+		if _, ok := stateUpdate.State["late"]; len(stateUpdate.State) != 1 || !ok {
+			t.Errorf("server %v would send %v registers to the joining server, expected only the late one", s.Process(), len(stateUpdate.State))
+		}
+	}
+
+	waitForViews(t, network, append(servers, joiner), func(v *view.View) bool { return v.HasMember(joinerProcess) })
+	state := joiner.storage.State()
+	if len(state) != numberOfRegisters+1 || state["late"].Value != 1 || state[fmt.Sprint(numberOfRegisters-1)].Value != numberOfRegisters-1 {
+		t.Errorf("the joining server has %v registers after the join, expected %v", len(state), numberOfRegisters+1)
+	}
+	if v, err := cl.ReadKey("late"); err != nil || v != 1 {
+		t.Errorf("expected 1 and no error, got %v and %v", v, err)
+	}
+}
@@ -2,11 +2,10 @@ package server
 
 import (
 	"container/list"
+	"context"
 	"fmt"
 	"log"
 	"math/rand"
-	"net/rpc"
-	"os"
 	"sync"
 	"time"
 
@@ -22,31 +21,43 @@ const (
 )
 
 func (s *Server) resetReconfigurationTimerLoop() {
-	timer := time.AfterFunc(firstReconfigurationTimerDuration, s.startReconfiguration)
+	timer := time.AfterFunc(s.firstReconfigurationTimerDuration, s.startReconfiguration)
+	defer timer.Stop()
 	for {
-		<-s.resetReconfigurationTimerChan
-		timer.Reset(reconfigurationPeriod)
+		select {
+		case <-s.resetReconfigurationTimerChan:
+			timer.Reset(s.reconfigurationPeriod)
+		case <-s.done:
+			return
+		}
 	}
 }
 
 func (s *Server) startReconfiguration() {
+	// recv is checked with currentViewMu held: an installation that completes
+	// in the meantime removes its updates from recv, and reconfiguring the new
+	// view without updates would start its view generator with nothing to install
+	s.currentViewMu.RLock()
+	defer s.currentViewMu.RUnlock()
+
 	if !s.hasUpdatesToCurrentView() {
 		// restart reconfiguration timer
-		s.resetReconfigurationTimerChan <- true
+		select {
+		case s.resetReconfigurationTimerChan <- true:
+		case <-s.done:
+		}
 		return
 	}
 
-	s.currentViewMu.RLock()
-	defer s.currentViewMu.RUnlock()
-
 	log.Println("Starting reconfiguration of currentView:", s.currentView)
 
 	initialViewSeq := s.getInitialViewSeqLocked()
 
+	currentView := s.currentView
 	if s.useConsensus {
-		go s.generateViewSequenceWithConsensus(s.currentView, initialViewSeq)
+		s.spawn(func() { s.generateViewSequenceWithConsensus(currentView, initialViewSeq) })
 	} else {
-		go s.generateViewSequenceWithoutConsensus(s.currentView, initialViewSeq)
+		s.spawn(func() { s.generateViewSequenceWithoutConsensus(currentView, initialViewSeq) })
 	}
 }
 
@@ -82,7 +93,12 @@ type generatedViewSeq struct {
 
 func (s *Server) generatedViewSeqProcessingLoop() {
 	for {
-		newGeneratedViewSeq := <-s.generatedViewSeqChan
+		var newGeneratedViewSeq generatedViewSeq
+		select {
+		case newGeneratedViewSeq = <-s.generatedViewSeqChan:
+		case <-s.done:
+			return
+		}
 		log.Println("New generated view sequence:", newGeneratedViewSeq)
 
 		leastUpdatedView := newGeneratedViewSeq.ViewSeq.GetLeastUpdatedView()
@@ -100,7 +116,7 @@ func (s *Server) generatedViewSeqProcessingLoop() {
 		processes := append(newGeneratedViewSeq.AssociatedView.GetMembers(), leastUpdatedView.GetMembers()...)
 		mergedView := view.NewWithProcesses(processes...)
 
-		go broadcastInstallSeq(mergedView, installSeqMsg)
+		go s.broadcastInstallSeq(mergedView, installSeqMsg)
 	}
 }
 
@@ -124,13 +140,51 @@ func (quorumCounter *installSeqQuorumCounterType) count(newInstallSeq *InstallSe
 	return (1 == quorumSize)
 }
 
+// release forgets the install-seqs whose associated views are older than installedView.
+func (quorumCounter *installSeqQuorumCounterType) release(installedView *view.View) {
+	var list []*InstallSeq
+	var counter []int
+	for i, installSeq := range quorumCounter.list {
+		if !installedView.MoreUpdatedThan(installSeq.AssociatedView) {
+			list = append(list, installSeq)
+			counter = append(counter, quorumCounter.counter[i])
+		}
+	}
+	quorumCounter.list = list
+	quorumCounter.counter = counter
+}
+
 func (s *Server) installSeqProcessingLoop() {
 	processToInstallSeqMsgMap := make(map[view.Process]*InstallSeqMsg)
-	// ENHANCEMENT: clean up quorum counter old views
 	var installSeqQuorumCounter installSeqQuorumCounterType
+	// installedView is the last view installed, the install-seqs of older views are dropped
+	var installedView *view.View
 
 	for {
-		installSeqMsg := <-s.installSeqProcessingChan
+		s.setLoopInstances(func(instances *ProtocolInstances) {
+			instances.InstallSeqCounters = len(installSeqQuorumCounter.list)
+			instances.InstallSeqSenders = len(processToInstallSeqMsgMap)
+		})
+
+		var installSeqMsg InstallSeqMsg
+		select {
+		case installSeqMsg = <-s.installSeqProcessingChan:
+		case installedView = <-s.installSeqReleaseChan:
+			installSeqQuorumCounter.release(installedView)
+			for sender, previousInstallSeqMsg := range processToInstallSeqMsgMap {
+				if installedView.MoreUpdatedThan(previousInstallSeqMsg.AssociatedView) {
+					delete(processToInstallSeqMsgMap, sender)
+				}
+			}
+			continue
+		case <-s.done:
+			return
+		}
+
+		if installedView != nil && installedView.MoreUpdatedThan(installSeqMsg.AssociatedView) {
+			// late install-seq of a released view
+			continue
+		}
 
 		// Check for duplicate
 		previousInstallSeq, ok := processToInstallSeqMsgMap[installSeqMsg.Sender]
@@ -143,7 +197,7 @@ func (s *Server) installSeqProcessingLoop() {
 		// Re-send install-seq to all
 		processes := append(installSeqMsg.AssociatedView.GetMembers(), installSeqMsg.InstallView.GetMembers()...)
 		mergedView := view.NewWithProcesses(processes...)
-		go broadcastInstallSeq(mergedView, installSeqMsg)
+		go s.broadcastInstallSeq(mergedView, installSeqMsg)
 
 		// Quorum check
 		if installSeqQuorumCounter.count(&installSeqMsg.InstallSeq, installSeqMsg.AssociatedView.QuorumSize()) {
@@ -166,29 +220,22 @@ func (s *Server) gotInstallSeqQuorum(installSeq InstallSeq) {
 		if installViewIsMoreUpdatedThanCv {
 			// disable R/W operations if not already disabled
 			s.registerLockOnce.Do(func() {
-				s.register.mu.Lock()
+				s.lockRegisters()
 				s.registerLockTime = time.Now()
 				log.Println("R/W operations disabled for reconfiguration")
 			})
 		}
 
-		syncStateMsg := SyncStateMsg{}
-		syncStateMsg.Value = s.register.Value
-		syncStateMsg.Timestamp = s.register.Timestamp
-		s.recvMutex.RLock()
-		syncStateMsg.Recv = make(map[view.Update]bool, len(s.recv))
-		for update, _ := range s.recv {
-			syncStateMsg.Recv[update] = true
-		}
-		s.recvMutex.RUnlock()
-		syncStateMsg.AssociatedView = installSeq.AssociatedView
-
-		// Send state to all
-		go broadcastStateUpdate(installSeq.InstallView, syncStateMsg)
+		// Send state to all, see stateUpdatesLocked for the members that only get the registers changed recently
+		go s.broadcastStateUpdate(s.stateUpdatesLocked(installSeq))
 
 		log.Println("State sent!")
 	}
 
+	if installViewIsMoreUpdatedThanCv {
+		s.beginTransferLocked(installSeq.InstallView)
+	}
+
 	// stop here if installView is old
 	if !installViewIsMoreUpdatedThanCv {
 		if installSeq.ViewSeq.HasViewMoreUpdatedThan(s.currentView) {
@@ -202,9 +249,12 @@ func (s *Server) gotInstallSeqQuorum(installSeq InstallSeq) {
 
 	if installSeq.InstallView.HasMember(s.thisProcess) {
 		// Process is on the new view
-		s.syncState(installSeq)
+		if !s.syncState(installSeq) {
+			return
+		}
 
 		s.updateCurrentViewLocked(installSeq.InstallView)
+		s.setSyncedView(installSeq.InstallView)
 
 		viewInstalledMsg := ViewInstalledMsg{}
 		viewInstalledMsg.InstalledView = s.currentView
@@ -212,23 +262,32 @@ func (s *Server) gotInstallSeqQuorum(installSeq InstallSeq) {
 		// Send view-installed to all
 		processes := installSeq.AssociatedView.GetMembersNotIn(installSeq.InstallView)
 		viewOfLeavingProcesses := view.NewWithProcesses(processes...)
-		go broadcastViewInstalled(viewOfLeavingProcesses, viewInstalledMsg)
+		go s.broadcastViewInstalled(viewOfLeavingProcesses, viewInstalledMsg)
 
 		if installSeq.ViewSeq.HasViewMoreUpdatedThan(s.currentView) {
 			s.installOthersViewsFromViewSeqLocked(installSeq)
 		} else {
-			s.register.mu.Unlock()
+			s.unlockRegisters()
 			log.Println("R/W operations enabled")
 
 			endTime := time.Now()
 			if installSeq.AssociatedView.HasMember(s.thisProcess) {
-				log.Printf("Reconfiguration completed in %v, the system was unavailable for %v.\n", endTime.Sub(s.startReconfigurationTime), endTime.Sub(s.registerLockTime))
+				s.registersLockedFor = endTime.Sub(s.registerLockTime)
+				if s.readsDuringReconfiguration {
+					log.Printf("Reconfiguration completed in %v, writes were unavailable for %v.\n", endTime.Sub(s.startReconfigurationTime), endTime.Sub(s.registerLockTime))
+				} else {
+					log.Printf("Reconfiguration completed in %v, the system was unavailable for %v.\n", endTime.Sub(s.startReconfigurationTime), endTime.Sub(s.registerLockTime))
+				}
 				s.registerLockOnce = sync.Once{}
 			} else {
 				log.Println("Reconfiguration completed, this process is now part of the system.")
 			}
 
-			s.resetReconfigurationTimerChan <- true
+			// the timer loop stops once the server is closing
+			select {
+			case s.resetReconfigurationTimerChan <- true:
+			case <-s.done:
+			}
 		}
 	} else {
 		// thisProcess is NOT on the new view
@@ -236,7 +295,12 @@ func (s *Server) gotInstallSeqQuorum(installSeq InstallSeq) {
 
 		log.Println("Waiting for view-installed quorum to leave")
 		for {
-			viewInstalled := <-s.newViewInstalledChan
+			var viewInstalled ViewInstalledMsg
+			select {
+			case viewInstalled = <-s.newViewInstalledChan:
+			case <-s.done:
+				return
+			}
 
 			if installSeq.InstallView.Equal(viewInstalled.InstalledView) {
 				counter++
@@ -247,8 +311,7 @@ func (s *Server) gotInstallSeqQuorum(installSeq InstallSeq) {
 		}
 
 		log.Println("Leaving...")
-		//shutdownChan <- true
-		os.Exit(0)
+		go s.Close()
 	}
 }
 
@@ -263,8 +326,15 @@ func (s *Server) updateCurrentViewLocked(newView *view.View) {
 		return
 	}
 
+	if err := s.storage.SaveCurrentView(newView); err != nil {
+		log.Fatalln("FATAL: failed to save current view:", err)
+	}
 	s.currentView = newView
 	log.Printf("CurrentView updated to: %v, ref: %v\n", s.currentView, s.currentView.ViewRef)
+	s.endTransfer()
+
+	s.releaseOldInstancesLocked(newView)
+	s.releaseSnapshotsLocked(newView)
 }
 
 func (s *Server) installOthersViewsFromViewSeqLocked(installSeq InstallSeq) {
@@ -286,14 +356,20 @@ func (s *Server) installOthersViewsFromViewSeqLocked(installSeq InstallSeq) {
 // --------------------- State Update -----------------------
 
 type State struct {
-	finalValue *Value
-	recv       map[view.Update]bool
+	registers map[string]RegisterValue
+	recv      map[view.Update]bool
+}
+
+func newState() State {
+	return State{registers: make(map[string]RegisterValue), recv: make(map[view.Update]bool)}
 }
 
 func (thisState State) NewCopy() State {
-	stateCopy := State{finalValue: &Value{}}
-	stateCopy.finalValue.Value = thisState.finalValue.Value
-	stateCopy.finalValue.Timestamp = thisState.finalValue.Timestamp
+	stateCopy := State{}
+	stateCopy.registers = make(map[string]RegisterValue, len(thisState.registers))
+	for name, value := range thisState.registers {
+		stateCopy.registers[name] = value
+	}
 	stateCopy.recv = make(map[view.Update]bool, len(thisState.recv))
 
 	for update, _ := range thisState.recv {
@@ -330,17 +406,31 @@ func getStateUpdateQuorumCounter(stateUpdateQuorumCounterList *list.List, associ
 }
 
 func (s *Server) stateUpdateProcessingLoop() {
-	// ENHANCEMENT: clean up quorum counter old views
 	stateUpdateQuorumCounterList := list.New()
+	// installedView is the last view installed, the state updates of older views are dropped unless syncState waits for them
+	var installedView *view.View
 
 	for {
+		s.setLoopInstances(func(instances *ProtocolInstances) {
+			instances.StateUpdateCounters = stateUpdateQuorumCounterList.Len()
+		})
+
 		select {
 		case stateUpdate := <-s.syncStateMsgChan:
 			//log.Println("processing stateUpdate:", stateUpdate)
 
 			stateUpdateQuorum, ok := getStateUpdateQuorumCounter(stateUpdateQuorumCounterList, stateUpdate.AssociatedView)
+			if !ok && installedView != nil && installedView.MoreUpdatedThan(stateUpdate.AssociatedView) {
+				// late state update of a released view
+				continue
+			}
+			if stateUpdate.Since != nil && !s.syncedTo(stateUpdate.Since) {
+				// the registers this server kept since stateUpdate.Since are not enough, it needs all the registers of the sender
+				s.spawn(func() { s.pullStateUpdate(stateUpdate) })
+				continue
+			}
 			if !ok {
-				stateUpdateQuorum = &stateUpdateQuorumType{associatedView: stateUpdate.AssociatedView, State: State{&Value{Timestamp: -1}, make(map[view.Update]bool)}, counter: 0, resultChan: make(chan State, 1)}
+				stateUpdateQuorum = &stateUpdateQuorumType{associatedView: stateUpdate.AssociatedView, State: newState(), counter: 0, resultChan: make(chan State, 1)}
 				stateUpdateQuorumCounterList.PushBack(stateUpdateQuorum)
 			}
 
@@ -351,10 +441,11 @@ func (s *Server) stateUpdateProcessingLoop() {
 				stateUpdateQuorum.recv[update] = true
 			}
 
-			// update register value if necessary
-			if stateUpdateQuorum.finalValue.Timestamp < stateUpdate.Timestamp {
-				stateUpdateQuorum.finalValue.Value = stateUpdate.Value
-				stateUpdateQuorum.finalValue.Timestamp = stateUpdate.Timestamp
+			// update register values if necessary
+			for name, value := range stateUpdate.State {
+				if currentValue, ok := stateUpdateQuorum.registers[name]; !ok || currentValue.Timestamp.Less(value.Timestamp) {
+					stateUpdateQuorum.registers[name] = value
+				}
 			}
 
 			if stateUpdateQuorum.counter == stateUpdate.AssociatedView.QuorumSize() {
@@ -363,27 +454,54 @@ func (s *Server) stateUpdateProcessingLoop() {
 		case chanRequest := <-s.stateUpdateChanRequestChan:
 			stateUpdateQuorum, ok := getStateUpdateQuorumCounter(stateUpdateQuorumCounterList, chanRequest.associatedView)
 			if !ok {
-				stateUpdateQuorum = &stateUpdateQuorumType{associatedView: chanRequest.associatedView, State: State{&Value{Timestamp: -1}, make(map[view.Update]bool)}, counter: 0, resultChan: make(chan State, 1)}
+				stateUpdateQuorum = &stateUpdateQuorumType{associatedView: chanRequest.associatedView, State: newState(), counter: 0, resultChan: make(chan State, 1)}
 				stateUpdateQuorumCounterList.PushBack(stateUpdateQuorum)
 			}
 
 			chanRequest.returnChan <- stateUpdateQuorum.resultChan
+		case installedView = <-s.stateUpdateReleaseChan:
+			var next *list.Element
+			for quorumCounter := stateUpdateQuorumCounterList.Front(); quorumCounter != nil; quorumCounter = next {
+				next = quorumCounter.Next()
+				if installedView.MoreUpdatedThan(quorumCounter.Value.(*stateUpdateQuorumType).associatedView) {
+					stateUpdateQuorumCounterList.Remove(quorumCounter)
+				}
+			}
+		case <-s.done:
+			return
 		}
 	}
 }
 
-func (s *Server) syncState(installSeq InstallSeq) {
+// syncState waits for the state of a quorum of installSeq.AssociatedView and
+// merges it with the state of this server. It returns false if the server is
+// closed first.
+func (s *Server) syncState(installSeq InstallSeq) bool {
 	log.Println("Running syncState")
 
-	chanRequest := stateUpdateChanRequest{associatedView: installSeq.AssociatedView, returnChan: make(chan chan State)}
+	chanRequest := stateUpdateChanRequest{associatedView: installSeq.AssociatedView, returnChan: make(chan chan State, 1)}
 
 	// Request the chan in which the state will be sent
-	s.stateUpdateChanRequestChan <- chanRequest
+	select {
+	case s.stateUpdateChanRequestChan <- chanRequest:
+	case <-s.done:
+		return false
+	}
 	// Receive the chan in which the state will be sent
-	stateChan := <-chanRequest.returnChan
+	var stateChan chan State
+	select {
+	case stateChan = <-chanRequest.returnChan:
+	case <-s.done:
+		return false
+	}
 
 	// get state
-	state := <-stateChan
+	var state State
+	select {
+	case state = <-stateChan:
+	case <-s.done:
+		return false
+	}
 	defer func() { stateChan <- state }()
 
 	s.recvMutex.Lock()
@@ -393,24 +511,37 @@ func (s *Server) syncState(installSeq InstallSeq) {
 		s.recv[update] = true
 	}
 
-	s.register.Value = state.finalValue.Value
-	s.register.Timestamp = state.finalValue.Timestamp
+	if err := s.storage.MergeState(state.registers); err != nil {
+		log.Fatalln("FATAL: failed to merge synced state:", err)
+	}
 
 	for _, update := range installSeq.InstallView.GetUpdates() {
 		delete(s.recv, update)
 	}
 
+	if err := s.storage.SaveRecv(s.recv); err != nil {
+		log.Fatalln("FATAL: failed to save recv:", err)
+	}
+
 	log.Println("State synced")
+	return true
 }
 
 // ------------- Join and Leave ---------------------
 
+// joinLocked pre-warms the registers of the server with the state of the
+// members of the current view, then asks them to add the server to the view.
 func (s *Server) joinLocked() {
 	log.Println("Asked to Join current view:", s.currentView)
-	reconfig := ReconfigMsg{AssociatedView: s.currentView, Update: view.Update{view.Join, s.thisProcess}}
+	currentView := s.currentView
+
+	s.spawn(func() {
+		snapshots := s.prewarm(currentView)
+		reconfig := ReconfigMsg{AssociatedView: currentView, Update: view.Update{Type: view.Join, Process: s.thisProcess}, Snapshots: snapshots}
 
-	// Send reconfig request to currentView
-	go broadcastReconfigRequest(s.currentView, reconfig)
+		// Send reconfig request to currentView
+		s.broadcastReconfigRequest(currentView, reconfig)
+	})
 }
 
 func (s *Server) leave() {
@@ -418,10 +549,10 @@ func (s *Server) leave() {
 	defer s.currentViewMu.RUnlock()
 
 	log.Println("Asked to Leave current view:", s.currentView)
-	reconfig := ReconfigMsg{AssociatedView: s.currentView, Update: view.Update{view.Leave, s.thisProcess}}
+	reconfig := ReconfigMsg{AssociatedView: s.currentView, Update: view.Update{Type: view.Leave, Process: s.thisProcess}}
 
 	// Send reconfig request to all
-	go broadcastReconfigRequest(s.currentView, reconfig)
+	go s.broadcastReconfigRequest(s.currentView, reconfig)
 }
 
 // -------- REQUESTS -----------
@@ -429,6 +560,9 @@ func (s *Server) leave() {
 type ReconfigMsg struct {
 	Update         view.Update
 	AssociatedView *view.View
+
+	// Snapshots are the snapshots of each member the joining server merged before asking to join, see prewarm.
+	Snapshots map[view.Process]uint64
 }
 
 type InstallSeq struct {
@@ -475,36 +609,57 @@ func (installSeqMsg InstallSeqMsg) Equal(installSeqMsg2 InstallSeqMsg) bool {
 }
 
 type SyncStateMsg struct {
-	Value          interface{}
-	Timestamp      int
+	State          map[string]RegisterValue
 	Recv           map[view.Update]bool
 	AssociatedView *view.View
+	Sender         view.Process
+	// Since is nil if State has every register of Sender. Otherwise State only has the registers Sender changed
+	// after it installed Since, see statedelta.go.
+	Since *view.View
 }
 
 type ViewInstalledMsg struct {
 	InstalledView *view.View
 }
 
-type ReconfigurationRequest int
-
-func init() { rpc.Register(new(ReconfigurationRequest)) }
+// ReconfigurationRequest is the RPC service servers use to reconfigure the system.
+type ReconfigurationRequest struct {
+	server *Server
+}
 
 func (r *ReconfigurationRequest) Reconfig(arg ReconfigMsg, reply *struct{}) error {
-	globalServer.currentViewMu.RLock()
-	defer globalServer.currentViewMu.RUnlock()
+	if !r.server.track() {
+		return ErrServerClosed
+	}
+	defer r.server.untrack()
 
-	if !arg.AssociatedView.Equal(globalServer.currentView) {
+	r.server.currentViewMu.RLock()
+	defer r.server.currentViewMu.RUnlock()
+
+	if err := r.server.checkInitializedLocked(); err != nil {
+		return err
+	}
+	if !arg.AssociatedView.Equal(r.server.currentView) {
 		return fmt.Errorf("Reconfig request with old view")
 	}
 
-	if globalServer.currentView.HasUpdate(arg.Update) {
+	if r.server.currentView.HasUpdate(arg.Update) {
 		log.Printf("Reconfig request's Update %v already in currentView\n", arg.Update)
 		return nil
 	}
 
-	globalServer.recvMutex.Lock()
-	defer globalServer.recvMutex.Unlock()
-	globalServer.recv[arg.Update] = true
+	r.server.recvMutex.Lock()
+	defer r.server.recvMutex.Unlock()
+	r.server.recv[arg.Update] = true
+
+	if err := r.server.storage.SaveRecv(r.server.recv); err != nil {
+		delete(r.server.recv, arg.Update)
+		return err
+	}
+
+	if id, ok := arg.Snapshots[r.server.thisProcess]; ok && arg.Update.Type == view.Join {
+		r.server.confirmSnapshot(arg.Update.Process, id)
+	}
 
 	log.Printf("%v added to next reconfiguration\n", arg.Update)
 
@@ -512,34 +667,68 @@ func (r *ReconfigurationRequest) Reconfig(arg ReconfigMsg, reply *struct{}) erro
 }
 
 func (r *ReconfigurationRequest) InstallSeq(arg InstallSeqMsg, reply *struct{}) error {
-	globalServer.installSeqProcessingChan <- arg
-	return nil
+	if !r.server.track() {
+		return ErrServerClosed
+	}
+	defer r.server.untrack()
+
+	select {
+	case r.server.installSeqProcessingChan <- arg:
+		return nil
+	case <-r.server.done:
+		return ErrServerClosed
+	}
 }
 
 func (r *ReconfigurationRequest) StateUpdate(arg SyncStateMsg, reply *struct{}) error {
-	globalServer.syncStateMsgChan <- arg
-	return nil
+	if !r.server.track() {
+		return ErrServerClosed
+	}
+	defer r.server.untrack()
+
+	select {
+	case r.server.syncStateMsgChan <- arg:
+		return nil
+	case <-r.server.done:
+		return ErrServerClosed
+	}
 }
 
 func (r *ReconfigurationRequest) ViewInstalled(arg ViewInstalledMsg, reply *struct{}) error {
-	globalServer.newViewInstalledChan <- arg
-	return nil
+	if !r.server.track() {
+		return ErrServerClosed
+	}
+	defer r.server.untrack()
+
+	select {
+	case r.server.newViewInstalledChan <- arg:
+		return nil
+	case <-r.server.done:
+		return ErrServerClosed
+	}
 }
 
 // -------- Send functions -----------
 
-func broadcastViewInstalled(destinationView *view.View, viewInstalledMsg ViewInstalledMsg) {
-	comm.BroadcastRPCRequest(destinationView, "ReconfigurationRequest.ViewInstalled", viewInstalledMsg)
+func (s *Server) broadcastViewInstalled(destinationView *view.View, viewInstalledMsg ViewInstalledMsg) {
+	comm.Broadcast(s.transport, destinationView, "ReconfigurationRequest.ViewInstalled", viewInstalledMsg)
 }
 
-func broadcastStateUpdate(destinationView *view.View, syncStateMsg SyncStateMsg) {
-	comm.BroadcastRPCRequest(destinationView, "ReconfigurationRequest.StateUpdate", syncStateMsg)
+// broadcastStateUpdate sends its state update to each process of stateUpdates.
+func (s *Server) broadcastStateUpdate(stateUpdates map[view.Process]SyncStateMsg) {
+	for process, stateUpdate := range stateUpdates {
+		go func(process view.Process, stateUpdate SyncStateMsg) {
+			if err := s.transport.Call(context.Background(), process, "ReconfigurationRequest.StateUpdate", stateUpdate, new(struct{})); err != nil {
+				log.Printf("WARN: failed to send state update to %v: %v\n", process, err)
+			}
+		}(process, stateUpdate)
+	}
 }
 
-func broadcastInstallSeq(destinationView *view.View, installSeqMsg InstallSeqMsg) {
-	comm.BroadcastRPCRequest(destinationView, "ReconfigurationRequest.InstallSeq", installSeqMsg)
+func (s *Server) broadcastInstallSeq(destinationView *view.View, installSeqMsg InstallSeqMsg) {
+	comm.Broadcast(s.transport, destinationView, "ReconfigurationRequest.InstallSeq", installSeqMsg)
 }
 
-func broadcastReconfigRequest(destinationView *view.View, reconfigMsg ReconfigMsg) {
-	comm.BroadcastRPCRequest(destinationView, "ReconfigurationRequest.Reconfig", reconfigMsg)
+func (s *Server) broadcastReconfigRequest(destinationView *view.View, reconfigMsg ReconfigMsg) {
+	comm.Broadcast(s.transport, destinationView, "ReconfigurationRequest.Reconfig", reconfigMsg)
 }
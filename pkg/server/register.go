@@ -1,96 +1,188 @@
-//TODO make it a key value storage
-
 package server
 
 import (
 	"log"
-	"net/rpc"
 	"sync"
 
+	"github.com/mateusbraga/freestore/pkg/comm"
+	"github.com/mateusbraga/freestore/pkg/timestamp"
 	"github.com/mateusbraga/freestore/pkg/view"
 )
 
+// Value is the message used by the RegisterService RPCs to read and write the register named Key.
 type Value struct {
+	Key       string
 	Value     interface{}
-	Timestamp int
+	Timestamp timestamp.Timestamp
 
 	ViewRef view.ViewRef
 	Err     error
 
-	mu sync.RWMutex
+	// PendingView is the view being installed when a read was answered during
+	// the state transfer of a reconfiguration, see Config.ReadsDuringReconfiguration.
+	// The value may be outdated by writes in PendingView, so the reader must also
+	// read from a quorum of it.
+	PendingView *view.View
+	// NotInstalled tells that the server has not installed the view of the read
+	// yet, so no write was done in that view on the server, and Value is empty.
+	NotInstalled bool
+}
+
+// RegisterService is the RPC service clients use to read and write the registers of a server.
+type RegisterService struct {
+	server *Server
 }
 
-type RegisterService struct{}
+func (r *RegisterService) Read(arg Value, reply *Value) error {
+	if !r.server.track() {
+		return ErrServerClosed
+	}
+	defer r.server.untrack()
+
+	if !r.server.acquireRegisterSlot() {
+		reply.Err = comm.OverloadedError{Process: r.server.thisProcess}
+		return nil
+	}
+	defer r.server.releaseRegisterSlot()
 
-func init() { rpc.Register(new(RegisterService)) }
+	if answered, err := r.server.readDuringTransfer(arg, reply); answered {
+		return err
+	}
 
-func (r *RegisterService) Read(clientViewRef view.ViewRef, reply *Value) error {
-	globalServer.currentViewMu.RLock()
-	defer globalServer.currentViewMu.RUnlock()
+	r.server.currentViewMu.RLock()
+	defer r.server.currentViewMu.RUnlock()
 
-	if clientViewRef != globalServer.currentView.ViewRef {
-		log.Printf("Got old view with ViewRef: %v, sending new View %v with ViewRef: %v\n", clientViewRef, globalServer.currentView, globalServer.currentView.ViewRef)
-		reply.Err = view.OldViewError{NewView: globalServer.currentView}
+	if err := r.server.checkInitializedLocked(); err != nil {
+		return err
+	}
+	if arg.ViewRef != r.server.currentView.ViewRef {
+		log.Printf("Got old view with ViewRef: %v, sending new View %v with ViewRef: %v\n", arg.ViewRef, r.server.currentView, r.server.currentView.ViewRef)
+		reply.Err = view.OldViewError{NewView: r.server.currentView}
 		return nil
 	}
 
-	globalServer.register.mu.RLock()
-	defer globalServer.register.mu.RUnlock()
+	registerValue, err := r.server.storage.Read(arg.Key)
+	if err != nil {
+		return err
+	}
 
-	reply.Value = globalServer.register.Value
-	reply.Timestamp = globalServer.register.Timestamp
+	reply.Key = arg.Key
+	reply.Value = registerValue.Value
+	reply.Timestamp = registerValue.Timestamp
 
 	return nil
 }
 
-func (r *RegisterService) Write(value Value, reply *Value) error {
-	globalServer.currentViewMu.RLock()
-	defer globalServer.currentViewMu.RUnlock()
+func (r *RegisterService) Write(arg Value, reply *Value) error {
+	if !r.server.track() {
+		return ErrServerClosed
+	}
+	defer r.server.untrack()
 
-	if value.ViewRef != globalServer.currentView.ViewRef {
-		log.Printf("Got old view with ViewRef: %v, sending new View %v with ViewRef: %v\n", value.ViewRef, globalServer.currentView, globalServer.currentView.ViewRef)
-		reply.Err = view.OldViewError{NewView: globalServer.currentView}
+	if !r.server.acquireRegisterSlot() {
+		reply.Err = comm.OverloadedError{Process: r.server.thisProcess}
 		return nil
 	}
+	defer r.server.releaseRegisterSlot()
 
-	globalServer.register.mu.Lock()
-	defer globalServer.register.mu.Unlock()
+	r.server.currentViewMu.RLock()
+	defer r.server.currentViewMu.RUnlock()
 
-	// Two writes with the same timestamp -> give preference to first one. This makes the Write operation idempotent and still read/write coherent.
-	if value.Timestamp > globalServer.register.Timestamp {
-		globalServer.register.Value = value.Value
-		globalServer.register.Timestamp = value.Timestamp
+	if err := r.server.checkInitializedLocked(); err != nil {
+		return err
+	}
+	if arg.ViewRef != r.server.currentView.ViewRef {
+		log.Printf("Got old view with ViewRef: %v, sending new View %v with ViewRef: %v\n", arg.ViewRef, r.server.currentView, r.server.currentView.ViewRef)
+		reply.Err = view.OldViewError{NewView: r.server.currentView}
+		return nil
 	}
 
-	return nil
+	// Two writes with the same timestamp are the same write, as timestamps carry the writer id and the number of the write at that writer -> keep the first one. This makes the Write operation idempotent.
+	return r.server.storage.Write(arg.Key, RegisterValue{Value: arg.Value, Timestamp: arg.Timestamp})
 }
 
 func (r *RegisterService) GetCurrentView(anything struct{}, reply **view.View) error {
-	globalServer.currentViewMu.RLock()
-	defer globalServer.currentViewMu.RUnlock()
+	if !r.server.track() {
+		return ErrServerClosed
+	}
+	defer r.server.untrack()
 
-	*reply = globalServer.currentView
+	r.server.currentViewMu.RLock()
+	defer r.server.currentViewMu.RUnlock()
+
+	if err := r.server.checkInitializedLocked(); err != nil {
+		return err
+	}
+	*reply = r.server.currentView
 	log.Println("Done GetCurrentView request")
 	return nil
 }
 
+// RegisterValue is the content of a register kept in Storage.
 type RegisterValue struct {
 	Value     interface{}
-	Timestamp uint64
+	Timestamp timestamp.Timestamp
 }
 
-// TODO Add state synchronization logic to Storage
+// Storage keeps the registers of a server, indexed by name, and the state
+// the server needs to take part in reconfigurations.
+//
+// Read and Write wait while the storage is locked by LockAll; this is how a
+// reconfiguration disables R/W operations. Peek, State and MergeState ignore
+// that lock so the state can be transferred, and read, during the reconfiguration.
 type Storage interface {
 	Read(name string) (RegisterValue, error)
 	Write(name string, value RegisterValue) error
 	LockAll()
 	UnlockAll()
+
+	// Peek returns the register named name like Read, without waiting while the storage is locked.
+	Peek(name string) (RegisterValue, error)
+
+	// State returns a copy of every register kept in the storage.
+	State() map[string]RegisterValue
+	// Names returns the names of the registers kept in the storage.
+	Names() []string
+	// MergeState writes each register of state that is more recent than the one kept in the storage.
+	MergeState(state map[string]RegisterValue) error
+	// Version returns a counter that grows with every change to the registers
+	// of the storage. It is not persisted: it only compares states of the same
+	// running storage.
+	Version() uint64
+	// StateSince returns a copy of the registers changed after the storage was
+	// at version.
+	StateSince(version uint64) map[string]RegisterValue
+
+	// SaveCurrentView records the last view installed by the server.
+	SaveCurrentView(currentView *view.View) error
+	// SaveRecv records the updates that will be applied in the next reconfiguration.
+	SaveRecv(recv map[view.Update]bool) error
+	// LoadServerState returns what was saved by SaveCurrentView and SaveRecv. currentView is nil if no view was saved.
+	LoadServerState() (currentView *view.View, recv map[view.Update]bool, err error)
+
+	// SaveGenesis records the first view of the system, given when the server is initialized.
+	SaveGenesis(genesis *view.View) error
+	// LoadGenesis returns what was saved by SaveGenesis, nil if nothing was saved.
+	LoadGenesis() (*view.View, error)
+
+	// Close releases the resources used by the storage.
+	Close() error
 }
 
 type memoryStorage struct {
 	keyvalues map[string]RegisterValue
 	kvMu      sync.RWMutex
 	storageMu sync.RWMutex
+
+	// version counts the changes to keyvalues, and versions has the version
+	// of the last change to each register. Both are protected by kvMu.
+	version  uint64
+	versions map[string]uint64
+
+	// currentView, recv and genesis are protected by kvMu
+	currentView *view.View
+	recv        map[view.Update]bool
+	genesis     *view.View
 }
 
 var _ Storage = new(memoryStorage)
@@ -98,6 +190,8 @@ var _ Storage = new(memoryStorage)
 func newMemoryStorage() *memoryStorage {
 	return &memoryStorage{
 		keyvalues: make(map[string]RegisterValue),
+		versions:  make(map[string]uint64),
+		recv:      make(map[view.Update]bool),
 	}
 }
 
@@ -105,6 +199,10 @@ func (s *memoryStorage) Read(name string) (RegisterValue, error) {
 	s.storageMu.RLock()
 	defer s.storageMu.RUnlock()
 
+	return s.Peek(name)
+}
+
+func (s *memoryStorage) Peek(name string) (RegisterValue, error) {
 	s.kvMu.RLock()
 	defer s.kvMu.RUnlock()
 
@@ -123,14 +221,122 @@ func (s *memoryStorage) Write(name string, newValue RegisterValue) error {
 	s.kvMu.Lock()
 	defer s.kvMu.Unlock()
 
+	s.writeLocked(name, newValue)
+	return nil
+}
+
+// writeLocked writes newValue if it is more recent than the current one. It returns whether it did.
+func (s *memoryStorage) writeLocked(name string, newValue RegisterValue) bool {
 	currentValue := s.keyvalues[name]
 
-	if currentValue.Timestamp < newValue.Timestamp {
+	if currentValue.Timestamp.Less(newValue.Timestamp) {
 		s.keyvalues[name] = newValue
+		s.version++
+		s.versions[name] = s.version
+		return true
 	}
-
-	return nil
+	return false
 }
 
 func (s *memoryStorage) LockAll()   { s.storageMu.Lock() }
 func (s *memoryStorage) UnlockAll() { s.storageMu.Unlock() }
+
+func (s *memoryStorage) State() map[string]RegisterValue {
+	s.kvMu.RLock()
+	defer s.kvMu.RUnlock()
+
+	state := make(map[string]RegisterValue, len(s.keyvalues))
+	for name, value := range s.keyvalues {
+		state[name] = value
+	}
+	return state
+}
+
+func (s *memoryStorage) Names() []string {
+	s.kvMu.RLock()
+	defer s.kvMu.RUnlock()
+
+	names := make([]string, 0, len(s.keyvalues))
+	for name := range s.keyvalues {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (s *memoryStorage) MergeState(state map[string]RegisterValue) error {
+	s.kvMu.Lock()
+	defer s.kvMu.Unlock()
+
+	for name, value := range state {
+		s.writeLocked(name, value)
+	}
+	return nil
+}
+
+func (s *memoryStorage) Version() uint64 {
+	s.kvMu.RLock()
+	defer s.kvMu.RUnlock()
+
+	return s.version
+}
+
+func (s *memoryStorage) StateSince(version uint64) map[string]RegisterValue {
+	s.kvMu.RLock()
+	defer s.kvMu.RUnlock()
+
+	state := make(map[string]RegisterValue)
+	for name, registerVersion := range s.versions {
+		if registerVersion > version {
+			state[name] = s.keyvalues[name]
+		}
+	}
+	return state
+}
+
+func (s *memoryStorage) SaveCurrentView(currentView *view.View) error {
+	s.kvMu.Lock()
+	defer s.kvMu.Unlock()
+
+	s.currentView = currentView
+	return nil
+}
+
+func (s *memoryStorage) SaveRecv(recv map[view.Update]bool) error {
+	s.kvMu.Lock()
+	defer s.kvMu.Unlock()
+
+	s.recv = copyRecv(recv)
+	return nil
+}
+
+func (s *memoryStorage) LoadServerState() (*view.View, map[view.Update]bool, error) {
+	s.kvMu.RLock()
+	defer s.kvMu.RUnlock()
+
+	return s.currentView, copyRecv(s.recv), nil
+}
+
+func (s *memoryStorage) SaveGenesis(genesis *view.View) error {
+	s.kvMu.Lock()
+	defer s.kvMu.Unlock()
+
+	s.genesis = genesis
+	return nil
+}
+
+func (s *memoryStorage) LoadGenesis() (*view.View, error) {
+	s.kvMu.RLock()
+	defer s.kvMu.RUnlock()
+
+	return s.genesis, nil
+}
+
+func (s *memoryStorage) Close() error { return nil }
+
+func copyRecv(recv map[view.Update]bool) map[view.Update]bool {
+	recvCopy := make(map[view.Update]bool, len(recv))
+	for update, _ := range recv {
+		recvCopy[update] = true
+	}
+	return recvCopy
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/linearizability"
+	"github.com/mateusbraga/freestore/pkg/simnet"
+	"github.com/mateusbraga/freestore/pkg/timestamp"
+)
+
+// ts returns a timestamp with counter n written by a test writer.
+func ts(n int) timestamp.Timestamp {
+	if n == 0 {
+		return timestamp.Timestamp{}
+	}
+	return timestamp.Timestamp{Counter: n, WriterID: "test"}
+}
+
+func TestMemoryStorageKeys(t *testing.T) {
+	storage := newMemoryStorage()
+
+	storage.Write("a", RegisterValue{Value: "a1", Timestamp: ts(1)})
+	storage.Write("b", RegisterValue{Value: "b2", Timestamp: ts(2)})
+	// old timestamps must not overwrite newer values
+	storage.Write("b", RegisterValue{Value: "b1", Timestamp: ts(1)})
+
+	if v, _ := storage.Read("a"); v.Value != "a1" || v.Timestamp != ts(1) {
+		t.Errorf("Read(\"a\"): expected a1 with timestamp 1, got %v", v)
+	}
+	if v, _ := storage.Read("b"); v.Value != "b2" || v.Timestamp != ts(2) {
+		t.Errorf("Read(\"b\"): expected b2 with timestamp 2, got %v", v)
+	}
+	if v, _ := storage.Read("c"); v.Value != nil || v.Timestamp != ts(0) {
+		t.Errorf("Read(\"c\"): expected empty register, got %v", v)
+	}
+}
+
+func TestMemoryStorageState(t *testing.T) {
+	storage := newMemoryStorage()
+	storage.Write("a", RegisterValue{Value: "a2", Timestamp: ts(2)})
+	storage.Write("b", RegisterValue{Value: "b1", Timestamp: ts(1)})
+
+	state := storage.State()
+	if len(state) != 2 {
+		t.Fatalf("State: expected 2 registers, got %v", state)
+	}
+	if names := storage.Names(); len(names) != 2 {
+		t.Errorf("Names: expected 2 registers, got %v", names)
+	}
+
+	storage2 := newMemoryStorage()
+	storage2.Write("a", RegisterValue{Value: "a3", Timestamp: ts(3)})
+
+	// MergeState must work while R/W operations are disabled
+	storage2.LockAll()
+	storage2.MergeState(state)
+	storage2.UnlockAll()
+
+	if v, _ := storage2.Read("a"); v.Value != "a3" {
+		t.Errorf("MergeState overwrote a more recent register: got %v", v)
+	}
+	if v, _ := storage2.Read("b"); v.Value != "b1" {
+		t.Errorf("MergeState did not write register b: got %v", v)
+	}
+}
+
+// TestConcurrentWritersLinearizable runs several clients writing the same
+// register at once, each reading it back after its writes, and checks that the
+// recorded history is linearizable. Writers that read the same value write
+// over it concurrently, so their timestamps must still order their values the
+// same way at every replica.
+func TestConcurrentWritersLinearizable(t *testing.T) {
+	const numClients = 4
+	opsPerClient := 30
+	if testing.Short() {
+		opsPerClient = 10
+	}
+
+	for _, seed := range simSeeds {
+		network := simnet.New(seed)
+		servers := startSimCluster(t, network, 3, false)
+		network.AddRule(simnet.Rule{Method: "RegisterService", MaxDelay: 3 * time.Millisecond})
+
+		var recorder linearizability.Recorder
+		var wg sync.WaitGroup
+		for i := 0; i < numClients; i++ {
+			cl := recorder.Client(newSimClient(t, network, servers[0]))
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < opsPerClient; j++ {
+					cl.Write(fmt.Sprintf("%v-%v", cl.ID, j))
+					cl.Read()
+				}
+			}()
+		}
+		wg.Wait()
+
+		if result := linearizability.Check(recorder.History()); !result.Ok {
+			t.Errorf("seed %v: %v", seed, result)
+		}
+		closeServers(servers)
+	}
+}
+
+// TestConcurrentWritesOnOneClient runs many goroutines writing the same
+// register through one client. Writes that read the same value must still get
+// different timestamps, otherwise the replicas keep whichever write arrives
+// first and diverge.
+func TestConcurrentWritesOnOneClient(t *testing.T) {
+	const numWorkers = 8
+	opsPerWorker := 20
+	if testing.Short() {
+		opsPerWorker = 5
+	}
+
+	for _, seed := range simSeeds {
+		network := simnet.New(seed)
+		servers := startSimCluster(t, network, 3, false)
+		network.AddRule(simnet.Rule{Method: "RegisterService", MaxDelay: 3 * time.Millisecond})
+		shared := newSimClient(t, network, servers[0])
+
+		var recorder linearizability.Recorder
+		var wg sync.WaitGroup
+		for i := 0; i < numWorkers; i++ {
+			cl := recorder.Client(shared)
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < opsPerWorker; j++ {
+					cl.Write(fmt.Sprintf("%v-%v", cl.ID, j))
+					cl.Read()
+				}
+			}()
+		}
+		wg.Wait()
+
+		if result := linearizability.Check(recorder.History()); !result.Ok {
+			t.Errorf("seed %v: %v", seed, result)
+		}
+		for _, s := range servers[1:] {
+			v0, _ := servers[0].storage.Peek("")
+			v, _ := s.storage.Peek("")
+			if v.Timestamp == v0.Timestamp && v.Value != v0.Value {
+				t.Errorf("seed %v: servers %v and %v keep different values with timestamp %v", seed, servers[0].Process(), s.Process(), v.Timestamp)
+			}
+		}
+		closeServers(servers)
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// ProtocolInstances counts the per-view state of the reconfiguration protocol
+// kept by a server. The state of a view is released once the server installs
+// a more updated view, so these numbers stay small in a long-lived cluster.
+type ProtocolInstances struct {
+	ViewGenerators      int // view generators, each with a worker goroutine
+	ConsensusInstances  int // consensus instances, each with a worker goroutine
+	InstallSeqCounters  int // install-seq quorum counters
+	InstallSeqSenders   int // last install-seq received from each process
+	StateUpdateCounters int // state update quorum counters
+}
+
+// ProtocolInstances returns the number of per-view protocol instances the server keeps.
+func (s *Server) ProtocolInstances() ProtocolInstances {
+	s.loopInstancesMu.Lock()
+	instances := s.loopInstances
+	s.loopInstancesMu.Unlock()
+
+	s.viewGeneratorsMu.Lock()
+	instances.ViewGenerators = len(s.viewGenerators)
+	s.viewGeneratorsMu.Unlock()
+
+	instances.ConsensusInstances = s.consensus.NumberOfInstances()
+	return instances
+}
+
+// setLoopInstances lets a loop update its counts in s.loopInstances.
+func (s *Server) setLoopInstances(update func(instances *ProtocolInstances)) {
+	s.loopInstancesMu.Lock()
+	defer s.loopInstancesMu.Unlock()
+	update(&s.loopInstances)
+}
+
+// releaseOldInstancesLocked releases the protocol state of the views older
+// than installedView, which was just installed. A late message for one of
+// those views is then dropped instead of creating its state again.
+func (s *Server) releaseOldInstancesLocked(installedView *view.View) {
+	s.consensus.Release(installedView)
+	s.releaseViewGenerators(installedView)
+
+	notifyInstalledView(s.installSeqReleaseChan, installedView)
+	notifyInstalledView(s.stateUpdateReleaseChan, installedView)
+}
+
+// releaseViewGenerators stops the view generators of the views older than installedView.
+func (s *Server) releaseViewGenerators(installedView *view.View) {
+	s.viewGeneratorsMu.Lock()
+	defer s.viewGeneratorsMu.Unlock()
+
+	if s.releasedView == nil || installedView.MoreUpdatedThan(s.releasedView) {
+		s.releasedView = installedView
+	}
+
+	var viewGenerators []viewGeneratorInstance
+	for _, vgi := range s.viewGenerators {
+		if installedView.MoreUpdatedThan(vgi.AssociatedView) {
+			close(vgi.released)
+		} else {
+			viewGenerators = append(viewGenerators, vgi)
+		}
+	}
+	s.viewGenerators = viewGenerators
+}
+
+// notifyInstalledView replaces the view waiting in releaseChan with
+// installedView. It does not block because only the server holding
+// currentViewMu sends on releaseChan.
+func notifyInstalledView(releaseChan chan *view.View, installedView *view.View) {
+	select {
+	case <-releaseChan:
+	default:
+	}
+	releaseChan <- installedView
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mateusbraga/freestore/pkg/comm"
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// TestReleaseOldInstances checks that the servers release the reconfiguration state of the views they replaced.
+func TestReleaseOldInstances(t *testing.T) {
+	for _, useConsensus := range []bool{false, true} {
+		transport := comm.NewMemoryTransport()
+		servers := startTestCluster(t, transport, 3, useConsensus)
+
+		for i := 4; i <= 7; i++ {
+			joiner := startTestServer(t, transport, view.Process{Addr: fmt.Sprintf("memory:%v", i)}, servers[0].getCurrentView(), useConsensus)
+			servers = append(servers, joiner)
+
+			waitForServers(t, servers, func(s *Server) bool { return s.getCurrentView().HasMember(joiner.Process()) }, fmt.Sprintf("consensus %v: the join of %v was not installed", useConsensus, joiner.Process()))
+		}
+
+		// without the release, each server would keep the state of the four reconfigurations, and a late message could create it again
+		waitForServers(t, servers, func(s *Server) bool { return s.ProtocolInstances() == ProtocolInstances{} }, fmt.Sprintf("consensus %v: a server keeps the state of old views", useConsensus))
+		for _, s := range servers {
+			s.Close()
+		}
+	}
+}
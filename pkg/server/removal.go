@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/comm"
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// maxRemovalCheckPeriod is the longest time between two checks of the members of the current view.
+const maxRemovalCheckPeriod = 1 * time.Second
+
+// removalLoop pings the other members of the current view and asks for the
+// removal of those that are suspected for s.removeSuspectedAfter by a quorum
+// of the view. A member is suspected if it does not answer the ping or if the
+// failure detector of the transport suspects it. A link that fails between
+// two live members does not make a quorum suspect either of them.
+func (s *Server) removalLoop() {
+	checkPeriod := s.removalCheckPeriod()
+	ticker := time.NewTicker(checkPeriod)
+	defer ticker.Stop()
+
+	detector, _ := s.transport.(comm.FailureDetector)
+	suspectedSince := make(map[view.Process]time.Time)
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+
+		s.currentViewMu.RLock()
+		members := s.currentView.GetMembers()
+		s.currentViewMu.RUnlock()
+
+		now := time.Now()
+		stillMembers := make(map[view.Process]bool, len(members))
+		for _, process := range members {
+			if process == s.thisProcess {
+				continue
+			}
+			stillMembers[process] = true
+
+			if !s.suspects(detector, process, checkPeriod) {
+				delete(suspectedSince, process)
+				continue
+			}
+
+			since, ok := suspectedSince[process]
+			if !ok {
+				suspectedSince[process] = now
+				continue
+			}
+			if now.Sub(since) >= s.removeSuspectedAfter && s.quorumSuspects(process) {
+				s.requestRemoval(process)
+			}
+		}
+
+		// forget the processes that already left
+		for process := range suspectedSince {
+			if !stillMembers[process] {
+				delete(suspectedSince, process)
+			}
+		}
+	}
+}
+
+// removalCheckPeriod is the time between two checks of the members of the current view.
+func (s *Server) removalCheckPeriod() time.Duration {
+	checkPeriod := s.removeSuspectedAfter / 4
+	if checkPeriod <= 0 || checkPeriod > maxRemovalCheckPeriod {
+		// a server that never asks for removals still answers Suspect
+		checkPeriod = maxRemovalCheckPeriod
+	}
+	return checkPeriod
+}
+
+// quorumSuspects tells if a quorum of the current view, counting this server,
+// suspects process. It asks the other members with ReconfigurationRequest.Suspect.
+func (s *Server) quorumSuspects(process view.Process) bool {
+	s.currentViewMu.RLock()
+	currentView := s.currentView
+	s.currentViewMu.RUnlock()
+
+	// the others ping process within a check period, give them another one to answer
+	ctx, cancel := s.contextUntilDone(2 * s.removalCheckPeriod())
+	defer cancel()
+
+	var others []view.Process
+	for _, member := range currentView.GetMembers() {
+		if member != s.thisProcess && member != process {
+			others = append(others, member)
+		}
+	}
+	answerChan := make(chan bool, len(others))
+	for _, member := range others {
+		go func(member view.Process) {
+			var suspected bool
+			err := s.transport.Call(ctx, member, "ReconfigurationRequest.Suspect", process, &suspected)
+			answerChan <- err == nil && suspected
+		}(member)
+	}
+
+	suspecting := 1
+	for answers := 0; suspecting < currentView.QuorumSize() && answers < len(others); answers++ {
+		if <-answerChan {
+			suspecting++
+		}
+	}
+	if suspecting >= currentView.QuorumSize() {
+		return true
+	}
+	log.Printf("Process %v is suspected, but only by %v members of the view: %v\n", process, suspecting, currentView)
+	return false
+}
+
+// Suspect answers whether the server suspects process, see quorumSuspects.
+func (r *ReconfigurationRequest) Suspect(process view.Process, reply *bool) error {
+	if !r.server.track() {
+		return ErrServerClosed
+	}
+	defer r.server.untrack()
+
+	detector, _ := r.server.transport.(comm.FailureDetector)
+	*reply = r.server.suspects(detector, process, r.server.removalCheckPeriod())
+	return nil
+}
+
+// suspects tells if process seems to have crashed: it does not answer a ping within timeout, or detector suspects it.
+func (s *Server) suspects(detector comm.FailureDetector, process view.Process, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := s.transport.Call(ctx, process, "HeartbeatService.Ping", struct{}{}, new(struct{}))
+	if errors.Is(err, comm.ErrUnreachable) || errors.Is(err, comm.ErrCanceled) {
+		return true
+	}
+	return detector != nil && detector.Suspected(process)
+}
+
+// requestRemoval asks the members of the current view to remove process, unless
+// the removal would leave fewer than s.minViewSize members.
+func (s *Server) requestRemoval(process view.Process) {
+	s.currentViewMu.RLock()
+	defer s.currentViewMu.RUnlock()
+
+	removal := view.Update{Type: view.Leave, Process: process}
+	if !s.currentView.HasMember(process) {
+		return
+	}
+
+	s.recvMutex.RLock()
+	_, alreadyRequested := s.recv[removal]
+	updates := []view.Update{removal}
+	for update := range s.recv {
+		updates = append(updates, update)
+	}
+	s.recvMutex.RUnlock()
+	if alreadyRequested {
+		return
+	}
+
+	if size := s.currentView.NewCopyWithUpdates(updates...).NumberOfMembers(); size < s.minViewSize {
+		log.Printf("WARN: Process %v is suspected, but removing it would leave %v members, less than the minimum of %v\n", process, size, s.minViewSize)
+		return
+	}
+
+	log.Printf("Process %v is suspected for %v, asking for its removal from the view: %v\n", process, s.removeSuspectedAfter, s.currentView)
+	reconfig := ReconfigMsg{AssociatedView: s.currentView, Update: removal}
+
+	// Send reconfig request to all
+	go s.broadcastReconfigRequest(s.currentView, reconfig)
+}
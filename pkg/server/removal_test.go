@@ -0,0 +1,102 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/comm"
+	"github.com/mateusbraga/freestore/pkg/simnet"
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// startRemovalTestCluster starts n servers that remove the members suspected for 200ms, keeping at least minViewSize of them.
+func startRemovalTestCluster(t *testing.T, transport comm.Transport, n int, minViewSize int) []*Server {
+	var processes []view.Process
+	for i := 1; i <= n; i++ {
+		processes = append(processes, view.Process{Addr: fmt.Sprintf("memory:%v", i)})
+	}
+	initialView := view.NewWithProcesses(processes...)
+
+	var servers []*Server
+	for _, process := range processes {
+		s, err := New(Config{
+			BindAddr:              process.Addr,
+			InitialView:           initialView,
+			Transport:             transport,
+			ReconfigurationPeriod: 100 * time.Millisecond,
+			RemoveSuspectedAfter:  200 * time.Millisecond,
+			MinViewSize:           minViewSize,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		go s.Run()
+		servers = append(servers, s)
+	}
+	return servers
+}
+
+func TestRemoveCrashedServer(t *testing.T) {
+	transport := comm.NewMemoryTransport()
+	servers := startRemovalTestCluster(t, transport, 4, 3)
+	crashed, others := servers[3], servers[:3]
+	defer func() {
+		for _, s := range others {
+			s.Close()
+		}
+	}()
+
+	// Close does not ask the others to remove the server, as in a crash
+	crashed.Close()
+
+	waitForServers(t, others, func(s *Server) bool { return !s.getCurrentView().HasMember(crashed.Process()) }, "the crashed server was not removed")
+	for _, s := range others {
+		if n := s.getCurrentView().NumberOfMembers(); n != 3 {
+			t.Errorf("expected 3 members at server %v, got %v", s.Process(), n)
+		}
+	}
+}
+
+func TestRemoveCrashedServerRespectsMinViewSize(t *testing.T) {
+	transport := comm.NewMemoryTransport()
+	servers := startRemovalTestCluster(t, transport, 3, 3)
+	crashed, others := servers[2], servers[:2]
+	defer func() {
+		for _, s := range others {
+			s.Close()
+		}
+	}()
+
+	crashed.Close()
+
+	// long enough for a removal to be requested and installed
+	time.Sleep(time.Second)
+	for _, s := range others {
+		if !s.getCurrentView().HasMember(crashed.Process()) {
+			t.Errorf("server %v removed %v below the minimum view size, its view is %v", s.Process(), crashed.Process(), s.getCurrentView())
+		}
+	}
+}
+
+// TestOneWayLinkFailureDoesNotRemoveServer checks that a live server is not
+// removed when only one member cannot reach it.
+func TestOneWayLinkFailureDoesNotRemoveServer(t *testing.T) {
+	network := simnet.New(1)
+	servers := startSimClusterWithConfig(t, network, 4, Config{RemoveSuspectedAfter: 200 * time.Millisecond, MinViewSize: 3})
+	defer closeServers(servers)
+	cutOff, live := servers[0], servers[3]
+
+	network.AddRule(simnet.Rule{From: cutOff.Process(), To: live.Process(), Drop: 1})
+	if !cutOff.suspects(nil, live.Process(), time.Second) {
+		t.Fatalf("server %v does not suspect %v with their link cut", cutOff.Process(), live.Process())
+	}
+
+	// long enough for a removal to be requested and installed
+	time.Sleep(time.Second)
+	for _, s := range servers {
+		if !s.getCurrentView().HasMember(live.Process()) {
+			t.Errorf("server %v removed %v, which only %v could not reach, its view is %v", s.Process(), live.Process(), cutOff.Process(), s.getCurrentView())
+		}
+	}
+}
@@ -1,16 +1,19 @@
 /*
 Package server implements a Freestore server.
-
 */
 package server
 
 import (
+	"fmt"
 	"log"
 	"net"
 	"net/rpc"
+	"path/filepath"
 	"sync"
 	"time"
 
+	"github.com/mateusbraga/freestore/pkg/comm"
+	"github.com/mateusbraga/freestore/pkg/consensus"
 	"github.com/mateusbraga/freestore/pkg/view"
 	//TODO count faults, faults masked, errors, failures and put in expvar
 )
@@ -19,21 +22,36 @@ const (
 	CHANNEL_DEFAULT_SIZE = 20
 )
 
-var (
-	globalServer *Server
-)
-
 type Server struct {
 	// initialization parameters
 	thisProcess  view.Process
-	listener     net.Listener
+	listener     *trackingListener
 	useConsensus bool
 
-	// register is protected by the mutex register.mu
-	register Value
+	// transport carries the requests this server sends, and rpcServer serves the requests it receives
+	transport comm.Transport
+	rpcServer *rpc.Server
+
+	// consensus runs the consensus instances of this server
+	consensus *consensus.Consensus
+
+	// reconfigurationPeriod is the time between the reconfigurations
+	reconfigurationPeriod             time.Duration
+	firstReconfigurationTimerDuration time.Duration
 
-	// currentView of the server
+	// removeSuspectedAfter is how long a member must be suspected before this server asks for its removal, zero if it never does; minViewSize is the least number of members such a removal may leave
+	removeSuspectedAfter time.Duration
+	minViewSize          int
+
+	// storage keeps the registers of this server and saves currentView and recv
+	storage Storage
+
+	// registerSlots limits the register requests in progress, nil if there is no limit
+	registerSlots chan struct{}
+
+	// currentView of the server, which has no updates until the server is initialized. genesis is the view the server was initialized with, nil if it started with an initial view. Both are protected by currentViewMu.
 	currentView   *view.View
+	genesis       *view.View
 	currentViewMu sync.RWMutex
 
 	// recv keeps the updates that will be applied to the currentView in the next reconfiguration
@@ -43,10 +61,42 @@ type Server struct {
 	// viewGenerators keep the data required to run a view generator
 	viewGenerators   []viewGeneratorInstance
 	viewGeneratorsMu sync.Mutex
+	// releasedView is the view the view generators were last released for, protected by viewGeneratorsMu. The view generators of older views are not created again.
+	releasedView *view.View
 
-	// registerLockOnce is used lock the register mutex only once when installing a sequence with more than one view (the register is locked when installing a view).
+	// registerLockOnce is used lock the storage only once when installing a sequence with more than one view (the storage is locked when installing a view).
 	registerLockOnce sync.Once
 
+	// readsDuringReconfiguration makes the server answer reads while the state of a reconfiguration is transferred, see Config.ReadsDuringReconfiguration
+	readsDuringReconfiguration bool
+	// transfer is the reconfiguration whose state is being transferred, nil if there is none. It is protected by transferMu and not by currentViewMu, which the reconfiguration holds.
+	transfer   *stateTransfer
+	transferMu sync.RWMutex
+
+	// snapshots are the snapshots this server serves to the joining servers, see prewarm
+	snapshots *snapshots
+
+	// syncedView is the last view this server installed with the state of a quorum, and syncedVersion the version of
+	// its storage right after, see statedelta.go. Both are protected by syncedMu.
+	syncedView    *view.View
+	syncedVersion uint64
+	syncedMu      sync.Mutex
+
+	// registersLocked tells if the storage is locked by lockRegisters; registersClosed if it may not be locked anymore
+	registersLocked   bool
+	registersClosed   bool
+	registersLockedMu sync.Mutex
+
+	// done is closed when the server starts closing, to stop its loops. closed is closed when it finished closing.
+	done      chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// tracked counts the requests and goroutines Close waits for. closing is protected by trackMu.
+	tracked sync.WaitGroup
+	closing bool
+	trackMu sync.Mutex
+
 	// the channels below is how the "loop" goroutines comunicate with each other.
 
 	generatedViewSeqChan          chan generatedViewSeq
@@ -56,56 +106,282 @@ type Server struct {
 	newViewInstalledChan          chan ViewInstalledMsg
 	resetReconfigurationTimerChan chan bool
 
+	// installSeqReleaseChan and stateUpdateReleaseChan tell the loops the last installed view, so they release the state of older views
+	installSeqReleaseChan  chan *view.View
+	stateUpdateReleaseChan chan *view.View
+
+	// loopInstances counts the per-view state kept by the loops, see ProtocolInstances
+	loopInstances   ProtocolInstances
+	loopInstancesMu sync.Mutex
+
 	// the times below is used to measure the duration of a reconfiguration
 
 	startReconfigurationTime time.Time
 	registerLockTime         time.Time
+	// registersLockedFor is how long the last reconfiguration of a view this server was a member of disabled the R/W operations. It is protected by currentViewMu.
+	registersLockedFor time.Duration
+}
+
+// Config holds the parameters used to create a Server.
+type Config struct {
+	// BindAddr is the address the server will listen to.
+	BindAddr string
+	// Listener, if not nil, is the listener the server accepts its requests from, instead of one listening to BindAddr. The server closes it when it is closed, or if New fails.
+	Listener net.Listener
+	// AdvertiseAddr is the address the other processes use to reach the server, which identifies it in the views. If empty, it is the address of the listener, which does not work across machines if BindAddr has an unspecified host such as [::].
+	AdvertiseAddr string
+	// NodeID names the node of the server in the views independently of its address. If empty, the node is named by the advertised address.
+	NodeID string
+	// Rejoin makes a server without saved state join the view as a new incarnation of its node, even if InitialView has it as a member. It must be set when the server lost the state of a previous execution; a server that finds its state in DataDir resumes its incarnation.
+	Rejoin bool
+	// InitialView is the view used when the server has no view saved in its storage. If nil, the server waits to be initialized with the genesis view of a new cluster by AdminService.Init, see freestore_admin init.
+	InitialView *view.View
+	// UseConsensus makes the server use consensus when a reconfiguration is required.
+	UseConsensus bool
+	// DataDir is the directory where the server keeps its registers, views and consensus state. If empty, the state is kept in memory and lost on restart.
+	DataDir string
+	// MaxConcurrentRequests is the number of register requests the server handles at a time. Further requests are answered with a comm.OverloadedError. Zero means no limit.
+	MaxConcurrentRequests int
+	// Transport carries the requests of the server. If nil, comm.DefaultTransport is used.
+	Transport comm.Transport
+	// ReconfigurationPeriod is the time between the reconfigurations of the system. If zero, the first reconfiguration starts 10 seconds after the server, and the others every minute.
+	ReconfigurationPeriod time.Duration
+	// RemoveSuspectedAfter enables the removal of crashed servers: a member of the current view that this server suspects for this long, and that a quorum of the view suspects too, is removed with a view.Leave update sent on its behalf. Zero disables the removal.
+	RemoveSuspectedAfter time.Duration
+	// MinViewSize is the least number of members a removal of a suspected server may leave in the view. Removals that would leave fewer are refused.
+	MinViewSize int
+	// ReadsDuringReconfiguration makes the server keep answering reads while the state is transferred to a new view, when R/W operations are otherwise disabled. Writes still wait for the new view. The clients then also read from a quorum of the new view, see RegisterService.Read.
+	ReadsDuringReconfiguration bool
 }
 
-// New creates a new server that will listen to bindAddr, use the initialView and use or not consensus when a reconfiguration is required.
-func New(bindAddr string, initialView *view.View, useConsensusArg bool) (*Server, error) {
-	listener, err := net.Listen("tcp", bindAddr)
+// New creates a new server as described by config. If config.DataDir holds
+// the state of a previous execution, the server recovers its registers, its
+// current view and the updates for the next reconfiguration from it.
+//
+// Each server has its own state, so many of them may run in the same process.
+func New(config Config) (*Server, error) {
+	transport := config.Transport
+	if transport == nil {
+		transport = comm.DefaultTransport
+	}
+	started := false
+	if config.Listener != nil {
+		defer func() {
+			if !started {
+				config.Listener.Close()
+			}
+		}()
+	}
+
+	var storage Storage
+	var consensusInstances *consensus.Consensus
+	if config.DataDir == "" {
+		storage = newMemoryStorage()
+
+		var err error
+		consensusInstances, err = consensus.New(transport)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		storage, err = OpenDiskStorage(config.DataDir)
+		if err != nil {
+			return nil, err
+		}
+
+		consensusInstances, err = consensus.Open(filepath.Join(config.DataDir, "consensus"), transport)
+		if err != nil {
+			storage.Close()
+			return nil, err
+		}
+	}
+	closeStorages := func() {
+		storage.Close()
+		consensusInstances.Close()
+	}
+
+	savedView, savedRecv, err := storage.LoadServerState()
 	if err != nil {
+		closeStorages()
+		return nil, err
+	}
+	genesis, err := storage.LoadGenesis()
+	if err != nil {
+		closeStorages()
+		return nil, err
+	}
+	if savedView == nil {
+		// the server may have crashed while it was initialized
+		savedView = genesis
+	}
+
+	currentView := config.InitialView
+	if savedView != nil && (currentView == nil || !savedView.LessUpdatedThan(currentView)) {
+		log.Println("Recovered current view from storage:", savedView)
+		currentView = savedView
+	}
+	if currentView == nil {
+		log.Println("Waiting to be initialized")
+		currentView = view.NewWithProcesses()
+	} else if err := storage.SaveCurrentView(currentView); err != nil {
+		closeStorages()
+		return nil, err
+	}
+
+	listener := config.Listener
+	if listener == nil {
+		listener, err = transport.Listen(config.BindAddr)
+		if err != nil {
+			closeStorages()
+			return nil, err
+		}
+	}
+
+	thisProcess := view.Process{Addr: listener.Addr().String(), ID: config.NodeID}
+	if config.AdvertiseAddr != "" {
+		thisProcess.Addr = config.AdvertiseAddr
+	}
+	thisProcess = identifyProcess(thisProcess, currentView, savedView != nil || !config.Rejoin)
+	if err := checkAdvertisedProcess(thisProcess, currentView, config.AdvertiseAddr != ""); err != nil {
+		listener.Close()
+		closeStorages()
 		return nil, err
 	}
 
 	s := &Server{
-		listener:                      listener,
-		thisProcess:                   view.Process{listener.Addr().String()},
-		currentView:                   initialView,
-		recv:                          make(map[view.Update]bool),
-		generatedViewSeqChan:          make(chan generatedViewSeq),
-		installSeqProcessingChan:      make(chan InstallSeqMsg, CHANNEL_DEFAULT_SIZE),
-		syncStateMsgChan:              make(chan SyncStateMsg, CHANNEL_DEFAULT_SIZE),
-		stateUpdateChanRequestChan:    make(chan stateUpdateChanRequest, CHANNEL_DEFAULT_SIZE),
-		newViewInstalledChan:          make(chan ViewInstalledMsg, CHANNEL_DEFAULT_SIZE),
-		resetReconfigurationTimerChan: make(chan bool, CHANNEL_DEFAULT_SIZE),
-	}
-	go s.generatedViewSeqProcessingLoop()
-	go s.installSeqProcessingLoop()
-	go s.stateUpdateProcessingLoop()
-	go s.resetReconfigurationTimerLoop()
-
-	if globalServer != nil {
-		log.Panicln("Tried to create a second Server")
-	}
-	globalServer = s
+		listener:                          newTrackingListener(listener),
+		thisProcess:                       thisProcess,
+		useConsensus:                      config.UseConsensus,
+		transport:                         transport,
+		rpcServer:                         rpc.NewServer(),
+		consensus:                         consensusInstances,
+		reconfigurationPeriod:             reconfigurationPeriod,
+		firstReconfigurationTimerDuration: firstReconfigurationTimerDuration,
+		removeSuspectedAfter:              config.RemoveSuspectedAfter,
+		minViewSize:                       config.MinViewSize,
+		readsDuringReconfiguration:        config.ReadsDuringReconfiguration,
+		storage:                           storage,
+		currentView:                       currentView,
+		genesis:                           genesis,
+		recv:                              savedRecv,
+		snapshots:                         newSnapshots(),
+		generatedViewSeqChan:              make(chan generatedViewSeq),
+		installSeqProcessingChan:          make(chan InstallSeqMsg, CHANNEL_DEFAULT_SIZE),
+		syncStateMsgChan:                  make(chan SyncStateMsg, CHANNEL_DEFAULT_SIZE),
+		stateUpdateChanRequestChan:        make(chan stateUpdateChanRequest, CHANNEL_DEFAULT_SIZE),
+		newViewInstalledChan:              make(chan ViewInstalledMsg, CHANNEL_DEFAULT_SIZE),
+		resetReconfigurationTimerChan:     make(chan bool, CHANNEL_DEFAULT_SIZE),
+		installSeqReleaseChan:             make(chan *view.View, 1),
+		stateUpdateReleaseChan:            make(chan *view.View, 1),
+		done:                              make(chan struct{}),
+		closed:                            make(chan struct{}),
+	}
+	if config.MaxConcurrentRequests > 0 {
+		s.registerSlots = make(chan struct{}, config.MaxConcurrentRequests)
+	}
+	if config.ReconfigurationPeriod > 0 {
+		s.reconfigurationPeriod = config.ReconfigurationPeriod
+		s.firstReconfigurationTimerDuration = config.ReconfigurationPeriod
+	}
+
+	if err := s.registerServices(); err != nil {
+		listener.Close()
+		closeStorages()
+		return nil, err
+	}
+
+	s.spawn(s.generatedViewSeqProcessingLoop)
+	s.spawn(s.installSeqProcessingLoop)
+	s.spawn(s.stateUpdateProcessingLoop)
+	s.spawn(s.resetReconfigurationTimerLoop)
+	if s.removeSuspectedAfter > 0 {
+		s.spawn(s.removalLoop)
+	}
 
 	s.currentViewMu.Lock()
 	defer s.currentViewMu.Unlock()
 
-	// register starts locked if it is not in the current view
-	if !s.currentView.HasMember(s.thisProcess) {
-		s.register.mu.Lock()
+	// storage starts locked if it is not in the current view
+	if !s.initializedLocked() {
+		s.lockRegisters()
+	} else if !s.currentView.HasMember(s.thisProcess) {
+		s.lockRegisters()
 		// ask to join the view
 		s.joinLocked()
 	}
 
+	started = true
 	return s, nil
 }
 
+// identifyProcess returns the incarnation of the node of process that the
+// server is. If resume is set and the node has a member in currentView, it is
+// that member; otherwise it is a new incarnation, which must join the view.
+func identifyProcess(process view.Process, currentView *view.View, resume bool) view.Process {
+	if member, ok := currentView.MemberOfNode(process); ok && resume {
+		return member
+	}
+	return currentView.NextIncarnation(process)
+}
+
+// checkAdvertisedProcess returns an error if thisProcess cannot identify the
+// server in currentView: its address cannot be reached by the other members,
+// or, if resolve is set, it names a member with a different address.
+func checkAdvertisedProcess(thisProcess view.Process, currentView *view.View, resolve bool) error {
+	for _, member := range currentView.GetMembers() {
+		if member.SameNode(thisProcess) {
+			continue
+		}
+
+		if thisProcess.HasUnspecifiedHost() && !member.HasUnspecifiedHost() {
+			return fmt.Errorf("address %v cannot be reached by view member %v, set an advertised address", thisProcess, member)
+		}
+		if resolve && thisProcess.SameAs(member) {
+			return fmt.Errorf("advertised address %v does not match view member %v, advertise the address used in the view", thisProcess, member)
+		}
+	}
+	return nil
+}
+
+// acquireRegisterSlot reserves a slot for a register request. It returns false if the server is handling too many requests.
+func (s *Server) acquireRegisterSlot() bool {
+	if s.registerSlots == nil {
+		return true
+	}
+	select {
+	case s.registerSlots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) releaseRegisterSlot() {
+	if s.registerSlots != nil {
+		<-s.registerSlots
+	}
+}
+
+// Run serves the requests to the server. It returns when the server is closed.
 func (s *Server) Run() {
-	// Accept connections forever
 	log.Println("Listening on address:", s.listener.Addr())
-	rpc.Accept(s.listener)
+	s.rpcServer.Accept(s.listener)
+	<-s.closed
+}
+
+// registerServices registers the RPC services of s on s.rpcServer.
+func (s *Server) registerServices() error {
+	services := []interface{}{&RegisterService{s}, &ReconfigurationRequest{s}, &ViewGeneratorRequest{s}, &AdminService{s}, new(comm.HeartbeatService)}
+	for _, service := range services {
+		if err := s.rpcServer.Register(service); err != nil {
+			return err
+		}
+	}
+	return s.consensus.RegisterService(s.rpcServer)
+}
+
+// Process returns the process of this server. Its address is the advertised one, see Config.AdvertiseAddr, which may differ from the address the server listens to.
+func (s *Server) Process() view.Process {
+	return s.thisProcess
 }
@@ -0,0 +1,353 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/client"
+	"github.com/mateusbraga/freestore/pkg/comm"
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// startTestCluster starts n servers in this process that talk through transport.
+func startTestCluster(t *testing.T, transport comm.Transport, n int, useConsensus bool) []*Server {
+	var processes []view.Process
+	for i := 1; i <= n; i++ {
+		processes = append(processes, view.Process{Addr: fmt.Sprintf("memory:%v", i)})
+	}
+	initialView := view.NewWithProcesses(processes...)
+
+	var servers []*Server
+	for _, process := range processes {
+		servers = append(servers, startTestServer(t, transport, process, initialView, useConsensus))
+	}
+	return servers
+}
+
+// startTestServer starts a server at process with a short reconfiguration period.
+func startTestServer(t *testing.T, transport comm.Transport, process view.Process, initialView *view.View, useConsensus bool) *Server {
+	return startTestServerWithConfig(t, transport, process, Config{InitialView: initialView, UseConsensus: useConsensus})
+}
+
+// waitForServers waits until cond holds for all servers. If it does not
+// within 10 seconds, the test fails with failure, followed by the server
+// and its view.
+func waitForServers(t *testing.T, servers []*Server, cond func(s *Server) bool, failure string) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for _, s := range servers {
+		for !cond(s) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%v: server %v, its view is %v", failure, s.Process(), s.getCurrentView())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// startTestServerWithConfig starts a server at process with config and a short reconfiguration period.
+func startTestServerWithConfig(t *testing.T, transport comm.Transport, process view.Process, config Config) *Server {
+	config.BindAddr = process.Addr
+	config.Transport = transport
+	config.ReconfigurationPeriod = 100 * time.Millisecond
+
+	s, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Run()
+	return s
+}
+
+func (s *Server) getCurrentView() *view.View {
+	s.currentViewMu.RLock()
+	defer s.currentViewMu.RUnlock()
+	return s.currentView
+}
+
+func TestServerWithMemoryTransport(t *testing.T) {
+	transport := comm.NewMemoryTransport()
+	servers := startTestCluster(t, transport, 1, false)
+
+	getView := func() (*view.View, error) { return client.GetCurrentViewWithTransport(transport, servers[0].Process()) }
+	cl, err := client.NewWithTransport(transport, getView, getView)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cl.WriteKey("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := cl.ReadKey("key"); err != nil || v != "value" {
+		t.Errorf("expected value and no error, got %v and %v", v, err)
+	}
+}
+
+// TestClusterInOneProcess runs 5 servers in this process and adds a 6th one with a reconfiguration.
+func TestClusterInOneProcess(t *testing.T) {
+	for _, useConsensus := range []bool{false, true} {
+		transport := comm.NewMemoryTransport()
+		servers := startTestCluster(t, transport, 5, useConsensus)
+
+		getView := func() (*view.View, error) { return client.GetCurrentViewWithTransport(transport, servers[0].Process()) }
+		cl, err := client.NewWithTransport(transport, getView, getView)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cl.Write(1); err != nil {
+			t.Fatal(err)
+		}
+
+		joiner := startTestServer(t, transport, view.Process{Addr: "memory:6"}, servers[0].getCurrentView(), useConsensus)
+
+		waitForServers(t, append(servers, joiner), func(s *Server) bool { return s.getCurrentView().HasMember(joiner.Process()) }, fmt.Sprintf("consensus %v: the join was not installed", useConsensus))
+
+		// the joiner got the state of the others
+		if v, err := joiner.storage.Read(""); err != nil || v.Value != 1 {
+			t.Errorf("consensus %v: expected joiner to have value 1, got %v and %v", useConsensus, v, err)
+		}
+
+		if v, err := cl.Read(); err != nil || v != 1 {
+			t.Errorf("consensus %v: expected 1 and no error after the join, got %v and %v", useConsensus, v, err)
+		}
+		if err := cl.Write(2); err != nil {
+			t.Errorf("consensus %v: write after the join failed: %v", useConsensus, err)
+		}
+
+		for _, s := range append(servers, joiner) {
+			s.Close()
+		}
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	transport := comm.NewMemoryTransport()
+	servers := startTestCluster(t, transport, 1, false)
+	s := servers[0]
+
+	runReturned := make(chan struct{})
+	go func() {
+		s.Run()
+		close(runReturned)
+	}()
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-runReturned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after Close")
+	}
+	// Close can be called again
+	if err := s.Close(); err != nil {
+		t.Error(err)
+	}
+
+	var v *view.View
+	err := transport.Call(context.Background(), s.Process(), "RegisterService.GetCurrentView", 0, &v)
+	if err == nil {
+		t.Error("expected request to a closed server to fail")
+	}
+}
+
+// TestTrackingListenerForgetsClosedConns checks that the listener of a server
+// only keeps the connections that are still open.
+func TestTrackingListenerForgetsClosedConns(t *testing.T) {
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := newTrackingListener(tcpListener)
+	defer listener.Close()
+
+	accept := func() net.Conn {
+		clientConn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer clientConn.Close()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	numberOfConns := func() int {
+		listener.connsMu.Lock()
+		defer listener.connsMu.Unlock()
+		return len(listener.conns)
+	}
+
+	for i := 0; i < 10; i++ {
+		accept().Close()
+	}
+	if n := numberOfConns(); n != 0 {
+		t.Errorf("expected no connections to be kept after they were closed, got %v", n)
+	}
+
+	open := accept()
+	if n := numberOfConns(); n != 1 {
+		t.Errorf("expected the open connection to be kept, got %v connections", n)
+	}
+	listener.closeConns()
+	if _, err := open.Read(make([]byte, 1)); err == nil {
+		t.Error("expected the open connection to be closed with the listener")
+	}
+}
+
+// TestServerLeave removes a server with Leave and checks that the others and the client keep working.
+func TestServerLeave(t *testing.T) {
+	for _, useConsensus := range []bool{false, true} {
+		transport := comm.NewMemoryTransport()
+		servers := startTestCluster(t, transport, 4, useConsensus)
+		leaver, others := servers[3], servers[:3]
+
+		getView := func() (*view.View, error) { return client.GetCurrentViewWithTransport(transport, others[0].Process()) }
+		cl, err := client.NewWithTransport(transport, getView, getView)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cl.Write(1); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = leaver.Leave(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("consensus %v: Leave failed: %v", useConsensus, err)
+		}
+		select {
+		case <-leaver.Done():
+		default:
+			t.Errorf("consensus %v: server not closed after Leave", useConsensus)
+		}
+
+		waitForServers(t, others, func(s *Server) bool { return !s.getCurrentView().HasMember(leaver.Process()) }, fmt.Sprintf("consensus %v: the leave was not installed", useConsensus))
+
+		if v, err := cl.Read(); err != nil || v != 1 {
+			t.Errorf("consensus %v: expected 1 and no error after the leave, got %v and %v", useConsensus, v, err)
+		}
+		if err := cl.Write(2); err != nil {
+			t.Errorf("consensus %v: write after the leave failed: %v", useConsensus, err)
+		}
+
+		for _, s := range others {
+			s.Close()
+		}
+	}
+}
+
+func TestServerAdvertiseAddr(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	advertised := view.Process{Addr: net.JoinHostPort("localhost", port)}
+	bound := view.Process{Addr: net.JoinHostPort("127.0.0.1", port)}
+
+	// the view names the server by the IP address, not by the advertised hostname
+	_, err = New(Config{BindAddr: bound.Addr, AdvertiseAddr: advertised.Addr, InitialView: view.NewWithProcesses(bound, view.Process{Addr: "127.0.0.1:1"})})
+	if err == nil {
+		t.Fatal("expected an error for an advertised address that does not match the view member")
+	}
+
+	// a server bound to an unspecified address cannot be reached by the other members
+	_, err = New(Config{BindAddr: net.JoinHostPort("::", port), InitialView: view.NewWithProcesses(bound, view.Process{Addr: "127.0.0.1:1"})})
+	if err == nil {
+		t.Fatal("expected an error for an unspecified address in a view of other hosts")
+	}
+
+	s, err := New(Config{BindAddr: bound.Addr, AdvertiseAddr: advertised.Addr, InitialView: view.NewWithProcesses(advertised)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Run()
+	defer s.Close()
+
+	if s.Process() != advertised {
+		t.Errorf("expected process %v, got %v", advertised, s.Process())
+	}
+	if v, err := client.GetCurrentView(advertised); err != nil || !v.HasMember(advertised) {
+		t.Errorf("expected a view with %v and no error, got %v and %v", advertised, v, err)
+	}
+}
+
+// TestServerRejoin brings back a server on the same address after it left, and another one after it lost its state.
+func TestServerRejoin(t *testing.T) {
+	transport := comm.NewMemoryTransport()
+	servers := startTestCluster(t, transport, 4, false)
+	defer func() {
+		for _, s := range servers {
+			s.Close()
+		}
+	}()
+
+	getView := func() (*view.View, error) { return client.GetCurrentViewWithTransport(transport, servers[0].Process()) }
+	cl, err := client.NewWithTransport(transport, getView, getView)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Write(1); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForMember := func(member view.Process, gone view.Process) {
+		waitForServers(t, servers, func(s *Server) bool {
+			v := s.getCurrentView()
+			return v.HasMember(member) && !v.HasMember(gone)
+		}, fmt.Sprintf("%v was not installed in place of %v", member, gone))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	err = servers[3].Leave(ctx)
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	left := servers[3].Process()
+	servers[3] = startTestServer(t, transport, left, servers[0].getCurrentView(), false)
+	if p := servers[3].Process(); p.Addr != left.Addr || p.Incarnation != left.Incarnation+1 {
+		t.Fatalf("expected a new incarnation of %v, got %v", left, p)
+	}
+	waitForMember(servers[3].Process(), left)
+
+	// a server that lost its state rejoins without leaving first
+	crashed := servers[2].Process()
+	servers[2].Close()
+	s, err := New(Config{
+		BindAddr:              crashed.Addr,
+		InitialView:           servers[0].getCurrentView(),
+		Transport:             transport,
+		ReconfigurationPeriod: 100 * time.Millisecond,
+		Rejoin:                true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Run()
+	servers[2] = s
+	if p := s.Process(); p.Addr != crashed.Addr || p.Incarnation != crashed.Incarnation+1 {
+		t.Fatalf("expected a new incarnation of %v, got %v", crashed, p)
+	}
+	waitForMember(s.Process(), crashed)
+
+	if n := servers[0].getCurrentView().NumberOfMembers(); n != 4 {
+		t.Errorf("expected 4 members, got %v", n)
+	}
+	if v, err := s.storage.Read(""); err != nil || v.Value != 1 {
+		t.Errorf("expected the new incarnation to get value 1, got %v and %v", v, err)
+	}
+	if v, err := cl.Read(); err != nil || v != 1 {
+		t.Errorf("expected 1 and no error after the rejoins, got %v and %v", v, err)
+	}
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+)
+
+// ErrServerClosed is returned by the requests that arrive while the server is closing.
+var ErrServerClosed = errors.New("server closed")
+
+// Close stops the server: it stops accepting requests, closes the
+// connections of its clients, waits for the requests in progress and the
+// processing loops to finish, and closes its storage. Run returns when the
+// server is closed. Close does not ask the other servers to remove this one
+// from the view, see Leave.
+func (s *Server) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.trackMu.Lock()
+		s.closing = true
+		s.trackMu.Unlock()
+
+		close(s.done)
+		s.listener.Close()
+		s.listener.closeConns()
+
+		// requests waiting for a reconfiguration that will never finish here can go now, their answers are lost with the connections
+		s.unlockRegistersForClose()
+
+		s.tracked.Wait()
+
+		if consensusErr := s.consensus.Close(); consensusErr != nil {
+			err = consensusErr
+		}
+		if storageErr := s.storage.Close(); storageErr != nil {
+			err = storageErr
+		}
+
+		log.Println("Server closed:", s.thisProcess)
+		close(s.closed)
+	})
+	<-s.closed
+	return err
+}
+
+// Leave asks the members of the current view to remove this server and
+// waits until the view without it is installed. The server closes itself
+// once it left. If ctx is done first, Leave returns ctx.Err() and the server
+// keeps running; the removal may still complete later.
+func (s *Server) Leave(ctx context.Context) error {
+	s.leave()
+
+	select {
+	case <-s.closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done returns a channel that is closed when the server is closed, either by Close or because it left the view.
+func (s *Server) Done() <-chan struct{} {
+	return s.closed
+}
+
+// track registers a request or goroutine that Close must wait for. It returns false if the server is closing; untrack must be called otherwise.
+func (s *Server) track() bool {
+	s.trackMu.Lock()
+	defer s.trackMu.Unlock()
+
+	if s.closing {
+		return false
+	}
+	s.tracked.Add(1)
+	return true
+}
+
+func (s *Server) untrack() {
+	s.tracked.Done()
+}
+
+// spawn runs f in a new goroutine that Close waits for. f must return soon after s.done is closed.
+func (s *Server) spawn(f func()) {
+	if !s.track() {
+		return
+	}
+	go func() {
+		defer s.untrack()
+		f()
+	}()
+}
+
+// lockRegisters disables the R/W operations on the registers. It does nothing once the server is closing.
+func (s *Server) lockRegisters() {
+	s.registersLockedMu.Lock()
+	defer s.registersLockedMu.Unlock()
+
+	if s.registersLocked || s.registersClosed {
+		return
+	}
+	s.storage.LockAll()
+	s.registersLocked = true
+}
+
+// unlockRegisters enables the R/W operations on the registers, if they are disabled.
+func (s *Server) unlockRegisters() {
+	s.registersLockedMu.Lock()
+	defer s.registersLockedMu.Unlock()
+
+	if s.registersLocked {
+		s.registersLocked = false
+		s.storage.UnlockAll()
+	}
+}
+
+// unlockRegistersForClose enables the R/W operations on the registers for good.
+func (s *Server) unlockRegistersForClose() {
+	s.registersLockedMu.Lock()
+	s.registersClosed = true
+	s.registersLockedMu.Unlock()
+
+	s.unlockRegisters()
+}
+
+// trackingListener is a net.Listener that remembers the connections it accepted, so they can be closed with the server.
+type trackingListener struct {
+	net.Listener
+
+	connsMu sync.Mutex
+	conns   map[net.Conn]bool
+	closed  bool
+}
+
+func newTrackingListener(listener net.Listener) *trackingListener {
+	return &trackingListener{Listener: listener, conns: make(map[net.Conn]bool)}
+}
+
+func (l *trackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	l.connsMu.Lock()
+	defer l.connsMu.Unlock()
+	if l.closed {
+		conn.Close()
+		return nil, ErrServerClosed
+	}
+	trackedConn := &trackedConn{Conn: conn, listener: l}
+	l.conns[trackedConn] = true
+	return trackedConn, nil
+}
+
+// trackedConn is a connection accepted by a trackingListener, which forgets it once it is closed.
+type trackedConn struct {
+	net.Conn
+	listener *trackingListener
+}
+
+func (c *trackedConn) Close() error {
+	c.listener.connsMu.Lock()
+	delete(c.listener.conns, c)
+	c.listener.connsMu.Unlock()
+
+	return c.Conn.Close()
+}
+
+func (l *trackingListener) closeConns() {
+	l.connsMu.Lock()
+	defer l.connsMu.Unlock()
+
+	l.closed = true
+	for conn := range l.conns {
+		// not conn.Close, which takes connsMu
+		conn.(*trackedConn).Conn.Close()
+	}
+	l.conns = make(map[net.Conn]bool)
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/client"
+	"github.com/mateusbraga/freestore/pkg/comm"
+	"github.com/mateusbraga/freestore/pkg/simnet"
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// simSeeds are the seeds of the simulated network tests. A seed fixes the faults, delays and delivery order the network chooses for the messages it is given, but the servers use real timers, so a failed test may send other messages when run again with its seed.
+var simSeeds = []int64{1, 2, 3}
+
+// startSimCluster starts n servers in this process that talk through network.
+func startSimCluster(t *testing.T, network *simnet.Network, n int, useConsensus bool) []*Server {
+	return startSimClusterWithConfig(t, network, n, Config{UseConsensus: useConsensus})
+}
+
+// startSimClusterWithConfig starts n servers with config in this process that talk through network.
+func startSimClusterWithConfig(t *testing.T, network *simnet.Network, n int, config Config) []*Server {
+	var processes []view.Process
+	for i := 1; i <= n; i++ {
+		processes = append(processes, view.Process{Addr: fmt.Sprintf("memory:%v", i)})
+	}
+	initialView := view.NewWithProcesses(processes...)
+
+	var servers []*Server
+	for _, process := range processes {
+		config.InitialView = initialView
+		servers = append(servers, startTestServerWithConfig(t, network.Transport(process), process, config))
+	}
+	return servers
+}
+
+func newSimClient(t *testing.T, network *simnet.Network, s *Server) *client.Client {
+	transport := network.Transport(view.Process{Addr: "client"})
+	getView := func() (*view.View, error) { return client.GetCurrentViewWithTransport(transport, s.Process()) }
+	cl, err := client.NewWithTransport(transport, getView, getView)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cl
+}
+
+// waitForViews waits until cond holds for the current view of all servers.
+func waitForViews(t *testing.T, network *simnet.Network, servers []*Server, cond func(*view.View) bool) {
+	t.Helper()
+	waitForServers(t, servers, func(s *Server) bool { return cond(s.getCurrentView()) }, fmt.Sprintf("seed %v: the expected view was not installed", network.Seed()))
+}
+
+func closeServers(servers []*Server) {
+	for _, s := range servers {
+		s.Close()
+	}
+}
+
+// TestQuorumsWithSimnet checks that reads and writes need a quorum only, with the requests delayed, reordered and duplicated.
+func TestQuorumsWithSimnet(t *testing.T) {
+	for _, seed := range simSeeds {
+		network := simnet.New(seed)
+		servers := startSimCluster(t, network, 5, false)
+		cl := newSimClient(t, network, servers[0])
+
+		network.AddRule(simnet.Rule{Method: "RegisterService", Duplicate: 0.2, MaxDelay: 5 * time.Millisecond})
+		network.Isolate(servers[3].Process(), servers[4].Process())
+
+		for i := 0; i < 20; i++ {
+			if err := cl.Write(i); err != nil {
+				t.Fatalf("seed %v: write %v failed with a minority isolated: %v", seed, i, err)
+			}
+			if v, err := cl.Read(); err != nil || v != i {
+				t.Fatalf("seed %v: expected %v and no error, got %v and %v", seed, i, v, err)
+			}
+		}
+
+		cl.SetRecoveryPolicy(client.FailFastPolicy)
+		network.Isolate(servers[2].Process(), servers[3].Process(), servers[4].Process())
+		err := cl.Write("lost")
+		var noQuorumErr comm.NoQuorumError
+		if !errors.As(err, &noQuorumErr) || len(noQuorumErr.Failed) != 3 {
+			t.Errorf("seed %v: expected a NoQuorumError with 3 failed processes, got %v", seed, err)
+		}
+
+		closeServers(servers)
+	}
+}
+
+// TestViewGeneratorConvergence joins two servers at the same time, with the
+// reconfiguration messages delayed and reordered, and checks that all
+// servers converge to the same view.
+func TestViewGeneratorConvergence(t *testing.T) {
+	for _, seed := range simSeeds {
+		for _, useConsensus := range []bool{false, true} {
+			network := simnet.New(seed)
+			servers := startSimCluster(t, network, 4, useConsensus)
+
+			network.AddRule(simnet.Rule{Method: "ViewGeneratorRequest", MaxDelay: 20 * time.Millisecond})
+			network.AddRule(simnet.Rule{Method: "ReconfigurationRequest", MaxDelay: 20 * time.Millisecond})
+
+			initialView := servers[0].getCurrentView()
+			for _, process := range []view.Process{{Addr: "memory:5"}, {Addr: "memory:6"}} {
+				servers = append(servers, startTestServer(t, network.Transport(process), process, initialView, useConsensus))
+			}
+
+			waitForViews(t, network, servers, func(v *view.View) bool { return v.NumberOfMembers() == 6 })
+			for _, s := range servers[1:] {
+				if !s.getCurrentView().Equal(servers[0].getCurrentView()) {
+					t.Errorf("seed %v, consensus %v: servers %v and %v installed different views: %v and %v", seed, useConsensus, servers[0].Process(), s.Process(), servers[0].getCurrentView(), s.getCurrentView())
+				}
+			}
+
+			closeServers(servers)
+		}
+	}
+}
+
+// TestInstallSeqQuorum checks that a new view is installed once a quorum of
+// the old view sent its state, with one member missing all the install-seq
+// messages and the others getting them duplicated.
+func TestInstallSeqQuorum(t *testing.T) {
+	for _, seed := range simSeeds {
+		network := simnet.New(seed)
+		servers := startSimCluster(t, network, 4, false)
+		cl := newSimClient(t, network, servers[0])
+		if err := cl.Write(1); err != nil {
+			t.Fatal(err)
+		}
+
+		lagging := servers[3]
+		network.AddRule(simnet.Rule{Method: "ReconfigurationRequest.InstallSeq", Duplicate: 0.5, MaxDelay: 10 * time.Millisecond})
+		network.AddRule(simnet.Rule{To: lagging.Process(), Method: "ReconfigurationRequest.InstallSeq", Drop: 1})
+
+		joinerProcess := view.Process{Addr: "memory:5"}
+		joiner := startTestServer(t, network.Transport(joinerProcess), joinerProcess, servers[0].getCurrentView(), false)
+
+		waitForViews(t, network, []*Server{servers[0], servers[1], servers[2], joiner}, func(v *view.View) bool { return v.HasMember(joinerProcess) })
+		if lagging.getCurrentView().HasMember(joinerProcess) {
+			t.Errorf("seed %v: server %v installed the view without getting any install-seq", seed, lagging.Process())
+		}
+		if v, err := joiner.storage.Read(""); err != nil || v.Value != 1 {
+			t.Errorf("seed %v: expected joiner to have value 1, got %v and %v", seed, v, err)
+		}
+
+		if err := cl.Write(2); err != nil {
+			t.Errorf("seed %v: write after the join failed: %v", seed, err)
+		}
+
+		closeServers(append(servers, joiner))
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// pullStateTimeout is how long a server waits for all the registers of a member that sent it only the recent ones.
+const pullStateTimeout = 10 * time.Second
+
+// The members of a view already keep the registers written in it, so they do
+// not send each other all of their registers while the R/W operations are
+// disabled. A server that installed a view with the state of a quorum records
+// the version of its storage right after; when it sends its state for that
+// view, the other members of the view get only the registers it changed since
+// that version (SyncStateMsg.Since). Every write completed in the view reached
+// a quorum of its members after they installed it, so a quorum of those
+// updates holds it, and the writes completed before are already kept by a
+// receiver that installed the view too. A receiver that did not install it
+// pulls all the registers of the sender with State instead. The joining
+// servers get all the registers, or those changed since their snapshot, see
+// prewarm.
+
+// stateUpdatesLocked returns the state update this server sends to each member
+// of installSeq.InstallView, after it disabled the R/W operations.
+func (s *Server) stateUpdatesLocked(installSeq InstallSeq) map[view.Process]SyncStateMsg {
+	syncStateMsg := SyncStateMsg{AssociatedView: installSeq.AssociatedView, Sender: s.thisProcess}
+	s.recvMutex.RLock()
+	syncStateMsg.Recv = make(map[view.Update]bool, len(s.recv))
+	for update := range s.recv {
+		syncStateMsg.Recv[update] = true
+	}
+	s.recvMutex.RUnlock()
+
+	stateUpdates := s.prewarmedStateUpdates(installSeq.InstallView, syncStateMsg)
+
+	version, synced := s.syncedVersionOf(installSeq.AssociatedView)
+	var recentState, state map[string]RegisterValue
+	if synced {
+		recentState = s.storage.StateSince(version)
+	}
+	for _, process := range installSeq.InstallView.GetMembers() {
+		if _, ok := stateUpdates[process]; ok {
+			continue
+		}
+
+		stateUpdate := syncStateMsg
+		if synced && installSeq.AssociatedView.HasMember(process) {
+			stateUpdate.State = recentState
+			stateUpdate.Since = installSeq.AssociatedView
+		} else {
+			if state == nil {
+				state = s.storage.State()
+			}
+			stateUpdate.State = state
+		}
+		stateUpdates[process] = stateUpdate
+	}
+	return stateUpdates
+}
+
+// setSyncedView records that the server installed installedView with the state of a quorum.
+func (s *Server) setSyncedView(installedView *view.View) {
+	s.syncedMu.Lock()
+	defer s.syncedMu.Unlock()
+
+	s.syncedView = installedView
+	s.syncedVersion = s.storage.Version()
+}
+
+// syncedVersionOf returns the version of the storage when the server installed
+// associatedView with the state of a quorum. It returns false if the server did
+// not, or installed another view since.
+func (s *Server) syncedVersionOf(associatedView *view.View) (uint64, bool) {
+	s.syncedMu.Lock()
+	defer s.syncedMu.Unlock()
+
+	if s.syncedView == nil || !s.syncedView.Equal(associatedView) {
+		return 0, false
+	}
+	return s.syncedVersion, true
+}
+
+// syncedTo tells if the server installed installedView with the state of a
+// quorum, so it keeps the registers changed in the views before.
+func (s *Server) syncedTo(installedView *view.View) bool {
+	_, ok := s.syncedVersionOf(installedView)
+	return ok
+}
+
+// pullStateUpdate replaces the registers of stateUpdate with all the
+// registers of its sender and hands it to the state update loop.
+func (s *Server) pullStateUpdate(stateUpdate SyncStateMsg) {
+	var state map[string]RegisterValue
+	if err := s.callUntilDone(stateUpdate.Sender, "ReconfigurationRequest.State", struct{}{}, &state, pullStateTimeout); err != nil {
+		log.Printf("WARN: failed to pull the state of %v: %v\n", stateUpdate.Sender, err)
+		return
+	}
+	stateUpdate.State = state
+	stateUpdate.Since = nil
+
+	select {
+	case s.syncStateMsgChan <- stateUpdate:
+	case <-s.done:
+	}
+}
+
+// State answers all the registers of the server. The registers only grow
+// more recent, so they hold the state update the server sent before.
+func (r *ReconfigurationRequest) State(arg struct{}, reply *map[string]RegisterValue) error {
+	if !r.server.track() {
+		return ErrServerClosed
+	}
+	defer r.server.untrack()
+
+	*reply = r.server.storage.State()
+	return nil
+}
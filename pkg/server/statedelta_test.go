@@ -0,0 +1,102 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/comm"
+	"github.com/mateusbraga/freestore/pkg/simnet"
+	"github.com/mateusbraga/freestore/pkg/timestamp"
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// TestLockedWindowOfOldMember checks that the members of a view send each
+// other only the registers written since they installed it, so the R/W
+// operations of an old member are not disabled longer with a larger store.
+func TestLockedWindowOfOldMember(t *testing.T) {
+	numberOfRegisters := 20000
+	if testing.Short() {
+		numberOfRegisters = 2000
+	}
+
+	network := simnet.New(1)
+	servers := startSimCluster(t, network, 3, false)
+	defer func() { closeServers(servers) }()
+	for i := 0; i < numberOfRegisters; i++ {
+		value := RegisterValue{Value: i, Timestamp: timestamp.Timestamp{Counter: 1, WriterID: "preloaded"}}
+		for _, s := range servers {
+			s.storage.Write(fmt.Sprint(i), value)
+		}
+	}
+	oldMember := servers[0]
+
+	lockedWindowOfJoin := func(i int) time.Duration {
+		joinerProcess := view.Process{Addr: fmt.Sprintf("memory:%v", i)}
+		joiner := startTestServerWithConfig(t, network.Transport(joinerProcess), joinerProcess, Config{InitialView: oldMember.getCurrentView()})
+		servers = append(servers, joiner)
+		waitForViews(t, network, servers, func(v *view.View) bool { return v.HasMember(joinerProcess) })
+
+		oldMember.currentViewMu.RLock()
+		defer oldMember.currentViewMu.RUnlock()
+		return oldMember.registersLockedFor
+	}
+
+	// the servers started with an initial view do not know what the others keep, they send all of their registers
+	fullWindow := lockedWindowOfJoin(4)
+
+	cl := newSimClient(t, network, oldMember)
+	if err := cl.WriteKey("late", 1); err != nil {
+		t.Fatal(err)
+	}
+	version, ok := oldMember.syncedVersionOf(oldMember.getCurrentView())
+	if !ok {
+		t.Fatalf("server %v did not record the version of the view it installed", oldMember.Process())
+	}
+	if state := oldMember.storage.StateSince(version); len(state) != 1 {
+		t.Errorf("server %v would send %v registers to the other members, expected only the late one", oldMember.Process(), len(state))
+	}
+
+	recentWindow := lockedWindowOfJoin(5)
+	t.Logf("R/W operations of %v disabled for %v with all the registers, %v with the recent ones", oldMember.Process(), fullWindow, recentWindow)
+	if recentWindow > fullWindow/2 {
+		t.Errorf("the R/W operations of %v were disabled for %v, %v when all the %v registers were sent", oldMember.Process(), recentWindow, fullWindow, numberOfRegisters)
+	}
+
+	for _, s := range servers {
+		if v, err := s.storage.Peek("late"); err != nil || v.Value != 1 {
+			t.Errorf("server %v has %v for the late register, expected 1", s.Process(), v.Value)
+		}
+	}
+}
+
+// TestStateUpdatePullsAllRegisters checks that a server that did not install
+// the view of a state update with only the recent registers pulls all the
+// registers of its sender.
+func TestStateUpdatePullsAllRegisters(t *testing.T) {
+	servers := startTestCluster(t, comm.NewMemoryTransport(), 3, false)
+	defer closeServers(servers)
+	sender, receiver := servers[0], servers[1]
+	sender.storage.Write("a", RegisterValue{Value: 1, Timestamp: timestamp.Timestamp{Counter: 1, WriterID: "test"}})
+
+	// the servers started with an initial view, so the receiver did not install it with the state of a quorum
+	associatedView := receiver.getCurrentView()
+	r := &ReconfigurationRequest{server: receiver}
+	if err := r.StateUpdate(SyncStateMsg{AssociatedView: associatedView, Sender: sender.Process(), Since: associatedView}, new(struct{})); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.StateUpdate(SyncStateMsg{AssociatedView: associatedView, Sender: servers[2].Process()}, new(struct{})); err != nil {
+		t.Fatal(err)
+	}
+
+	chanRequest := stateUpdateChanRequest{associatedView: associatedView, returnChan: make(chan chan State, 1)}
+	receiver.stateUpdateChanRequestChan <- chanRequest
+	select {
+	case state := <-<-chanRequest.returnChan:
+		if len(state.registers) != 1 || state.registers["a"].Value != 1 {
+			t.Errorf("expected the register of %v in the state of the quorum, got %v", sender.Process(), state.registers)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("server %v did not get the state of a quorum", receiver.Process())
+	}
+}
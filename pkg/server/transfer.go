@@ -0,0 +1,74 @@
+package server
+
+import (
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// stateTransfer is a reconfiguration from oldView to newView whose state is
+// being transferred. The registers of the server are locked meanwhile, so
+// they keep the state of oldView.
+type stateTransfer struct {
+	oldView *view.View
+	newView *view.View
+}
+
+// beginTransferLocked records that the server is transferring its state to
+// newView, if it answers reads during reconfigurations. The registers must be
+// locked already.
+func (s *Server) beginTransferLocked(newView *view.View) {
+	if !s.readsDuringReconfiguration {
+		return
+	}
+
+	s.transferMu.Lock()
+	defer s.transferMu.Unlock()
+	s.transfer = &stateTransfer{oldView: s.currentView, newView: newView}
+}
+
+// endTransfer records that the server installed a new view, which ends the transfer.
+func (s *Server) endTransfer() {
+	s.transferMu.Lock()
+	defer s.transferMu.Unlock()
+	s.transfer = nil
+}
+
+// readDuringTransfer answers arg without waiting for the reconfiguration in
+// progress, if it can, and tells if it did.
+//
+// No write is done during the transfer, so a read of the old view is
+// answered with the locked registers. A write may complete in the new view
+// before this server installs it, though, so reply.PendingView asks the
+// client to read from a quorum of the new view too. A read of the new view is
+// answered with reply.NotInstalled: no write was done in it here yet.
+func (s *Server) readDuringTransfer(arg Value, reply *Value) (bool, error) {
+	s.transferMu.RLock()
+	defer s.transferMu.RUnlock()
+
+	if s.transfer == nil {
+		return false, nil
+	}
+
+	switch arg.ViewRef {
+	case s.transfer.oldView.ViewRef:
+		if !s.transfer.oldView.HasMember(s.thisProcess) {
+			// a joining server does not have the state of the old view
+			return false, nil
+		}
+
+		registerValue, err := s.storage.Peek(arg.Key)
+		if err != nil {
+			return true, err
+		}
+
+		reply.Key = arg.Key
+		reply.Value = registerValue.Value
+		reply.Timestamp = registerValue.Timestamp
+		reply.PendingView = s.transfer.newView
+		return true, nil
+	case s.transfer.newView.ViewRef:
+		reply.Key = arg.Key
+		reply.NotInstalled = true
+		return true, nil
+	}
+	return false, nil
+}
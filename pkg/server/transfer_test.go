@@ -0,0 +1,113 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/client"
+	"github.com/mateusbraga/freestore/pkg/simnet"
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+func (s *Server) transferring() bool {
+	s.registersLockedMu.Lock()
+	registersLocked := s.registersLocked
+	s.registersLockedMu.Unlock()
+
+	s.transferMu.RLock()
+	defer s.transferMu.RUnlock()
+	return registersLocked && (s.transfer != nil || !s.readsDuringReconfiguration)
+}
+
+// TestReadsDuringReconfiguration delays the state transfer of a join and
+// checks that a read waits for it, unless the servers answer reads during
+// reconfigurations.
+func TestReadsDuringReconfiguration(t *testing.T) {
+	const transferDelay = time.Second
+
+	for _, readsDuringReconfiguration := range []bool{false, true} {
+		network := simnet.New(1)
+		servers := startSimClusterWithConfig(t, network, 4, Config{ReadsDuringReconfiguration: readsDuringReconfiguration})
+		cl := newSimClient(t, network, servers[0])
+		if err := cl.Write(1); err != nil {
+			t.Fatal(err)
+		}
+
+		network.AddRule(simnet.Rule{Method: "ReconfigurationRequest.StateUpdate", MinDelay: transferDelay, MaxDelay: transferDelay})
+		joinerProcess := view.Process{Addr: "memory:5"}
+		joiner := startTestServerWithConfig(t, network.Transport(joinerProcess), joinerProcess, Config{InitialView: servers[0].getCurrentView(), ReadsDuringReconfiguration: readsDuringReconfiguration})
+
+		waitForServers(t, servers, (*Server).transferring, fmt.Sprintf("reads during reconfiguration %v: the state transfer did not start", readsDuringReconfiguration))
+
+		startTime := time.Now()
+		v, err := cl.Read()
+		readDuration := time.Since(startTime)
+		if err != nil || v != 1 {
+			t.Errorf("reads during reconfiguration %v: expected 1 and no error, got %v and %v", readsDuringReconfiguration, v, err)
+		}
+		if readsDuringReconfiguration && readDuration > transferDelay/2 {
+			t.Errorf("the read waited %v for the state transfer", readDuration)
+		}
+		if !readsDuringReconfiguration && readDuration < transferDelay/2 {
+			t.Errorf("the read took %v, it did not wait for the state transfer", readDuration)
+		}
+
+		waitForViews(t, network, append(servers, joiner), func(v *view.View) bool { return v.HasMember(joinerProcess) })
+		if err := cl.Write(2); err != nil {
+			t.Errorf("reads during reconfiguration %v: write after the join failed: %v", readsDuringReconfiguration, err)
+		}
+		if v, err := cl.Read(); err != nil || v != 2 {
+			t.Errorf("reads during reconfiguration %v: expected 2 and no error, got %v and %v", readsDuringReconfiguration, v, err)
+		}
+
+		closeServers(append(servers, joiner))
+	}
+}
+
+// TestReadsDuringReconfigurationAfterWriteInNewView checks that a read
+// answered by a quorum of the old view, whose servers are still transferring
+// their state, returns the value written in the new view by the servers that
+// installed it first.
+func TestReadsDuringReconfigurationAfterWriteInNewView(t *testing.T) {
+	const transferDelay = 2 * time.Second
+
+	network := simnet.New(1)
+	config := Config{ReadsDuringReconfiguration: true}
+	servers := startSimClusterWithConfig(t, network, 3, config)
+	writer := newSimClient(t, network, servers[0])
+	if err := writer.Write(1); err != nil {
+		t.Fatal(err)
+	}
+
+	readerTransport := network.Transport(view.Process{Addr: "reader"})
+	getView := func() (*view.View, error) {
+		return client.GetCurrentViewWithTransport(readerTransport, servers[0].Process())
+	}
+	reader, err := client.NewWithTransport(readerTransport, getView, getView)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the reader only reaches the servers that are slow to install the new view
+	network.AddRule(simnet.Rule{From: view.Process{Addr: "reader"}, To: servers[0].Process(), Drop: 1})
+
+	for _, s := range servers[1:] {
+		network.AddRule(simnet.Rule{To: s.Process(), Method: "ReconfigurationRequest.StateUpdate", MinDelay: transferDelay, MaxDelay: transferDelay})
+	}
+	config.InitialView = servers[0].getCurrentView()
+	var joiners []*Server
+	for _, process := range []view.Process{{Addr: "memory:4"}, {Addr: "memory:5"}} {
+		joiners = append(joiners, startTestServerWithConfig(t, network.Transport(process), process, config))
+	}
+	defer closeServers(append(servers, joiners...))
+
+	fastServers := append([]*Server{servers[0]}, joiners...)
+	waitForViews(t, network, fastServers, func(v *view.View) bool { return v.NumberOfMembers() == 5 })
+	if err := writer.Write(2); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := reader.Read(); err != nil || v != 2 {
+		t.Errorf("expected 2 and no error, got %v and %v", v, err)
+	}
+}
@@ -2,10 +2,9 @@ package server
 
 import (
 	"log"
-	"net/rpc"
+	"time"
 
 	"github.com/mateusbraga/freestore/pkg/comm"
-	"github.com/mateusbraga/freestore/pkg/consensus"
 	"github.com/mateusbraga/freestore/pkg/view"
 )
 
@@ -14,22 +13,30 @@ const CONSENSUS_LEADER_PROCESS_POSITION int = 0
 type viewGeneratorInstance struct {
 	AssociatedView *view.View //Id
 	jobChan        chan interface{}
+	// released is closed by releaseViewGenerators to stop the worker
+	released chan struct{}
 }
 
-func (s *Server) getOrCreateViewGenerator(associatedView *view.View, initialSeq ViewSeq) viewGeneratorInstance {
+// getOrCreateViewGenerator returns the view generator of associatedView. It
+// returns false if the view generator was released.
+func (s *Server) getOrCreateViewGenerator(associatedView *view.View, initialSeq ViewSeq) (viewGeneratorInstance, bool) {
 	s.viewGeneratorsMu.Lock()
 	defer s.viewGeneratorsMu.Unlock()
 
 	for _, vgi := range s.viewGenerators {
 		if vgi.AssociatedView.Equal(associatedView) {
-			return vgi
+			return vgi, true
 		}
 	}
+	if s.releasedView != nil && s.releasedView.MoreUpdatedThan(associatedView) {
+		return viewGeneratorInstance{}, false
+	}
 
 	// view generator does not exist. Create it.
 	vgi := viewGeneratorInstance{
 		AssociatedView: associatedView,
 		jobChan:        make(chan interface{}, CHANNEL_DEFAULT_SIZE),
+		released:       make(chan struct{}),
 	}
 	s.viewGenerators = append(s.viewGenerators, vgi)
 
@@ -37,9 +44,9 @@ func (s *Server) getOrCreateViewGenerator(associatedView *view.View, initialSeq
 	if workerSeq == nil {
 		workerSeq = s.getInitialViewSeq()
 	}
-	go s.viewGeneratorWorker(vgi, workerSeq)
+	s.spawn(func() { s.viewGeneratorWorker(vgi, workerSeq) })
 
-	return vgi
+	return vgi, true
 }
 
 func (s *Server) viewGeneratorWorker(vgi viewGeneratorInstance, initialSeq ViewSeq) {
@@ -65,7 +72,7 @@ func (s *Server) viewGeneratorWorker(vgi viewGeneratorInstance, initialSeq ViewS
 			seqConvMsg.Seq = newConvergedSeq
 
 			// Send seq-conv to all
-			go broadcastViewSequenceConv(associatedView, seqConvMsg)
+			go s.broadcastViewSequenceConv(associatedView, seqConvMsg)
 		}
 	}
 
@@ -77,14 +84,21 @@ func (s *Server) viewGeneratorWorker(vgi viewGeneratorInstance, initialSeq ViewS
 		viewSeqMsg.AssociatedView = associatedView
 		viewSeqMsg.Sender = s.thisProcess
 
-		go broadcastViewSequence(associatedView, viewSeqMsg)
+		go s.broadcastViewSequence(associatedView, viewSeqMsg)
 		countViewSeq(viewSeqMsg)
 
 		lastProposedSeq = initialSeq
 	}
 
 	for {
-		job := <-jobChan
+		var job interface{}
+		select {
+		case job = <-jobChan:
+		case <-vgi.released:
+			return
+		case <-s.done:
+			return
+		}
 		switch jobPointer := job.(type) {
 		case ViewSeqMsg:
 			receivedViewSeqMsg := jobPointer
@@ -141,7 +155,7 @@ func (s *Server) viewGeneratorWorker(vgi viewGeneratorInstance, initialSeq ViewS
 				viewSeqMsg.ProposedSeq = newProposeSeq
 				viewSeqMsg.LastConvergedSeq = lastConvergedSeq
 
-				go broadcastViewSequence(associatedView, viewSeqMsg)
+				go s.broadcastViewSequence(associatedView, viewSeqMsg)
 				countViewSeq(viewSeqMsg)
 
 				lastProposedSeq = newProposeSeq
@@ -156,10 +170,16 @@ func (s *Server) viewGeneratorWorker(vgi viewGeneratorInstance, initialSeq ViewS
 
 			// Quorum check
 			if seqConvQuorumCounter.count(receivedSeqConvMsg, associatedView.QuorumSize()) {
-				s.generatedViewSeqChan <- generatedViewSeq{ViewSeq: receivedSeqConvMsg.Seq, AssociatedView: associatedView}
+				select {
+				case s.generatedViewSeqChan <- generatedViewSeq{ViewSeq: receivedSeqConvMsg.Seq, AssociatedView: associatedView}:
+				case <-vgi.released:
+					return
+				case <-s.done:
+					return
+				}
 			}
 		default:
-			log.Fatalln("Something is wrong with the switch statement")
+			log.Fatalln("FATAL: Something is wrong with the switch statement")
 		}
 
 	}
@@ -169,7 +189,7 @@ func (s *Server) viewGeneratorWorker(vgi viewGeneratorInstance, initialSeq ViewS
 func assertOnlyUpdatedViews(baseView *view.View, seq ViewSeq) {
 	for _, loopView := range seq {
 		if loopView.LessUpdatedThan(baseView) {
-			log.Fatalf("BUG! Found an old view in view sequence %v: %v\n", seq, loopView)
+			log.Fatalf("FATAL: BUG! Found an old view in view sequence %v: %v\n", seq, loopView)
 		}
 	}
 }
@@ -178,17 +198,49 @@ func assertOnlyUpdatedViews(baseView *view.View, seq ViewSeq) {
 func (s *Server) generateViewSequenceWithoutConsensus(associatedView *view.View, seq ViewSeq) {
 	assertOnlyUpdatedViews(associatedView, seq)
 
-	_ = s.getOrCreateViewGenerator(associatedView, seq)
+	_, _ = s.getOrCreateViewGenerator(associatedView, seq)
 }
 
+// generateViewSequenceWithConsensus agrees with the other members of
+// associatedView on the next view sequence. The leader proposes seq; the
+// others wait for the result and take over if the leader becomes suspected.
+// A proposal that fails is retried after a random back-off.
 func (s *Server) generateViewSequenceWithConsensus(associatedView *view.View, seq ViewSeq) {
 	assertOnlyUpdatedViews(associatedView, seq)
 
-	if associatedView.GetProcessPosition(s.thisProcess) == CONSENSUS_LEADER_PROCESS_POSITION {
-		consensus.Propose(associatedView, s.thisProcess, &seq)
+	detector, _ := s.transport.(comm.FailureDetector)
+	resultChan := s.consensus.GetConsensusResultChan(associatedView)
+	retryPeriod := consensusRetryPeriod
+
+	var value interface{}
+	for value == nil {
+		if s.installedViewNewerThan(associatedView) {
+			// the result was delivered to another goroutine, or the server moved on without it
+			return
+		}
+
+		if leader := s.consensusLeader(associatedView, detector); leader == s.thisProcess {
+			// a hung acceptor must not keep the leader from checking the result and proposing again
+			ctx, cancel := s.contextUntilDone(retryPeriod)
+			if err := s.consensus.Propose(ctx, associatedView, s.thisProcess, &seq); err != nil {
+				log.Println("WARN: failed to propose view sequence:", err)
+			}
+			cancel()
+		} else {
+			log.Printf("Waiting for consensus resolution by leader %v\n", leader)
+		}
+
+		timer := time.NewTimer(jitter(retryPeriod))
+		select {
+		case value = <-resultChan:
+		case <-timer.C:
+			retryPeriod = nextConsensusRetryPeriod(retryPeriod)
+		case <-s.done:
+			timer.Stop()
+			return
+		}
+		timer.Stop()
 	}
-	log.Println("Waiting for consensus resolution")
-	value := <-consensus.GetConsensusResultChan(associatedView)
 
 	// get startReconfigurationTime to compute reconfiguration duration
 	//if startReconfigurationTime.IsZero() || startReconfigurationTime.Sub(time.Now()) > 20*time.Second {
@@ -202,9 +254,9 @@ func (s *Server) generateViewSequenceWithConsensus(associatedView *view.View, se
 	}
 	log.Println("Consensus result received")
 
-	s.generatedViewSeqChan <- generatedViewSeq{
-		AssociatedView: associatedView,
-		ViewSeq:        *result,
+	select {
+	case s.generatedViewSeqChan <- generatedViewSeq{AssociatedView: associatedView, ViewSeq: *result}:
+	case <-s.done:
 	}
 }
 
@@ -297,30 +349,59 @@ func (thisViewSeqMsg ViewSeqMsg) SameButDifferentSender(otherViewSeqMsg ViewSeqM
 	return true
 }
 
-type ViewGeneratorRequest int
+// ViewGeneratorRequest is the RPC service servers use to generate view sequences.
+type ViewGeneratorRequest struct {
+	server *Server
+}
 
 func (r *ViewGeneratorRequest) ProposeSeqView(arg ViewSeqMsg, reply *struct{}) error {
-	vgi := globalServer.getOrCreateViewGenerator(arg.AssociatedView, nil)
-	vgi.jobChan <- arg
+	if !r.server.track() {
+		return ErrServerClosed
+	}
+	defer r.server.untrack()
 
-	return nil
+	vgi, ok := r.server.getOrCreateViewGenerator(arg.AssociatedView, nil)
+	if !ok {
+		// the view generator of an old view was released, do not start it again
+		return nil
+	}
+	select {
+	case vgi.jobChan <- arg:
+		return nil
+	case <-vgi.released:
+		return nil
+	case <-r.server.done:
+		return ErrServerClosed
+	}
 }
 
 func (r *ViewGeneratorRequest) SeqConv(arg SeqConvMsg, reply *struct{}) error {
-	vgi := globalServer.getOrCreateViewGenerator(arg.AssociatedView, nil)
-	vgi.jobChan <- &arg.SeqConv
+	if !r.server.track() {
+		return ErrServerClosed
+	}
+	defer r.server.untrack()
 
-	return nil
+	vgi, ok := r.server.getOrCreateViewGenerator(arg.AssociatedView, nil)
+	if !ok {
+		// the view generator of an old view was released, do not start it again
+		return nil
+	}
+	select {
+	case vgi.jobChan <- &arg.SeqConv:
+		return nil
+	case <-vgi.released:
+		return nil
+	case <-r.server.done:
+		return ErrServerClosed
+	}
 }
 
-func init() { rpc.Register(new(ViewGeneratorRequest)) }
-
 // -------- Broadcast functions -----------
-func broadcastViewSequence(destinationView *view.View, viewSeqMsg ViewSeqMsg) {
-	destinationViewWithoutThisProcess := destinationView.NewCopyWithUpdates(view.Update{Type: view.Leave, Process: globalServer.thisProcess})
-	comm.BroadcastRPCRequest(destinationViewWithoutThisProcess, "ViewGeneratorRequest.ProposeSeqView", viewSeqMsg)
+func (s *Server) broadcastViewSequence(destinationView *view.View, viewSeqMsg ViewSeqMsg) {
+	destinationViewWithoutThisProcess := destinationView.NewCopyWithUpdates(view.Update{Type: view.Leave, Process: s.thisProcess})
+	comm.Broadcast(s.transport, destinationViewWithoutThisProcess, "ViewGeneratorRequest.ProposeSeqView", viewSeqMsg)
 }
 
-func broadcastViewSequenceConv(destinationView *view.View, seqConvMsg SeqConvMsg) {
-	comm.BroadcastRPCRequest(destinationView, "ViewGeneratorRequest.SeqConv", seqConvMsg)
+func (s *Server) broadcastViewSequenceConv(destinationView *view.View, seqConvMsg SeqConvMsg) {
+	comm.Broadcast(s.transport, destinationView, "ViewGeneratorRequest.SeqConv", seqConvMsg)
 }
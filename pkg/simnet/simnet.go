@@ -0,0 +1,450 @@
+// Package simnet implements a simulated network for testing freestore's protocols.
+//
+// A Network connects many processes running in the same Go process, like
+// comm.MemoryTransport, but it can drop, delay and duplicate the messages
+// between specific processes, and partition them. The faults are chosen by a
+// pseudo-random source seeded by the test.
+//
+// The messages are delivered from an event queue ordered by a virtual clock.
+// A message sent at virtual time t with delay d is due at t+d, and the
+// messages are delivered, or dropped, one at a time in the order they are
+// due. Messages due at the same time are delivered in the order of their
+// links, and then in the order they were sent on their link. Each link has
+// its own source, derived from the seed and the link's processes, so the
+// n-th message sent on a link always gets the same fault and delay however
+// the goroutines of the other links are scheduled. Given the same messages,
+// a seed fully determines the order of the drops, delays, duplicates and
+// deliveries, which Events reports.
+//
+// The virtual clock advances at the pace of real time while messages are in
+// flight, so that the timeouts of the processes keep their meaning, and it
+// stands still while the network is idle.
+package simnet
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/comm"
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+// Fault is what the network does to a message.
+type Fault int
+
+const (
+	// Deliver delivers the request and its answer.
+	Deliver Fault = iota
+	// Drop loses the request. The sender gets an UnreachableError.
+	Drop
+	// DropReply delivers the request but loses its answer. The sender gets an UnreachableError.
+	DropReply
+	// Duplicate delivers the request twice. The sender gets the first answer.
+	Duplicate
+)
+
+func (f Fault) String() string {
+	switch f {
+	case Deliver:
+		return "deliver"
+	case Drop:
+		return "drop"
+	case DropReply:
+		return "drop-reply"
+	case Duplicate:
+		return "duplicate"
+	}
+	return "unknown"
+}
+
+// Rule describes the faults of the messages that match it. The zero From,
+// To and Method match any process and method. Method matches either a
+// service method, as "RegisterService.Read", or a whole service, as
+// "RegisterService".
+type Rule struct {
+	From   view.Process
+	To     view.Process
+	Method string
+
+	// Probabilities of each fault, their sum must not be over 1.
+	Drop      float64
+	DropReply float64
+	Duplicate float64
+
+	// Each message is delayed by a duration between MinDelay and MaxDelay,
+	// which reorders the messages sent at about the same time.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+}
+
+func (r Rule) matches(from, to view.Process, serviceMethod string) bool {
+	if r.From != (view.Process{}) && r.From != from {
+		return false
+	}
+	if r.To != (view.Process{}) && r.To != to {
+		return false
+	}
+	if r.Method != "" && r.Method != serviceMethod && !strings.HasPrefix(serviceMethod, r.Method+".") {
+		return false
+	}
+	return true
+}
+
+// Event is a message that went through the network.
+type Event struct {
+	From   view.Process
+	To     view.Process
+	Method string
+	Fault  Fault
+	Delay  time.Duration
+	// At is the virtual time the message was delivered or dropped.
+	At time.Duration
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("%v: %v -> %v %v: %v after %v", e.At, e.From, e.To, e.Method, e.Fault, e.Delay)
+}
+
+type link struct {
+	from view.Process
+	to   view.Process
+}
+
+// message is a message waiting in the event queue.
+type message struct {
+	event Event
+	// due is the virtual time the message is delivered at.
+	due time.Duration
+	// sent is the number of messages sent on the link before this one.
+	sent  int
+	ready chan struct{}
+	index int
+}
+
+// before reports whether m is delivered before other.
+func (m *message) before(other *message) bool {
+	switch {
+	case m.due != other.due:
+		return m.due < other.due
+	case m.event.From.Addr != other.event.From.Addr:
+		return m.event.From.Addr < other.event.From.Addr
+	case m.event.To.Addr != other.event.To.Addr:
+		return m.event.To.Addr < other.event.To.Addr
+	}
+	return m.sent < other.sent
+}
+
+// queue is the event queue of a Network, a heap of the messages in flight.
+type queue []*message
+
+func (q queue) Len() int           { return len(q) }
+func (q queue) Less(i, j int) bool { return q[i].before(q[j]) }
+
+func (q queue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *queue) Push(x interface{}) {
+	m := x.(*message)
+	m.index = len(*q)
+	*q = append(*q, m)
+}
+
+func (q *queue) Pop() interface{} {
+	old := *q
+	m := old[len(old)-1]
+	old[len(old)-1] = nil
+	m.index = -1
+	*q = old[:len(old)-1]
+	return m
+}
+
+// Network is a simulated network. Its zero value is not usable, see New.
+type Network struct {
+	seed      int64
+	transport *comm.MemoryTransport
+
+	mu        sync.Mutex
+	rules     []Rule
+	scripts   map[link][]Fault
+	sources   map[link]*rand.Rand
+	sent      map[link]int
+	partition map[view.Process]int
+	isolated  map[view.Process]bool
+	events    []Event
+
+	// clock is the virtual time of the last delivery, at lastDelivery in real time.
+	clock        time.Duration
+	lastDelivery time.Time
+	queue        queue
+	dispatching  bool
+	wake         chan struct{}
+}
+
+// New returns a Network with no faults, whose faults are chosen with seed.
+func New(seed int64) *Network {
+	return &Network{
+		seed:      seed,
+		transport: comm.NewMemoryTransport(),
+		scripts:   make(map[link][]Fault),
+		sources:   make(map[link]*rand.Rand),
+		sent:      make(map[link]int),
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Seed returns the seed of the network.
+func (n *Network) Seed() int64 {
+	return n.seed
+}
+
+// AddRule adds a rule to the network. When many rules match a message, the last one added is used.
+func (n *Network) AddRule(rule Rule) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.rules = append(n.rules, rule)
+}
+
+// ClearRules removes all the rules and scripts of the network.
+func (n *Network) ClearRules() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.rules = nil
+	n.scripts = make(map[link][]Fault)
+}
+
+// Script sets the faults of the next messages sent from one process to
+// another, in order. The rules apply again once the faults run out.
+func (n *Network) Script(from, to view.Process, faults ...Fault) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	l := link{from, to}
+	n.scripts[l] = append(n.scripts[l], faults...)
+}
+
+// Partition splits the processes in groups that cannot talk to each other.
+// The processes that are not in any group, such as clients, can still talk
+// to everyone. It replaces the previous partition.
+func (n *Network) Partition(groups ...[]view.Process) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.partition = make(map[view.Process]int)
+	for i, group := range groups {
+		for _, process := range group {
+			n.partition[process] = i
+		}
+	}
+}
+
+// Isolate cuts the given processes from all the others, clients included.
+// The isolated processes can still talk to each other.
+func (n *Network) Isolate(processes ...view.Process) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.isolated = make(map[view.Process]bool)
+	for _, process := range processes {
+		n.isolated[process] = true
+	}
+}
+
+// Heal removes the partitions and isolations of the network.
+func (n *Network) Heal() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.partition = nil
+	n.isolated = nil
+}
+
+// Events returns the messages that went through the network so far, in the order they were delivered or dropped.
+func (n *Network) Events() []Event {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]Event(nil), n.events...)
+}
+
+// Transport returns the transport of process: the messages it sends come from process, and it listens at process.
+func (n *Network) Transport(process view.Process) comm.Transport {
+	return &endpoint{network: n, process: process}
+}
+
+func (n *Network) connected(from, to view.Process) bool {
+	if n.isolated[from] != n.isolated[to] {
+		return false
+	}
+
+	fromGroup, fromOk := n.partition[from]
+	toGroup, toOk := n.partition[to]
+	return !fromOk || !toOk || fromGroup == toGroup
+}
+
+// schedule decides what happens to a message from one process to another, and puts it in the event queue.
+func (n *Network) schedule(from, to view.Process, serviceMethod string) *message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	event := Event{From: from, To: to, Method: serviceMethod}
+
+	l := link{from, to}
+	source, ok := n.sources[l]
+	if !ok {
+		hash := fnv.New64a()
+		fmt.Fprintf(hash, "%v|%v", from, to)
+		source = rand.New(rand.NewSource(n.seed ^ int64(hash.Sum64())))
+		n.sources[l] = source
+	}
+	// always draw the same amount from source, so a script or rule does not shift the faults of the later messages
+	faultDraw := source.Float64()
+	delayDraw := source.Float64()
+
+	switch {
+	case !n.connected(from, to):
+		event.Fault = Drop
+	case len(n.scripts[l]) != 0:
+		event.Fault = n.scripts[l][0]
+		n.scripts[l] = n.scripts[l][1:]
+	default:
+		for i := len(n.rules) - 1; i >= 0; i-- {
+			rule := n.rules[i]
+			if !rule.matches(from, to, serviceMethod) {
+				continue
+			}
+
+			switch {
+			case faultDraw < rule.Drop:
+				event.Fault = Drop
+			case faultDraw < rule.Drop+rule.DropReply:
+				event.Fault = DropReply
+			case faultDraw < rule.Drop+rule.DropReply+rule.Duplicate:
+				event.Fault = Duplicate
+			}
+			event.Delay = rule.MinDelay + time.Duration(delayDraw*float64(rule.MaxDelay-rule.MinDelay))
+			break
+		}
+	}
+
+	m := &message{event: event, due: n.clock + event.Delay, sent: n.sent[l], ready: make(chan struct{})}
+	n.sent[l]++
+
+	if len(n.queue) == 0 {
+		// the clock stood still while the network was idle
+		n.lastDelivery = time.Now()
+	}
+	heap.Push(&n.queue, m)
+	n.notify()
+	if !n.dispatching {
+		n.dispatching = true
+		go n.dispatch()
+	}
+	return m
+}
+
+// cancel removes m from the event queue if it was not delivered yet.
+func (n *Network) cancel(m *message) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if m.index >= 0 {
+		heap.Remove(&n.queue, m.index)
+		n.notify()
+	}
+}
+
+// notify wakes dispatch up after the event queue changed. n.mu must be held.
+func (n *Network) notify() {
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch delivers the messages of the event queue in order, until it is empty.
+func (n *Network) dispatch() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for len(n.queue) != 0 {
+		next := n.queue[0]
+		if wait := next.due - n.clock - time.Since(n.lastDelivery); wait > 0 {
+			n.mu.Unlock()
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-n.wake:
+				timer.Stop()
+			}
+			n.mu.Lock()
+			continue
+		}
+
+		heap.Pop(&n.queue)
+		n.clock = next.due
+		n.lastDelivery = time.Now()
+		next.event.At = next.due
+		n.events = append(n.events, next.event)
+		close(next.ready)
+	}
+	n.dispatching = false
+}
+
+// endpoint is the transport of a process of a Network.
+type endpoint struct {
+	network *Network
+	process view.Process
+}
+
+var _ comm.Transport = new(endpoint)
+
+// Listen listens at the endpoint's process. addr must be its address, empty or end with port 0.
+func (e *endpoint) Listen(addr string) (net.Listener, error) {
+	if addr != "" && !strings.HasSuffix(addr, ":0") && addr != e.process.Addr {
+		return nil, fmt.Errorf("listen simnet %v: transport belongs to %v", addr, e.process)
+	}
+	return e.network.transport.Listen(e.process.Addr)
+}
+
+func (e *endpoint) Call(ctx context.Context, process view.Process, serviceMethod string, arg interface{}, result interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return comm.CanceledError{Err: err}
+	}
+
+	m := e.network.schedule(e.process, process, serviceMethod)
+	select {
+	case <-m.ready:
+	case <-ctx.Done():
+		e.network.cancel(m)
+		return comm.CanceledError{Err: ctx.Err()}
+	}
+
+	switch m.event.Fault {
+	case Drop:
+		return comm.UnreachableError{Process: process, Method: serviceMethod, Cause: "request dropped by simnet"}
+	case DropReply:
+		if err := e.network.transport.Call(ctx, process, serviceMethod, arg, result); err != nil {
+			return err
+		}
+		return comm.UnreachableError{Process: process, Method: serviceMethod, Cause: "reply dropped by simnet"}
+	case Duplicate:
+		err := e.network.transport.Call(ctx, process, serviceMethod, arg, result)
+		duplicateResult := reflect.New(reflect.TypeOf(result).Elem()).Interface()
+		e.network.transport.Call(ctx, process, serviceMethod, arg, duplicateResult)
+		return err
+	}
+	return e.network.transport.Call(ctx, process, serviceMethod, arg, result)
+}
@@ -0,0 +1,209 @@
+package simnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/rpc"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mateusbraga/freestore/pkg/comm"
+	"github.com/mateusbraga/freestore/pkg/view"
+)
+
+type counterService struct {
+	calls int32
+}
+
+func (s *counterService) Inc(arg int, reply *int) error {
+	*reply = int(atomic.AddInt32(&s.calls, 1))
+	return nil
+}
+
+func startCounter(t *testing.T, network *Network, process view.Process) *counterService {
+	listener, err := network.Transport(process).Listen(process.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := new(counterService)
+	rpcServer := rpc.NewServer()
+	rpcServer.RegisterName("Counter", service)
+	go rpcServer.Accept(listener)
+
+	return service
+}
+
+var (
+	client = view.Process{Addr: "client"}
+	server = view.Process{Addr: "server"}
+)
+
+func TestScript(t *testing.T) {
+	network := New(1)
+	service := startCounter(t, network, server)
+	transport := network.Transport(client)
+
+	network.Script(client, server, Drop, DropReply, Duplicate, Deliver)
+
+	var reply int
+	if err := transport.Call(context.Background(), server, "Counter.Inc", 0, &reply); !errors.Is(err, comm.ErrUnreachable) {
+		t.Errorf("expected dropped request, got %v", err)
+	}
+	if calls := atomic.LoadInt32(&service.calls); calls != 0 {
+		t.Errorf("dropped request reached the service, calls %v", calls)
+	}
+
+	if err := transport.Call(context.Background(), server, "Counter.Inc", 0, &reply); !errors.Is(err, comm.ErrUnreachable) {
+		t.Errorf("expected dropped reply, got %v", err)
+	}
+	if calls := atomic.LoadInt32(&service.calls); calls != 1 {
+		t.Errorf("request with dropped reply should reach the service, calls %v", calls)
+	}
+
+	if err := transport.Call(context.Background(), server, "Counter.Inc", 0, &reply); err != nil || reply != 2 {
+		t.Errorf("expected first answer 2 of the duplicate, got %v and %v", reply, err)
+	}
+	if calls := atomic.LoadInt32(&service.calls); calls != 3 {
+		t.Errorf("duplicate should reach the service twice, calls %v", calls)
+	}
+
+	if err := transport.Call(context.Background(), server, "Counter.Inc", 0, &reply); err != nil || reply != 4 {
+		t.Errorf("expected 4 and no error, got %v and %v", reply, err)
+	}
+
+	events := network.Events()
+	if len(events) != 4 || events[0].Fault != Drop || events[3].Fault != Deliver || events[0].Method != "Counter.Inc" {
+		t.Errorf("unexpected events %v", events)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	network := New(1)
+	startCounter(t, network, server)
+	other := view.Process{Addr: "other"}
+	startCounter(t, network, other)
+	transport := network.Transport(client)
+
+	var reply int
+	network.Partition([]view.Process{server}, []view.Process{other})
+	if err := network.Transport(other).Call(context.Background(), server, "Counter.Inc", 0, &reply); !errors.Is(err, comm.ErrUnreachable) {
+		t.Errorf("expected partitioned processes not to talk, got %v", err)
+	}
+	if err := transport.Call(context.Background(), server, "Counter.Inc", 0, &reply); err != nil {
+		t.Errorf("client out of the partition should reach everyone, got %v", err)
+	}
+
+	network.Isolate(server)
+	if err := transport.Call(context.Background(), server, "Counter.Inc", 0, &reply); !errors.Is(err, comm.ErrUnreachable) {
+		t.Errorf("expected isolated process to be unreachable, got %v", err)
+	}
+
+	network.Heal()
+	if err := network.Transport(other).Call(context.Background(), server, "Counter.Inc", 0, &reply); err != nil {
+		t.Errorf("expected healed network to deliver, got %v", err)
+	}
+}
+
+func TestRules(t *testing.T) {
+	network := New(1)
+	startCounter(t, network, server)
+	transport := network.Transport(client)
+
+	network.AddRule(Rule{Drop: 1})
+	network.AddRule(Rule{Method: "Counter.Inc", MinDelay: 20 * time.Millisecond, MaxDelay: 20 * time.Millisecond})
+
+	var reply int
+	start := time.Now()
+	if err := transport.Call(context.Background(), server, "Counter.Inc", 0, &reply); err != nil {
+		t.Errorf("expected the last matching rule to apply, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("expected a delay of 20ms, got %v", elapsed)
+	}
+
+	if err := transport.Call(context.Background(), server, "Other.Method", 0, &reply); !errors.Is(err, comm.ErrUnreachable) {
+		t.Errorf("expected the first rule to drop other methods, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if err := transport.Call(ctx, server, "Counter.Inc", 0, &reply); !errors.Is(err, comm.ErrCanceled) {
+		t.Errorf("expected the delay to respect the context, got %v", err)
+	}
+}
+
+func TestSameSeedSameFaults(t *testing.T) {
+	faults := func(seed int64) []Fault {
+		network := New(seed)
+		startCounter(t, network, server)
+		network.AddRule(Rule{Drop: 0.3, DropReply: 0.2, Duplicate: 0.2})
+
+		transport := network.Transport(client)
+		for i := 0; i < 50; i++ {
+			var reply int
+			transport.Call(context.Background(), server, "Counter.Inc", 0, &reply)
+		}
+
+		var faults []Fault
+		for _, event := range network.Events() {
+			faults = append(faults, event.Fault)
+		}
+		return faults
+	}
+
+	first, second, other := faults(42), faults(42), faults(43)
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("seed 42 gave different faults: %v and %v", first, second)
+		}
+	}
+
+	different := false
+	for i := range first {
+		if first[i] != other[i] {
+			different = true
+		}
+	}
+	if !different {
+		t.Errorf("seeds 42 and 43 gave the same faults: %v", first)
+	}
+}
+
+func TestSameSeedSameOrder(t *testing.T) {
+	order := func(seed int64) []Event {
+		network := New(seed)
+		startCounter(t, network, server)
+		network.AddRule(Rule{Drop: 0.2, Duplicate: 0.2, MinDelay: 100 * time.Millisecond, MaxDelay: 200 * time.Millisecond})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(process view.Process) {
+				defer wg.Done()
+				var reply int
+				network.Transport(process).Call(context.Background(), server, "Counter.Inc", 0, &reply)
+			}(view.Process{Addr: fmt.Sprintf("client:%v", i)})
+		}
+		wg.Wait()
+
+		return network.Events()
+	}
+
+	first, second, other := order(42), order(42), order(43)
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("seed 42 gave different orders:\n%v\n%v", first, second)
+	}
+	if reflect.DeepEqual(first, other) {
+		t.Errorf("seeds 42 and 43 gave the same order: %v", first)
+	}
+	for i := 1; i < len(first); i++ {
+		if first[i].At < first[i-1].At {
+			t.Errorf("event %v delivered before %v", first[i], first[i-1])
+		}
+	}
+}
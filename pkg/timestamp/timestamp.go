@@ -0,0 +1,37 @@
+// Package timestamp implements the timestamps that order the values written to freestore's registers.
+package timestamp
+
+import (
+	"fmt"
+)
+
+// Timestamp orders the values of a register. Timestamps are totally ordered
+// by Counter, then by WriterID and then by Seq. A writer numbers each of its
+// writes with a different Seq, so no two writes produce the same timestamp for
+// different values, even when they are made concurrently by the same writer.
+// The zero Timestamp is older than any timestamp created by Next.
+type Timestamp struct {
+	Counter  int
+	WriterID string
+	Seq      uint64
+}
+
+// Next returns the timestamp of the write seq of writerID over a value with timestamp t.
+func (t Timestamp) Next(writerID string, seq uint64) Timestamp {
+	return Timestamp{Counter: t.Counter + 1, WriterID: writerID, Seq: seq}
+}
+
+// Less returns true if t is older than other.
+func (t Timestamp) Less(other Timestamp) bool {
+	if t.Counter != other.Counter {
+		return t.Counter < other.Counter
+	}
+	if t.WriterID != other.WriterID {
+		return t.WriterID < other.WriterID
+	}
+	return t.Seq < other.Seq
+}
+
+func (t Timestamp) String() string {
+	return fmt.Sprintf("%d.%s.%d", t.Counter, t.WriterID, t.Seq)
+}
@@ -0,0 +1,34 @@
+package timestamp
+
+import (
+	"testing"
+)
+
+func TestTimestampOrder(t *testing.T) {
+	var zero Timestamp
+
+	a1 := zero.Next("a", 1)
+	b1 := zero.Next("b", 1)
+	a2 := b1.Next("a", 2)
+
+	if !zero.Less(a1) || !zero.Less(b1) {
+		t.Errorf("zero timestamp should be older than %v and %v", a1, b1)
+	}
+	if a1 == b1 {
+		t.Errorf("writers a and b created the same timestamp %v", a1)
+	}
+	if a1.Less(b1) == b1.Less(a1) {
+		t.Errorf("%v and %v should be ordered", a1, b1)
+	}
+	if !b1.Less(a2) || a2.Less(b1) {
+		t.Errorf("%v should be older than %v", b1, a2)
+	}
+	// concurrent writes of the same writer over the same value
+	a1bis := zero.Next("a", 2)
+	if a1 == a1bis || a1.Less(a1bis) == a1bis.Less(a1) {
+		t.Errorf("%v and %v should be different and ordered", a1, a1bis)
+	}
+	if a1.Less(a1) {
+		t.Errorf("%v should not be older than itself", a1)
+	}
+}
@@ -7,9 +7,9 @@ import (
 )
 
 func TestViewRef(t *testing.T) {
-	updates := []Update{Update{Type: Join, Process: Process{"10.1.1.2:5000"}},
-		Update{Type: Join, Process: Process{"10.1.1.3:5000"}},
-		Update{Type: Join, Process: Process{"10.1.1.4:5000"}},
+	updates := []Update{Update{Type: Join, Process: Process{Addr: "10.1.1.2:5000"}},
+		Update{Type: Join, Process: Process{Addr: "10.1.1.3:5000"}},
+		Update{Type: Join, Process: Process{Addr: "10.1.1.4:5000"}},
 	}
 
 	v1 := NewWithUpdates(updates...)
@@ -19,8 +19,8 @@ func TestViewRef(t *testing.T) {
 		t.Errorf("v1 and v2 should have same ViewRef\n")
 	}
 
-	v3 := v1.NewCopyWithUpdates(Update{Join, Process{"3"}})
-	v5 := v1.NewCopyWithUpdates(Update{Join, Process{"3"}})
+	v3 := v1.NewCopyWithUpdates(Update{Join, Process{Addr: "3"}})
+	v5 := v1.NewCopyWithUpdates(Update{Join, Process{Addr: "3"}})
 
 	if ViewToViewRef(v3) == ViewToViewRef(v1) {
 		t.Errorf("v1 and v3 should have different ViewRefs\n")
@@ -51,9 +51,9 @@ func TestViewRef(t *testing.T) {
 }
 
 func BenchmarkViewToViewRef(b *testing.B) {
-	updates := []Update{Update{Type: Join, Process: Process{"10.1.1.2:5000"}},
-		Update{Type: Join, Process: Process{"10.1.1.3:5000"}},
-		Update{Type: Join, Process: Process{"10.1.1.4:5000"}},
+	updates := []Update{Update{Type: Join, Process: Process{Addr: "10.1.1.2:5000"}},
+		Update{Type: Join, Process: Process{Addr: "10.1.1.3:5000"}},
+		Update{Type: Join, Process: Process{Addr: "10.1.1.4:5000"}},
 	}
 	v1 := NewWithUpdates(updates...)
 
@@ -4,7 +4,9 @@ package view
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"net"
 )
 
 // View represents a server's view of the members of the distributed system. A View once created is immutable, for a mutable View, see type CurrentView.
@@ -41,7 +43,7 @@ func (v *View) NewCopyWithUpdates(updates ...Update) *View {
 	for update, _ := range v.Entries {
 		newViewUpdates = append(newViewUpdates, update)
 	}
-    newViewUpdates = append(newViewUpdates, updates...)
+	newViewUpdates = append(newViewUpdates, updates...)
 
 	return NewWithUpdates(newViewUpdates...)
 }
@@ -49,14 +51,23 @@ func (v *View) NewCopyWithUpdates(updates ...Update) *View {
 func (v *View) addUpdate(updates ...Update) {
 	for _, newUpdate := range updates {
 		v.Entries[newUpdate] = true
+	}
 
-		switch newUpdate.Type {
-		case Join:
-			if !v.Entries[Update{Leave, newUpdate.Process}] {
-				v.Members[newUpdate.Process] = true
+	// a process is a member if it joined, did not leave, and no later incarnation of its node joined
+	v.Members = make(map[Process]bool)
+	for update, _ := range v.Entries {
+		if update.Type != Join || v.Entries[Update{Leave, update.Process}] {
+			continue
+		}
+		superseded := false
+		for otherUpdate, _ := range v.Entries {
+			if otherUpdate.Type == Join && update.Process.supersededBy(otherUpdate.Process) {
+				superseded = true
+				break
 			}
-		case Leave:
-			delete(v.Members, newUpdate.Process)
+		}
+		if !superseded {
+			v.Members[update.Process] = true
 		}
 	}
 	v.ViewRef = ViewToViewRef(v)
@@ -72,7 +83,7 @@ func (v *View) String() string {
 		if !first {
 			fmt.Fprintf(buf, ", ")
 		}
-		fmt.Fprintf(buf, "%v", process.Addr)
+		fmt.Fprintf(buf, "%v", process)
 		first = false
 	}
 
@@ -133,6 +144,28 @@ func (v *View) HasMember(p Process) bool {
 	return v.Members[p]
 }
 
+// MemberOfNode returns the member of the view that is an incarnation of the node of process.
+func (v *View) MemberOfNode(process Process) (Process, bool) {
+	for member, _ := range v.Members {
+		if member.SameNode(process) {
+			return member, true
+		}
+	}
+	return Process{}, false
+}
+
+// NextIncarnation returns process with an Incarnation higher than the ones
+// of its node in the updates of the view. process is returned as is if its
+// node has no updates.
+func (v *View) NextIncarnation(process Process) Process {
+	for update, _ := range v.Entries {
+		if update.Process.SameNode(process) && update.Process.Incarnation >= process.Incarnation {
+			process.Incarnation = update.Process.Incarnation + 1
+		}
+	}
+	return process
+}
+
 func (v *View) GetUpdates() []Update {
 	var updates []Update
 	for update, _ := range v.Entries {
@@ -169,7 +202,7 @@ func (v *View) GetProcessPosition(process Process) int {
 	// Position will be the position of the process in an ordered list of the members.
 	position := 0
 	for proc, _ := range v.Members {
-		if proc.Addr < process.Addr {
+		if proc.Less(process) {
 			position++
 		}
 	}
@@ -196,6 +229,10 @@ func (v *View) NumberOfToleratedFaults() int {
 
 // ----- ERRORS -----
 
+// ErrViewChanged is matched by OldViewError with errors.Is.
+var ErrViewChanged = errors.New("view changed")
+
+// OldViewError is returned by a server when a request uses a view older than the server's current view, NewView.
 type OldViewError struct {
 	NewView *View
 }
@@ -204,6 +241,8 @@ func (e OldViewError) Error() string {
 	return fmt.Sprintf("Client's current view is old, update to '%v'", e.NewView)
 }
 
+func (e OldViewError) Is(target error) bool { return target == ErrViewChanged }
+
 func init() { gob.Register(new(OldViewError)) }
 
 // ----- Auxiliary types ---------
@@ -215,12 +254,118 @@ const (
 	Leave updateType = "-"
 )
 
+// Process identifies a freestore process. Addr is where the process is
+// reached; ID, if set, names the node of the process independently of its
+// address. The node of a process without ID is its address.
+//
+// Incarnation tells apart the lives of a node: a node that left the system,
+// or lost its state, joins again as a new process with a higher Incarnation.
+// The join of a new incarnation removes the older ones from the view.
 type Process struct {
-	Addr string
+	Addr        string
+	ID          string
+	Incarnation uint64
+}
+
+func (thisProcess Process) String() string {
+	s := thisProcess.Addr
+	if thisProcess.ID != "" {
+		s = thisProcess.ID + "@" + s
+	}
+	if thisProcess.Incarnation != 0 {
+		s = fmt.Sprintf("%v#%v", s, thisProcess.Incarnation)
+	}
+	return s
 }
 
 func (thisProcess Process) Less(otherProcess Process) bool {
-	return thisProcess.Addr < otherProcess.Addr
+	if thisProcess.Addr != otherProcess.Addr {
+		return thisProcess.Addr < otherProcess.Addr
+	}
+	if thisProcess.ID != otherProcess.ID {
+		return thisProcess.ID < otherProcess.ID
+	}
+	return thisProcess.Incarnation < otherProcess.Incarnation
+}
+
+// SameNode tells if both processes are incarnations of the same node.
+func (thisProcess Process) SameNode(otherProcess Process) bool {
+	if thisProcess.ID != "" || otherProcess.ID != "" {
+		return thisProcess.ID == otherProcess.ID
+	}
+	return thisProcess.Addr == otherProcess.Addr
+}
+
+// supersededBy tells if otherProcess is a later incarnation of the node of thisProcess.
+func (thisProcess Process) supersededBy(otherProcess Process) bool {
+	return thisProcess.SameNode(otherProcess) && thisProcess.Incarnation < otherProcess.Incarnation
+}
+
+// HasUnspecifiedHost tells if the address of the process has no host or an
+// unspecified one, such as [::]:5000. Such an address can only be reached
+// from the machine of the process.
+func (thisProcess Process) HasUnspecifiedHost() bool {
+	host, _, err := net.SplitHostPort(thisProcess.Addr)
+	if err != nil {
+		return false
+	}
+	if host == "" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsUnspecified()
+}
+
+// Resolve returns the process with the hostname and the service name of its
+// address replaced by an IP address and a port number, so that processes
+// named by hostname can be compared with processes named by IP address.
+func (thisProcess Process) Resolve() (Process, error) {
+	host, port, err := net.SplitHostPort(thisProcess.Addr)
+	if err != nil {
+		return Process{}, err
+	}
+
+	portNumber, err := net.LookupPort("tcp", port)
+	if err != nil {
+		return Process{}, err
+	}
+
+	if ip := net.ParseIP(host); ip == nil && host != "" {
+		addrs, err := net.LookupHost(host)
+		if err != nil {
+			return Process{}, err
+		}
+		host = addrs[0]
+		// prefer IPv4, as in net.Dial
+		for _, addr := range addrs {
+			if net.ParseIP(addr).To4() != nil {
+				host = addr
+				break
+			}
+		}
+	} else if ip != nil {
+		host = ip.String()
+	}
+
+	return Process{Addr: net.JoinHostPort(host, fmt.Sprint(portNumber))}, nil
+}
+
+// SameAs tells if both processes have the same resolved address. If an
+// address cannot be resolved, the addresses are compared as they are.
+func (thisProcess Process) SameAs(otherProcess Process) bool {
+	if thisProcess == otherProcess {
+		return true
+	}
+
+	resolved, err := thisProcess.Resolve()
+	if err != nil {
+		return false
+	}
+	otherResolved, err := otherProcess.Resolve()
+	if err != nil {
+		return false
+	}
+	return resolved == otherResolved
 }
 
 type Update struct {
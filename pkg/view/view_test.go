@@ -7,10 +7,10 @@ import (
 )
 
 func TestViewEqual(t *testing.T) {
-	updates := []Update{Update{Type: Join, Process: Process{"1"}},
-		Update{Type: Join, Process: Process{"2"}},
-		Update{Type: Join, Process: Process{"3"}},
-		Update{Type: Leave, Process: Process{"1"}},
+	updates := []Update{Update{Type: Join, Process: Process{Addr: "1"}},
+		Update{Type: Join, Process: Process{Addr: "2"}},
+		Update{Type: Join, Process: Process{Addr: "3"}},
+		Update{Type: Leave, Process: Process{Addr: "1"}},
 	}
 
 	v1 := newView()
@@ -32,7 +32,7 @@ func TestViewEqual(t *testing.T) {
 		t.Fatalf("Views v1 and v2 should be equal")
 	}
 
-	v2 = v2.NewCopyWithUpdates(Update{Leave, Process{"2"}})
+	v2 = v2.NewCopyWithUpdates(Update{Leave, Process{Addr: "2"}})
 
 	if v1.Equal(v2) {
 		t.Fatalf("Views v1 and v2 should be different")
@@ -40,16 +40,16 @@ func TestViewEqual(t *testing.T) {
 }
 
 func TestViewGetMembers(t *testing.T) {
-	updates := []Update{Update{Type: Join, Process: Process{"1"}},
-		Update{Type: Join, Process: Process{"2"}},
-		Update{Type: Join, Process: Process{"3"}},
-		Update{Type: Leave, Process: Process{"1"}},
+	updates := []Update{Update{Type: Join, Process: Process{Addr: "1"}},
+		Update{Type: Join, Process: Process{Addr: "2"}},
+		Update{Type: Join, Process: Process{Addr: "3"}},
+		Update{Type: Leave, Process: Process{Addr: "1"}},
 	}
 
 	v1 := NewWithUpdates(updates...)
 
 	processes := v1.GetMembers()
-	processes2 := []Process{Process{"2"}, Process{"3"}}
+	processes2 := []Process{Process{Addr: "2"}, Process{Addr: "3"}}
 
 	if len(processes) != len(processes2) {
 		t.Fatalf("Array of processes should be equal")
@@ -70,7 +70,7 @@ func TestViewGetMembers(t *testing.T) {
 }
 
 func TestViewSize(t *testing.T) {
-	v1 := NewWithUpdates(Update{Type: Join, Process: Process{"1"}})
+	v1 := NewWithUpdates(Update{Type: Join, Process: Process{Addr: "1"}})
 
 	if n := v1.NumberOfMembers(); n != 1 {
 		t.Errorf("v1 should have 1 member, not %d", n)
@@ -84,7 +84,7 @@ func TestViewSize(t *testing.T) {
 		t.Errorf("Number of tolerable failures of 1 process should be 0, not %d", f)
 	}
 
-	v1 = v1.NewCopyWithUpdates(Update{Type: Join, Process: Process{"2"}})
+	v1 = v1.NewCopyWithUpdates(Update{Type: Join, Process: Process{Addr: "2"}})
 
 	if n := v1.NumberOfMembers(); n != 2 {
 		t.Errorf("v1 should have 2 members, not %d", n)
@@ -98,7 +98,7 @@ func TestViewSize(t *testing.T) {
 		t.Errorf("Number of tolerable failures of 2 processes should be 0, not %d", f)
 	}
 
-	v1 = v1.NewCopyWithUpdates(Update{Join, Process{"3"}})
+	v1 = v1.NewCopyWithUpdates(Update{Join, Process{Addr: "3"}})
 
 	if q := v1.QuorumSize(); q != 2 {
 		t.Errorf("Quorum of 3 processes should be 2, not %d", q)
@@ -108,7 +108,7 @@ func TestViewSize(t *testing.T) {
 		t.Errorf("Number of tolerable failures of 3 processes should be 1, not %d", f)
 	}
 
-	v1 = v1.NewCopyWithUpdates(Update{Join, Process{"4"}})
+	v1 = v1.NewCopyWithUpdates(Update{Join, Process{Addr: "4"}})
 
 	if q := v1.QuorumSize(); q != 3 {
 		t.Errorf("Quorum of 4 processes should be 3, not %d", q)
@@ -120,8 +120,8 @@ func TestViewSize(t *testing.T) {
 }
 
 func TestViewLessUpdatedThan(t *testing.T) {
-	updates := []Update{Update{Type: Join, Process: Process{"1"}},
-		Update{Type: Join, Process: Process{"2"}},
+	updates := []Update{Update{Type: Join, Process: Process{Addr: "1"}},
+		Update{Type: Join, Process: Process{Addr: "2"}},
 	}
 
 	v1 := NewWithUpdates(updates...)
@@ -135,7 +135,7 @@ func TestViewLessUpdatedThan(t *testing.T) {
 		t.Errorf("v2 is less updated than v1!")
 	}
 
-	v2 = v2.NewCopyWithUpdates(Update{Join, Process{"3"}})
+	v2 = v2.NewCopyWithUpdates(Update{Join, Process{Addr: "3"}})
 
 	if !v1.LessUpdatedThan(v2) {
 		t.Errorf("v1 is less updated than v2!")
@@ -145,8 +145,8 @@ func TestViewLessUpdatedThan(t *testing.T) {
 		t.Errorf("v2 is less updated than v1!")
 	}
 
-	v1 = v1.NewCopyWithUpdates(Update{Join, Process{"3"}})
-	v2 = v2.NewCopyWithUpdates(Update{Join, Process{"2"}})
+	v1 = v1.NewCopyWithUpdates(Update{Join, Process{Addr: "3"}})
+	v2 = v2.NewCopyWithUpdates(Update{Join, Process{Addr: "2"}})
 
 	if v2.LessUpdatedThan(v1) {
 		t.Errorf("v2 is not less updated than v1!")
@@ -154,8 +154,8 @@ func TestViewLessUpdatedThan(t *testing.T) {
 }
 
 func TestViewMoreUpdatedThan(t *testing.T) {
-	updates := []Update{Update{Type: Join, Process: Process{"1"}},
-		Update{Type: Join, Process: Process{"2"}},
+	updates := []Update{Update{Type: Join, Process: Process{Addr: "1"}},
+		Update{Type: Join, Process: Process{Addr: "2"}},
 	}
 
 	v1 := NewWithUpdates(updates...)
@@ -169,7 +169,7 @@ func TestViewMoreUpdatedThan(t *testing.T) {
 		t.Errorf("v2 is not more updated than v1!")
 	}
 
-	v2 = v2.NewCopyWithUpdates(Update{Join, Process{"3"}})
+	v2 = v2.NewCopyWithUpdates(Update{Join, Process{Addr: "3"}})
 
 	if v1.MoreUpdatedThan(v2) {
 		t.Errorf("v1 is not more updated than v2!")
@@ -179,8 +179,8 @@ func TestViewMoreUpdatedThan(t *testing.T) {
 		t.Errorf("v2 is not more updated than v1!")
 	}
 
-	v1 = v1.NewCopyWithUpdates(Update{Join, Process{"3"}})
-	v2 = v2.NewCopyWithUpdates(Update{Join, Process{"2"}})
+	v1 = v1.NewCopyWithUpdates(Update{Join, Process{Addr: "3"}})
+	v2 = v2.NewCopyWithUpdates(Update{Join, Process{Addr: "2"}})
 
 	if v2.MoreUpdatedThan(v1) {
 		t.Errorf("v2 is not more updated than v1!")
@@ -188,36 +188,36 @@ func TestViewMoreUpdatedThan(t *testing.T) {
 }
 
 func TestGetProcessPosition(t *testing.T) {
-	updates := []Update{Update{Type: Join, Process: Process{"1"}},
-		Update{Type: Join, Process: Process{"2"}},
-		Update{Type: Join, Process: Process{"3"}},
+	updates := []Update{Update{Type: Join, Process: Process{Addr: "1"}},
+		Update{Type: Join, Process: Process{Addr: "2"}},
+		Update{Type: Join, Process: Process{Addr: "3"}},
 	}
 
 	v1 := NewWithUpdates(updates...)
 
-	if position := v1.GetProcessPosition(Process{"1"}); position != 0 {
+	if position := v1.GetProcessPosition(Process{Addr: "1"}); position != 0 {
 		t.Errorf("GetProcessPosition: expected 0, got %v", position)
 	}
 
-	if position := v1.GetProcessPosition(Process{"2"}); position != 1 {
+	if position := v1.GetProcessPosition(Process{Addr: "2"}); position != 1 {
 		t.Errorf("GetProcessPosition: expected 1, got %v", position)
 	}
 
-	v1 = v1.NewCopyWithUpdates(Update{Leave, Process{"1"}})
+	v1 = v1.NewCopyWithUpdates(Update{Leave, Process{Addr: "1"}})
 
-	if position := v1.GetProcessPosition(Process{"1"}); position != -1 {
+	if position := v1.GetProcessPosition(Process{Addr: "1"}); position != -1 {
 		t.Errorf("GetProcessPosition: expected -1, got %v", position)
 	}
 
-	if position := v1.GetProcessPosition(Process{"2"}); position != 0 {
+	if position := v1.GetProcessPosition(Process{Addr: "2"}); position != 0 {
 		t.Errorf("GetProcessPosition: expected 0, got %v", position)
 	}
 }
 
 func TestViewGob(t *testing.T) {
-	updates := []Update{Update{Type: Join, Process: Process{"10.1.1.2:5000"}},
-		Update{Type: Join, Process: Process{"10.1.1.3:5000"}},
-		Update{Type: Join, Process: Process{"10.1.1.4:5000"}},
+	updates := []Update{Update{Type: Join, Process: Process{Addr: "10.1.1.2:5000"}},
+		Update{Type: Join, Process: Process{Addr: "10.1.1.3:5000"}},
+		Update{Type: Join, Process: Process{Addr: "10.1.1.4:5000"}},
 	}
 	v1 := NewWithUpdates(updates...)
 
@@ -242,9 +242,9 @@ func TestViewGob(t *testing.T) {
 }
 
 func BenchmarkViewGobEncode(b *testing.B) {
-	updates := []Update{Update{Type: Join, Process: Process{"10.1.1.2:5000"}},
-		Update{Type: Join, Process: Process{"10.1.1.3:5000"}},
-		Update{Type: Join, Process: Process{"10.1.1.4:5000"}},
+	updates := []Update{Update{Type: Join, Process: Process{Addr: "10.1.1.2:5000"}},
+		Update{Type: Join, Process: Process{Addr: "10.1.1.3:5000"}},
+		Update{Type: Join, Process: Process{Addr: "10.1.1.4:5000"}},
 	}
 	v1 := NewWithUpdates(updates...)
 
@@ -261,9 +261,9 @@ func BenchmarkViewGobEncode(b *testing.B) {
 }
 
 func BenchmarkViewGobDecode(b *testing.B) {
-	updates := []Update{Update{Type: Join, Process: Process{"10.1.1.2:5000"}},
-		Update{Type: Join, Process: Process{"10.1.1.3:5000"}},
-		Update{Type: Join, Process: Process{"10.1.1.4:5000"}},
+	updates := []Update{Update{Type: Join, Process: Process{Addr: "10.1.1.2:5000"}},
+		Update{Type: Join, Process: Process{Addr: "10.1.1.3:5000"}},
+		Update{Type: Join, Process: Process{Addr: "10.1.1.4:5000"}},
 	}
 	v1 := NewWithUpdates(updates...)
 	buf := new(bytes.Buffer)
@@ -287,3 +287,79 @@ func BenchmarkViewGobDecode(b *testing.B) {
 		}
 	}
 }
+
+func TestProcessResolve(t *testing.T) {
+	tests := []struct {
+		process  Process
+		resolved Process
+	}{
+		{Process{Addr: "10.1.1.2:5000"}, Process{Addr: "10.1.1.2:5000"}},
+		{Process{Addr: "[0:0::1]:5000"}, Process{Addr: "[::1]:5000"}},
+		{Process{Addr: "localhost:5000"}, Process{Addr: "127.0.0.1:5000"}},
+	}
+	for _, test := range tests {
+		resolved, err := test.process.Resolve()
+		if err != nil || resolved != test.resolved {
+			t.Errorf("%v resolved to %v and %v, expected %v", test.process, resolved, err, test.resolved)
+		}
+	}
+
+	if !(Process{Addr: "localhost:5000"}).SameAs(Process{Addr: "127.0.0.1:5000"}) {
+		t.Errorf("localhost:5000 should be the same as 127.0.0.1:5000")
+	}
+	if (Process{Addr: "localhost:5000"}).SameAs(Process{Addr: "127.0.0.1:5001"}) {
+		t.Errorf("localhost:5000 should not be the same as 127.0.0.1:5001")
+	}
+
+	if !(Process{Addr: "[::]:5000"}).HasUnspecifiedHost() || !(Process{Addr: ":5000"}).HasUnspecifiedHost() || (Process{Addr: "10.1.1.2:5000"}).HasUnspecifiedHost() {
+		t.Errorf("HasUnspecifiedHost is wrong")
+	}
+}
+
+func TestViewIncarnations(t *testing.T) {
+	first := Process{Addr: "10.1.1.2:5000"}
+	other := Process{Addr: "10.1.1.3:5000"}
+
+	v := NewWithProcesses(first, other)
+	if next := v.NextIncarnation(first); next.Incarnation != 1 || next.Addr != first.Addr {
+		t.Fatalf("expected incarnation 1 of %v, got %v", first, next)
+	}
+	if next := v.NextIncarnation(Process{Addr: "10.1.1.4:5000"}); next.Incarnation != 0 {
+		t.Errorf("a node without updates should keep its incarnation, got %v", next)
+	}
+
+	// the process leaves and comes back on the same address
+	left := v.NewCopyWithUpdates(Update{Leave, first})
+	second := left.NextIncarnation(first)
+	rejoined := left.NewCopyWithUpdates(Update{Join, second})
+	if !rejoined.HasMember(second) || rejoined.HasMember(first) || rejoined.NumberOfMembers() != 2 {
+		t.Errorf("expected %v to rejoin in place of %v, got %v", second, first, rejoined)
+	}
+	if rejoined.ViewRef == v.ViewRef {
+		t.Errorf("views with different incarnations should have different ViewRefs")
+	}
+	if !rejoined.MoreUpdatedThan(left) || !left.MoreUpdatedThan(v) {
+		t.Errorf("each view should be more updated than the previous one")
+	}
+
+	// a new incarnation joins without the old one leaving, as when it lost its state
+	third := rejoined.NextIncarnation(second)
+	replaced := rejoined.NewCopyWithUpdates(Update{Join, third})
+	if member, ok := replaced.MemberOfNode(first); !ok || member != third || replaced.NumberOfMembers() != 2 {
+		t.Errorf("expected %v to replace %v, got %v", third, second, replaced)
+	}
+
+	// the order of the updates does not matter
+	if !NewWithUpdates(Update{Join, third}, Update{Join, first}).Equal(NewWithUpdates(Update{Join, first}, Update{Join, third})) {
+		t.Errorf("views with the same updates should be equal")
+	}
+	if NewWithUpdates(Update{Join, third}, Update{Join, first}).HasMember(first) {
+		t.Errorf("%v should be superseded by %v", first, third)
+	}
+
+	// the node of a process with ID does not depend on its address
+	moved := Process{Addr: "10.1.1.9:5000", ID: "node-1"}
+	if !moved.SameNode(Process{Addr: "10.1.1.2:5000", ID: "node-1", Incarnation: 2}) || moved.SameNode(first) {
+		t.Errorf("SameNode should compare the IDs")
+	}
+}